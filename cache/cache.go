@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/ray-remotestate/restro/metrics"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache stores encoded values by key. Implementations must be safe for
+// concurrent use.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	Set(ctx context.Context, key string, value []byte)
+	Delete(ctx context.Context, keys ...string)
+	// Purge drops every entry, for when invalidations may have been missed.
+	Purge(ctx context.Context)
+}
+
+// Loader reads through a Cache, collapsing concurrent misses for the same key
+// into a single load.
+type Loader struct {
+	name  string
+	cache Cache
+	group singleflight.Group
+	// generation moves on every invalidation; loads that started before one
+	// may have read old rows and are not cached
+	generation atomic.Uint64
+}
+
+func NewLoader(name string, c Cache) *Loader {
+	return &Loader{name: name, cache: c}
+}
+
+// Get returns the cached value of key, or calls load and caches its result.
+// The returned slice is shared and must not be modified.
+func (l *Loader) Get(ctx context.Context, key string, load func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	if value, ok := l.cache.Get(ctx, key); ok {
+		metrics.CacheRequests.WithLabelValues(l.name, "hit").Inc()
+		return value, nil
+	}
+	metrics.CacheRequests.WithLabelValues(l.name, "miss").Inc()
+
+	value, err, _ := l.group.Do(key, func() (interface{}, error) {
+		generation := l.generation.Load()
+		// the shared load must not fail because the first caller went away
+		value, err := load(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if l.generation.Load() == generation {
+			l.cache.Set(ctx, key, value)
+		}
+		return value, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]byte), nil
+}
+
+// Forget drops keys from this instance's cache and lets in-flight loads of
+// them start over.
+func (l *Loader) Forget(ctx context.Context, keys ...string) {
+	l.generation.Add(1)
+	for _, key := range keys {
+		l.group.Forget(key)
+	}
+	l.cache.Delete(ctx, keys...)
+}
+
+// Purge drops every entry of this instance's cache.
+func (l *Loader) Purge(ctx context.Context) {
+	l.generation.Add(1)
+	l.cache.Purge(ctx)
+}
+
+// Catalog caches restaurant and menu reads. main replaces it with one sized
+// from config.
+var Catalog = NewLoader("catalog", NewLRU(1000, 5*time.Minute))
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// InvalidationChannel is the Postgres channel carrying invalidated keys
+// between instances.
+const InvalidationChannel = "restro_cache_invalidation"
+
+// PurgeKey invalidates every key, for changes that affect too many to list.
+const PurgeKey = "*"
+
+const listenerPingInterval = 90 * time.Second
+
+// Listener applies the invalidations published on InvalidationChannel by any
+// instance, this one included, to the local caches.
+type Listener struct {
+	connStr  string
+	loaders  []*Loader
+	listener *pq.Listener
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func NewListener(connStr string, loaders ...*Loader) *Listener {
+	return &Listener{connStr: connStr, loaders: loaders}
+}
+
+func (l *Listener) Start(ctx context.Context) error {
+	l.listener = pq.NewListener(l.connStr, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logrus.WithError(err).Warn("cache invalidation listener")
+		}
+	})
+	if err := l.listener.Listen(InvalidationChannel); err != nil {
+		l.listener.Close()
+		return err
+	}
+
+	ctx, l.cancel = context.WithCancel(ctx)
+	l.done = make(chan struct{})
+	go l.run(ctx)
+	return nil
+}
+
+func (l *Listener) run(ctx context.Context) {
+	defer close(l.done)
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-l.listener.Notify:
+			if n == nil || n.Extra == PurgeKey {
+				// nil means reconnected; anything published meanwhile was lost
+				for _, loader := range l.loaders {
+					loader.Purge(ctx)
+				}
+				continue
+			}
+			for _, loader := range l.loaders {
+				loader.Forget(ctx, n.Extra)
+			}
+		case <-ticker.C:
+			go l.listener.Ping()
+		}
+	}
+}
+
+func (l *Listener) Stop(ctx context.Context) error {
+	l.cancel()
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return l.listener.Close()
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2, time.Minute)
+	c.Set(ctx, "a", []byte("1"))
+	c.Set(ctx, "b", []byte("2"))
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("3"))
+	if _, ok := c.Get(ctx, "b"); ok {
+		t.Error("b was used least recently and should have been evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(ctx, key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+}
+
+func TestLRUNoSize(t *testing.T) {
+	ctx := context.Background()
+	for _, size := range []int{0, -1} {
+		c := NewLRU(size, time.Minute)
+		c.Set(ctx, "a", []byte("1"))
+		if _, ok := c.Get(ctx, "a"); ok {
+			t.Errorf("size %d: kept an entry", size)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/ray-remotestate/restro/config"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/models"
+	"github.com/ray-remotestate/restro/utils"
+)
+
+var errAdminExists = errors.New("an admin already exists")
+
+const usage = `usage:
+  restro                                   start the server
+  restro admin create --email EMAIL --name NAME [--password-stdin]`
+
+// runCommand handles the CLI subcommands and returns the process exit code.
+func runCommand(args []string) int {
+	if len(args) < 2 || args[0] != "admin" || args[1] != "create" {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("admin create", flag.ContinueOnError)
+	email := fs.String("email", "", "email of the admin")
+	name := fs.String("name", "", "name of the admin")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin instead of generating one")
+	if err := fs.Parse(args[2:]); err != nil {
+		return 2
+	}
+	if *email == "" || *name == "" {
+		fmt.Fprintln(os.Stderr, "--email and --name are required")
+		return 2
+	}
+
+	password, generated, err := readOrGeneratePassword(*passwordStdin, os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := database.ConnectAndMigrate(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize database: %v\n", err)
+		return 1
+	}
+	defer database.ShutdownDatabase()
+
+	userID, err := createFirstAdmin(context.Background(), *email, *name, password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create admin: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("admin created: %s <%s> (id %s)\n", *name, *email, userID)
+	if generated {
+		fmt.Printf("generated password: %s\n", password)
+	}
+	return 0
+}
+
+// bootstrapAdminFromEnv creates the configured admin on startup. It is a
+// one-time operation: once any admin exists the request is refused.
+func bootstrapAdminFromEnv(ctx context.Context) {
+	admin := config.BootstrapAdmin
+	if admin.Email == "" {
+		return
+	}
+	if admin.Name == "" || len(admin.Password) < 6 {
+		logrus.Error("admin bootstrap skipped: BOOTSTRAP_ADMIN_NAME and a BOOTSTRAP_ADMIN_PASSWORD of at least 6 characters are required")
+		return
+	}
+
+	userID, err := createFirstAdmin(ctx, admin.Email, admin.Name, admin.Password)
+	if errors.Is(err, errAdminExists) {
+		logrus.Info("admin bootstrap refused: an admin already exists, remove BOOTSTRAP_ADMIN_* from the environment")
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("admin bootstrap failed")
+		return
+	}
+	logrus.WithField("user_id", userID).Info("bootstrap admin created")
+}
+
+func createFirstAdmin(ctx context.Context, email, name, password string) (uuid.UUID, error) {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	var userID uuid.UUID
+	err = database.Tx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if err := dbhelper.LockAdminBootstrap(ctx, tx); err != nil {
+			return err
+		}
+
+		exists, err := dbhelper.AdminExists(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errAdminExists
+		}
+
+		if userID, err = dbhelper.CreateUser(ctx, tx, name, email, hashedPassword); err != nil {
+			return err
+		}
+		if err := dbhelper.AssignRole(ctx, tx, userID, models.RoleAdmin); err != nil {
+			return err
+		}
+
+		after, err := json.Marshal(map[string]string{
+			"name":  name,
+			"email": email,
+			"role":  string(models.RoleAdmin),
+		})
+		if err != nil {
+			return err
+		}
+		return dbhelper.InsertAuditLog(ctx, tx, models.AuditLog{
+			ActorID:      userID,
+			Action:       "admin.bootstrap",
+			ResourceType: "user",
+			ResourceID:   userID.String(),
+			After:        after,
+		})
+	})
+	return userID, err
+}
+
+func readOrGeneratePassword(fromStdin bool, stdin io.Reader) (password string, generated bool, err error) {
+	if fromStdin {
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", false, err
+		}
+		password = strings.TrimRight(line, "\r\n")
+		if len(password) < 6 {
+			return "", false, errors.New("password must be at least 6 characters")
+		}
+		return password, false, nil
+	}
+
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", false, err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), true, nil
+}
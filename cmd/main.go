@@ -1,48 +1,214 @@
 package main
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"errors"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/ray-remotestate/restro/cache"
 	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/handlers"
+	"github.com/ray-remotestate/restro/lifecycle"
+	"github.com/ray-remotestate/restro/metrics"
+	"github.com/ray-remotestate/restro/ratelimit"
 	"github.com/ray-remotestate/restro/server"
 	"github.com/ray-remotestate/restro/config"
+	"github.com/ray-remotestate/restro/storage"
+	"github.com/ray-remotestate/restro/tracing"
 )
 
-const shutdownTimeOut = 10 * time.Second
+const (
+	sessionCleanupInterval     = time.Hour
+	sessionRetention           = 30 * 24 * time.Hour
+	rateLimitCleanupInterval   = time.Hour
+	idempotencyCleanupInterval = time.Hour
+	inventoryResetInterval     = 5 * time.Minute
+	priceScheduleInterval      = time.Minute
+)
 
 func main() {
-	config.Init()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	logrus.SetFormatter(&logrus.JSONFormatter{})
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
-	svr := server.SetupRoutes()
+	lc := lifecycle.New(context.Background())
+	var svr *server.Server
 
-	if err := database.ConnectAndMigrate(); err != nil {
-		logrus.Panicf("failed to initialize database, error: %v", err)
-	}
-	logrus.Println("migration is successful")
+	// config -> tracing -> DB -> cache -> storage -> background workers -> HTTP; stopped in reverse
+	lc.Add(lifecycle.Component{
+		Name: "config",
+		Start: func(ctx context.Context) error {
+			config.Init()
+			return nil
+		},
+	})
+
+	var shutdownTracing func(context.Context) error
+	lc.Add(lifecycle.Component{
+		Name: "tracing",
+		Start: func(ctx context.Context) (err error) {
+			shutdownTracing, err = tracing.Init(ctx)
+			return err
+		},
+		Stop: func(ctx context.Context) error {
+			return shutdownTracing(ctx)
+		},
+	})
+
+	lc.Add(lifecycle.Component{
+		Name: "database",
+		Start: func(ctx context.Context) error {
+			if err := database.ConnectAndMigrate(); err != nil {
+				return err
+			}
+			logrus.Info("migration is successful")
+
+			if err := metrics.RegisterDBStats(database.Restro); err != nil {
+				logrus.WithError(err).Error("failed to register database metrics")
+			}
+			// prices cannot be read until every restaurant has a currency
+			var n int64
+			if err := database.Tx(ctx, func(ctx context.Context, tx *sql.Tx) (err error) {
+				n, err = dbhelper.BackfillCurrency(ctx, tx, config.DefaultCurrency)
+				return err
+			}); err != nil {
+				return err
+			} else if n > 0 {
+				logrus.WithField("restaurants", n).WithField("currency", config.DefaultCurrency).Info("backfilled restaurant currencies")
+			}
+			bootstrapAdminFromEnv(ctx)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			return database.ShutdownDatabase()
+		},
+	})
 
-	go func() {
-		log.Println("Server starting at :8080")
-		if err := svr.Run(":8080"); err != nil {
-			logrus.Panicf("Server didn't start! %+v", err)
+	var cacheListener *cache.Listener
+	lc.Add(lifecycle.Component{
+		Name: "cache",
+		Start: func(ctx context.Context) error {
+			cache.Catalog = cache.NewLoader("catalog", cache.NewLRU(config.Cache.Size, config.Cache.TTL))
+			cacheListener = cache.NewListener(database.ConnString(), cache.Catalog)
+			return cacheListener.Start(ctx)
+		},
+		Stop: func(ctx context.Context) error {
+			return cacheListener.Stop(ctx)
+		},
+	})
+
+	lc.Add(lifecycle.Component{
+		Name: "storage",
+		Start: func(ctx context.Context) error {
+			if config.Storage.Backend == "s3" {
+				s3, err := storage.NewS3(config.Storage.S3)
+				if err != nil {
+					return err
+				}
+				storage.Images = s3
+				return nil
+			}
+			storage.Images = storage.NewLocal(config.Storage.Dir)
+			return nil
+		},
+	})
+
+	lc.Add(lifecycle.Worker("session-cleanup", sessionCleanupInterval, func(ctx context.Context) error {
+		deleted, err := dbhelper.DeleteStaleSessions(ctx, time.Now().Add(-sessionRetention))
+		if err == nil && deleted > 0 {
+			logrus.WithField("deleted", deleted).Info("removed stale sessions")
+		}
+		return err
+	}))
+
+	lc.Add(lifecycle.Worker("rate-limit-cleanup", rateLimitCleanupInterval, func(ctx context.Context) error {
+		if config.RateLimit.Store != "postgres" {
+			return nil
 		}
-	}()
+		_, err := ratelimit.NewPostgresStore(database.Restro).DeleteIdle(ctx, time.Now().Add(-rateLimitCleanupInterval))
+		return err
+	}))
 
-	<-done
+	lc.Add(lifecycle.Worker("idempotency-cleanup", idempotencyCleanupInterval, func(ctx context.Context) error {
+		deleted, err := dbhelper.DeleteExpiredIdempotencyKeys(ctx)
+		if err == nil && deleted > 0 {
+			logrus.WithField("deleted", deleted).Info("removed expired idempotency keys")
+		}
+		return err
+	}))
 
-	logrus.Info("shutting down server...")
-	if err := database.ShutdownDatabase(); err != nil{
-		logrus.WithError(err).Error("failed to close database connection!")
+	lc.Add(lifecycle.Worker("inventory-reset", inventoryResetInterval, handlers.ResetInventory))
+	lc.Add(lifecycle.Worker("price-schedule", priceScheduleInterval, handlers.ApplyScheduledPrices))
+
+	lc.Add(lifecycle.Component{
+		Name: "http",
+		Start: func(ctx context.Context) error {
+			svr = server.SetupRoutes()
+			logrus.Info("Server starting at :8080")
+			return svr.Start(ctx, ":8080")
+		},
+		Stop: func(ctx context.Context) error {
+			// fail readiness first so load balancers stop sending traffic
+			handlers.StartDraining()
+			select {
+			case <-time.After(config.DrainDelay):
+			case <-ctx.Done():
+			}
+			return svr.Shutdown(ctx)
+		},
+	})
+
+	if err := lc.Start(); err != nil {
+		logrus.WithError(err).Error("failed to start")
+		stop(lc)
+		os.Exit(1)
 	}
-	if err := svr.Shutdown(shutdownTimeOut); err != nil {
-		logrus.WithError(err).Error("failed to gracefully shutdown server")
+
+	select {
+	case <-done:
+	case err := <-svr.Errors():
+		logrus.WithError(err).Error("server stopped unexpectedly")
 	}
 
+	logrus.Info("shutting down server...")
+	if !stop(lc) {
+		os.Exit(1)
+	}
 	logrus.Info("system is shut")
-}
\ No newline at end of file
+}
+
+// stop stops every started component and logs the ones that failed.
+func stop(lc *lifecycle.Manager) bool {
+	err := lc.Stop(config.ShutdownTimeout)
+	if err == nil {
+		return true
+	}
+
+	var stopErr *lifecycle.StopError
+	for _, e := range unwrapAll(err) {
+		if errors.As(e, &stopErr) {
+			logrus.WithError(stopErr.Err).WithFields(logrus.Fields{
+				"component": stopErr.Component,
+				"timed_out": stopErr.TimedOut,
+			}).Error("component failed to stop")
+		}
+	}
+	return false
+}
+
+func unwrapAll(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
@@ -1,23 +1,330 @@
 package config
 
 import (
-	"log"
+	"net"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+	"github.com/ray-remotestate/restro/models"
+	"github.com/ray-remotestate/restro/ratelimit"
+	"github.com/ray-remotestate/restro/storage"
 )
 
 var SecretKey []byte
 
+// BootstrapAdmin describes the admin created on the first startup, if any.
+var BootstrapAdmin struct {
+	Email    string
+	Name     string
+	Password string
+}
+
+// Tracing configures the OpenTelemetry exporter. Exporter is one of "otlp",
+// "stdout" or "none".
+var Tracing struct {
+	Exporter     string
+	OTLPEndpoint string
+	ServiceName  string
+	SampleRatio  float64
+}
+
+// DrainDelay is how long /readyz reports failure before the listener closes
+// on shutdown.
+var DrainDelay time.Duration
+
+// ShutdownTimeout is the deadline shared by all components when stopping.
+var ShutdownTimeout time.Duration
+
+// RateLimit holds the token bucket policies per route group. Store is
+// "memory" (per instance) or "postgres" (shared by all instances).
+var RateLimit struct {
+	Enabled bool
+	Store   string
+	Auth    ratelimit.Policy
+	API     ratelimit.Policy
+	Admin   ratelimit.Policy
+}
+
+// CORS lists the browser origins allowed to call the API. "*" allows any
+// origin but cannot be combined with credentials.
+var CORS struct {
+	AllowedOrigins   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// Cookie holds the attributes of the refresh token and CSRF cookies. A
+// dashboard on another site needs SameSite=None, which requires Secure.
+var Cookie struct {
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// TrustedProxies are the networks of the proxies in front of the API, whose
+// X-Forwarded-For entries are believed. Without any, the peer address is
+// the client.
+var TrustedProxies []*net.IPNet
+
+// HSTSMaxAge is sent in Strict-Transport-Security; zero disables the header.
+var HSTSMaxAge time.Duration
+
+// LegacyAPI dates the deprecation of the unversioned routes, which remain as
+// aliases of /v1 until Sunset.
+var LegacyAPI struct {
+	DeprecatedAt time.Time
+	Sunset       time.Time
+}
+
+// IdempotencyTTL is how long a stored Idempotency-Key response is replayed.
+var IdempotencyTTL time.Duration
+
+// Cache sizes the in-process catalog cache. A size of zero disables it.
+var Cache struct {
+	Size int
+	TTL  time.Duration
+}
+
+// Storage selects where uploaded images are kept: "local" under Dir, or
+// "s3". PublicURL is the base of the image URLs in responses; the default
+// has the API serve them from the store.
+var Storage struct {
+	Backend        string
+	Dir            string
+	S3             storage.S3Config
+	PublicURL      string
+	MaxUploadBytes int64
+}
+
+// Timezone is the local time of the restaurants, for daily resets and
+// happy hours.
+var Timezone *time.Location
+
+// DefaultCurrency is what new restaurants price their menus in unless they
+// pick one.
+var DefaultCurrency models.Currency
+
+// Inventory sets when daily stock is restored and 86'd items come back:
+// every day at ResetAt past midnight, local time.
+var Inventory struct {
+	ResetAt time.Duration
+}
+
 func Init() {
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatalf("%v", err)
+		logrus.WithError(err).Fatal("failed to load .env")
 	}
 
 	secret := os.Getenv("JWT_SECRET_KEY")
 	if secret == "" {
-		log.Fatal("JWT secret key not set")
+		logrus.Fatal("JWT secret key not set")
 	}
 	SecretKey = []byte(secret)
-}
\ No newline at end of file
+
+	BootstrapAdmin.Email = os.Getenv("BOOTSTRAP_ADMIN_EMAIL")
+	BootstrapAdmin.Name = os.Getenv("BOOTSTRAP_ADMIN_NAME")
+	BootstrapAdmin.Password = os.Getenv("BOOTSTRAP_ADMIN_PASSWORD")
+
+	Tracing.Exporter = getEnv("OTEL_EXPORTER", "none")
+	Tracing.OTLPEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	Tracing.ServiceName = getEnv("OTEL_SERVICE_NAME", "restro")
+	Tracing.SampleRatio = getEnvFloat("OTEL_SAMPLE_RATIO", 1)
+
+	DrainDelay = getEnvDuration("SHUTDOWN_DRAIN_DELAY", 5*time.Second)
+	ShutdownTimeout = getEnvDuration("SHUTDOWN_TIMEOUT", 20*time.Second)
+
+	RateLimit.Enabled = getEnv("RATE_LIMIT_ENABLED", "true") == "true"
+	RateLimit.Store = getEnv("RATE_LIMIT_STORE", "memory")
+	RateLimit.Auth = getEnvPolicy("RATE_LIMIT_AUTH", "auth", "10/m,burst=10,key=ip")
+	RateLimit.API = getEnvPolicy("RATE_LIMIT_API", "api", "300/m,burst=60,key=user")
+	RateLimit.Admin = getEnvPolicy("RATE_LIMIT_ADMIN", "admin", "60/m,burst=20,key=user")
+
+	CORS.AllowedOrigins = getEnvList("CORS_ALLOWED_ORIGINS")
+	CORS.AllowCredentials = getEnv("CORS_ALLOW_CREDENTIALS", "false") == "true"
+	CORS.MaxAge = getEnvDuration("CORS_MAX_AGE", 10*time.Minute)
+	for _, origin := range CORS.AllowedOrigins {
+		if origin == "*" && CORS.AllowCredentials {
+			logrus.Fatal("CORS_ALLOWED_ORIGINS=* cannot be combined with CORS_ALLOW_CREDENTIALS")
+		}
+	}
+
+	Cookie.Domain = os.Getenv("COOKIE_DOMAIN")
+	Cookie.Secure = getEnv("COOKIE_SECURE", "true") == "true"
+	Cookie.SameSite = getEnvSameSite("COOKIE_SAMESITE", http.SameSiteStrictMode)
+	if Cookie.SameSite == http.SameSiteNoneMode && !Cookie.Secure {
+		logrus.Fatal("COOKIE_SAMESITE=none requires COOKIE_SECURE=true")
+	}
+
+	HSTSMaxAge = getEnvDuration("HSTS_MAX_AGE", 365*24*time.Hour)
+
+	TrustedProxies = getEnvNetworks("TRUSTED_PROXIES")
+
+	Cache.Size = getEnvInt("CACHE_SIZE", 1000)
+	if Cache.Size < 0 {
+		logrus.Fatal("CACHE_SIZE must be 0 or more")
+	}
+	Cache.TTL = getEnvDuration("CACHE_TTL", 5*time.Minute)
+
+	IdempotencyTTL = getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+
+	Storage.Backend = getEnv("STORAGE_BACKEND", "local")
+	Storage.Dir = getEnv("STORAGE_DIR", "data/blobs")
+	Storage.S3 = storage.S3Config{
+		Endpoint:  getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+		Region:    getEnv("S3_REGION", "us-east-1"),
+		Bucket:    os.Getenv("S3_BUCKET"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		PathStyle: getEnv("S3_PATH_STYLE", "false") == "true",
+	}
+	Storage.PublicURL = strings.TrimSuffix(getEnv("IMAGE_PUBLIC_URL", "/media"), "/")
+	Storage.MaxUploadBytes = int64(getEnvInt("IMAGE_MAX_UPLOAD_BYTES", 10<<20))
+	if Storage.Backend != "local" && Storage.Backend != "s3" {
+		logrus.Fatal("STORAGE_BACKEND must be local or s3")
+	}
+
+	// INVENTORY_TIMEZONE set the same before it was shared
+	Timezone = getEnvLocation("TIMEZONE", getEnv("INVENTORY_TIMEZONE", "UTC"))
+	Inventory.ResetAt = getEnvClock("INVENTORY_RESET_TIME", "04:00")
+	DefaultCurrency = getEnvCurrency("DEFAULT_CURRENCY", "USD")
+
+	LegacyAPI.DeprecatedAt = getEnvTime("LEGACY_API_DEPRECATED_AT", "2026-10-18T00:00:00Z")
+	LegacyAPI.Sunset = getEnvTime("LEGACY_API_SUNSET", "2027-04-18T00:00:00Z")
+}
+
+func getEnv(key, fallback string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		logrus.WithError(err).Fatalf("invalid %s", key)
+	}
+	return f
+}
+
+func getEnvInt(key string, fallback int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		logrus.WithError(err).Fatalf("invalid %s", key)
+	}
+	return n
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		logrus.WithError(err).Fatalf("invalid %s", key)
+	}
+	return d
+}
+
+func getEnvTime(key, fallback string) time.Time {
+	t, err := time.Parse(time.RFC3339, getEnv(key, fallback))
+	if err != nil {
+		logrus.WithError(err).Fatalf("invalid %s, expected RFC3339", key)
+	}
+	return t
+}
+
+// getEnvClock reads a time of day as HH:MM and returns it as the time since
+// midnight.
+func getEnvClock(key, fallback string) time.Duration {
+	t, err := time.Parse("15:04", getEnv(key, fallback))
+	if err != nil {
+		logrus.WithError(err).Fatalf("invalid %s, expected HH:MM", key)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+}
+
+func getEnvLocation(key, fallback string) *time.Location {
+	loc, err := time.LoadLocation(getEnv(key, fallback))
+	if err != nil {
+		logrus.WithError(err).Fatalf("invalid %s", key)
+	}
+	return loc
+}
+
+func getEnvCurrency(key, fallback string) models.Currency {
+	c, err := models.ParseCurrency(getEnv(key, fallback))
+	if err != nil {
+		logrus.WithError(err).Fatalf("invalid %s", key)
+	}
+	return c
+}
+
+func getEnvPolicy(key, name, fallback string) ratelimit.Policy {
+	p, err := ratelimit.ParsePolicy(name, getEnv(key, fallback))
+	if err != nil {
+		logrus.WithError(err).Fatalf("invalid %s", key)
+	}
+	return p
+}
+
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvNetworks reads a list of CIDRs, where a bare address stands for
+// itself.
+func getEnvNetworks(key string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, item := range getEnvList(key) {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			logrus.WithError(err).Fatalf("invalid %s", key)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func getEnvSameSite(key string, fallback http.SameSite) http.SameSite {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "":
+		return fallback
+	case "strict":
+		return http.SameSiteStrictMode
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	logrus.Fatalf("invalid %s: expected strict, lax or none", key)
+	return fallback
+}
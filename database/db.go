@@ -1,35 +1,41 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"embed"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/XSAM/otelsql"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"github.com/ray-remotestate/restro/tracing"
 )
 
 var Restro *sql.DB
 
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
 func ConnectAndMigrate() error {
 	err := godotenv.Load()
 	if err != nil {
 		return err
 	}
 
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		os.Getenv("DB_host"),
-		os.Getenv("DB_port"),
-		os.Getenv("DB_user"),
-		os.Getenv("DB_password"),
-		os.Getenv("DB_name"),
-	)
+	connStr := ConnString()
 
-	DB, err := sql.Open("postgres", connStr)
+	// every query made through the pool gets a child span of the request span
+	DB, err := otelsql.Open("postgres", connStr, otelsql.WithAttributes(semconv.DBSystemPostgreSQL))
 	if err != nil {
 		return err
 	}
@@ -43,16 +49,31 @@ func ConnectAndMigrate() error {
 	return migrateUp(DB)
 }
 
+// ConnString is the lib/pq connection string of the database, for
+// connections kept outside the pool such as LISTEN.
+func ConnString() string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		os.Getenv("DB_host"),
+		os.Getenv("DB_port"),
+		os.Getenv("DB_user"),
+		os.Getenv("DB_password"),
+		os.Getenv("DB_name"),
+	)
+}
+
 func migrateUp(db *sql.DB) error {
 	driver, err := postgres.WithInstance(db, &postgres.Config{})
 	if err != nil {
 		return err
 	}
 
-	logrus.Println("done creating migration")
-	m, err := migrate.NewWithDatabaseInstance(
-		"file:///home/ray/ray/Golang_Projects/restro/database/migrations",
-		"postgres", driver)
+	source, err := iofs.New(migrationFiles, "migrations")
+	if err != nil {
+		return err
+	}
+
+	logrus.Info("done creating migration")
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
 	if err != nil {
 		return err
 	}
@@ -69,12 +90,54 @@ func migrateUp(db *sql.DB) error {
 	return nil
 }
 
+// ExpectedMigrationVersion is the highest migration version shipped with the binary.
+func ExpectedMigrationVersion() (uint, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return 0, err
+	}
+
+	var latest uint
+	for _, entry := range entries {
+		prefix, _, ok := strings.Cut(entry.Name(), "_")
+		if !ok {
+			continue
+		}
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		latest = max(latest, uint(version))
+	}
+	return latest, nil
+}
+
+// MigrationVersion reports the version recorded by golang-migrate and
+// whether the last migration left the schema dirty.
+func MigrationVersion(ctx context.Context) (uint, bool, error) {
+	var version uint
+	var dirty bool
+	err := Restro.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	return version, dirty, err
+}
+
 func ShutdownDatabase() error {
 	return Restro.Close()
 }
 
-func Tx(fn func(tx *sql.Tx) error) error {
-	tx, err := Restro.Begin()
+// Tx runs fn in a transaction, committing when it returns nil. fn gets the
+// context of the transaction's span, for its queries to be traced under it.
+func Tx(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx) error) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "database.Tx")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	tx, err := Restro.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -86,7 +149,7 @@ func Tx(fn func(tx *sql.Tx) error) error {
 		}
 	}()
 
-	if err := fn(tx); err != nil {
+	if err := fn(ctx, tx); err != nil {
 		_ = tx.Rollback()
 		return err
 	}
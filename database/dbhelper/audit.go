@@ -0,0 +1,98 @@
+package dbhelper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/models"
+)
+
+const maxAuditLogLimit = 500
+
+func InsertAuditLog(ctx context.Context, exec SQLExecutor, entry models.AuditLog) error {
+	_, err := exec.ExecContext(ctx, `
+		INSERT INTO audit_log (actor_id, impersonator_id, action, resource_type, resource_id, before, after, diff, ip_address, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		nullUUID(entry.ActorID), entry.ImpersonatorID, entry.Action, entry.ResourceType, entry.ResourceID,
+		nullJSON(entry.Before), nullJSON(entry.After), nullJSON(entry.Diff), entry.IPAddress, entry.RequestID)
+	return err
+}
+
+func ListAuditLogs(ctx context.Context, filter models.AuditFilter) ([]models.AuditLog, error) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if filter.ActorID != uuid.Nil {
+		add("actor_id = $%d", filter.ActorID)
+	}
+	if filter.ResourceType != "" {
+		add("resource_type = $%d", filter.ResourceType)
+	}
+	if filter.ResourceID != "" {
+		add("resource_id = $%d", filter.ResourceID)
+	}
+	if !filter.From.IsZero() {
+		add("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add("created_at < $%d", filter.To)
+	}
+
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > maxAuditLogLimit {
+		limit = maxAuditLogLimit
+	}
+	args = append(args, limit)
+
+	rows, err := database.Restro.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, COALESCE(actor_id, '00000000-0000-0000-0000-000000000000'), impersonator_id, action, resource_type,
+			COALESCE(resource_id, ''), before, after, diff, COALESCE(ip_address, ''), COALESCE(request_id, ''), created_at
+		FROM audit_log
+		%s
+		ORDER BY created_at DESC
+		LIMIT $%d`, where, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []models.AuditLog
+	for rows.Next() {
+		var l models.AuditLog
+		var before, after, diff []byte
+		if err := rows.Scan(&l.ID, &l.ActorID, &l.ImpersonatorID, &l.Action, &l.ResourceType,
+			&l.ResourceID, &before, &after, &diff, &l.IPAddress, &l.RequestID, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		l.Before, l.After, l.Diff = before, after, diff
+		logs = append(logs, l)
+	}
+	return logs, rows.Err()
+}
+
+func nullUUID(id uuid.UUID) interface{} {
+	if id == uuid.Nil {
+		return nil
+	}
+	return id
+}
+
+func nullJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
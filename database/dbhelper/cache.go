@@ -0,0 +1,18 @@
+package dbhelper
+
+import (
+	"context"
+
+	"github.com/ray-remotestate/restro/cache"
+)
+
+// NotifyCacheInvalidation tells every instance to drop keys. Inside a
+// transaction the notification is only delivered if it commits.
+func NotifyCacheInvalidation(ctx context.Context, exec SQLExecutor, keys ...string) error {
+	for _, key := range keys {
+		if _, err := exec.ExecContext(ctx, `SELECT pg_notify($1, $2)`, cache.InvalidationChannel, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
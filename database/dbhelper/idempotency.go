@@ -0,0 +1,73 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/models"
+)
+
+// ReserveIdempotencyKey claims caller's key for a new request. It succeeds
+// when the key is unused, expired, or held by a request that has not finished
+// within lockTimeout and is presumed dead. Otherwise it returns the stored
+// entry. Concurrent duplicates race on the primary key, so only one wins.
+func ReserveIdempotencyKey(ctx context.Context, caller, key, requestHash string, expiresAt time.Time, lockTimeout time.Duration) (models.IdempotencyKey, bool, error) {
+	for {
+		var claimed string
+		err := database.Restro.QueryRowContext(ctx, `
+			INSERT INTO idempotency_keys AS k (caller, key, request_hash, expires_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (caller, key) DO UPDATE SET
+				request_hash = EXCLUDED.request_hash,
+				status = NULL,
+				content_type = '',
+				body = NULL,
+				created_at = NOW(),
+				expires_at = EXCLUDED.expires_at
+			WHERE k.expires_at < NOW() OR (k.status IS NULL AND k.created_at < NOW() - make_interval(secs => $5))
+			RETURNING caller`, caller, key, requestHash, expiresAt, lockTimeout.Seconds()).Scan(&claimed)
+		if err == nil {
+			return models.IdempotencyKey{}, true, nil
+		}
+		if err != sql.ErrNoRows {
+			return models.IdempotencyKey{}, false, err
+		}
+
+		var k models.IdempotencyKey
+		err = database.Restro.QueryRowContext(ctx, `
+			SELECT caller, key, request_hash, status, content_type, body, created_at, expires_at
+			FROM idempotency_keys
+			WHERE caller = $1 AND key = $2`, caller, key).
+			Scan(&k.Caller, &k.Key, &k.RequestHash, &k.Status, &k.ContentType, &k.Body, &k.CreatedAt, &k.ExpiresAt)
+		if err == sql.ErrNoRows {
+			// removed by the cleanup in between; try to claim it again
+			continue
+		}
+		return k, false, err
+	}
+}
+
+// CompleteIdempotencyKey stores the response of the request holding the key.
+func CompleteIdempotencyKey(ctx context.Context, caller, key string, status int, contentType string, body []byte) error {
+	_, err := database.Restro.ExecContext(ctx, `
+		UPDATE idempotency_keys SET status = $3, content_type = $4, body = $5
+		WHERE caller = $1 AND key = $2`, caller, key, status, contentType, body)
+	return err
+}
+
+// ReleaseIdempotencyKey frees a key whose request failed so it can be retried.
+func ReleaseIdempotencyKey(ctx context.Context, caller, key string) error {
+	_, err := database.Restro.ExecContext(ctx, `
+		DELETE FROM idempotency_keys WHERE caller = $1 AND key = $2 AND status IS NULL`, caller, key)
+	return err
+}
+
+func DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	res, err := database.Restro.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,55 @@
+package dbhelper
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/ray-remotestate/restro/models"
+)
+
+const imageColumns = `id, restaurant_id, menu_id, slot, format, width, height, created_by, created_at`
+
+func scanImage(row interface{ Scan(...interface{}) error }) (models.Image, error) {
+	var img models.Image
+	var createdBy uuid.NullUUID
+	err := row.Scan(&img.ID, &img.RestaurantID, &img.MenuID, &img.Slot, &img.Format, &img.Width, &img.Height, &createdBy, &img.CreatedAt)
+	img.CreatedBy = createdBy.UUID
+	return img, err
+}
+
+// ReplaceImage stores img in its slot and returns the image it replaced, if
+// any. The caller deletes the replaced image's blobs once this commits.
+func ReplaceImage(ctx context.Context, exec SQLExecutor, img models.Image) (*models.Image, error) {
+	old, err := DeleteImage(ctx, exec, img.RestaurantID, img.MenuID, img.Slot)
+	if err != nil {
+		return nil, err
+	}
+	_, err = exec.ExecContext(ctx, `
+		INSERT INTO images (id, restaurant_id, menu_id, slot, format, width, height, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		img.ID, img.RestaurantID, img.MenuID, img.Slot, img.Format, img.Width, img.Height, img.CreatedBy)
+	return old, err
+}
+
+// DeleteImage removes the image in a slot of a restaurant or menu item and
+// returns it, or nil if the slot was empty.
+func DeleteImage(ctx context.Context, exec SQLExecutor, restaurantID, menuID *uuid.UUID, slot models.ImageSlot) (*models.Image, error) {
+	rows, err := exec.QueryContext(ctx, `
+		DELETE FROM images
+		WHERE (restaurant_id = $1 OR menu_id = $2) AND slot = $3
+		RETURNING `+imageColumns, restaurantID, menuID, slot)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var old *models.Image
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		old = &img
+	}
+	return old, rows.Err()
+}
@@ -0,0 +1,15 @@
+package dbhelper
+
+import (
+	"context"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/models"
+)
+
+func LogImpersonation(ctx context.Context, entry models.ImpersonationAudit) error {
+	_, err := database.Restro.ExecContext(ctx, `
+		INSERT INTO impersonation_audit (actor_id, subject_id, session_id, method, path, status, ip_address, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))`,
+		entry.ActorID, entry.SubjectID, entry.SessionID, entry.Method, entry.Path, entry.Status, entry.IPAddress, entry.Reason)
+	return err
+}
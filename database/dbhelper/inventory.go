@@ -0,0 +1,238 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/models"
+)
+
+var ErrInvalidQuantity = errors.New("quantities must be greater than zero")
+
+// OutOfStockError lists the menu items of an order that are unavailable or
+// short of stock.
+type OutOfStockError struct {
+	Items []uuid.UUID
+}
+
+func (e *OutOfStockError) Error() string {
+	ids := make([]string, len(e.Items))
+	for i, id := range e.Items {
+		ids[i] = id.String()
+	}
+	return "out of stock: " + strings.Join(ids, ", ")
+}
+
+const inventoryColumns = `id, restaurant_id, name, coalesce(is_available, TRUE), stock, daily_stock, eighty_sixed_at, orderable`
+
+func scanInventory(row interface{ Scan(...interface{}) error }) (models.Inventory, error) {
+	var inv models.Inventory
+	err := row.Scan(&inv.MenuItemID, &inv.RestaurantID, &inv.Name, &inv.IsAvailable, &inv.Stock, &inv.DailyStock,
+		&inv.EightySixedAt, &inv.Orderable)
+	return inv, err
+}
+
+// ListInventory returns the stock of every item on a restaurant's menu.
+func ListInventory(ctx context.Context, restaurantID uuid.UUID) ([]models.Inventory, error) {
+	rows, err := database.Restro.QueryContext(ctx, `
+		SELECT `+inventoryColumns+`
+		FROM menu
+		WHERE restaurant_id = $1
+		ORDER BY name, id`, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.Inventory{}
+	for rows.Next() {
+		inv, err := scanInventory(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, inv)
+	}
+	return items, rows.Err()
+}
+
+// GetInventoryForUpdate locks a menu item for the rest of the transaction.
+func GetInventoryForUpdate(ctx context.Context, exec SQLExecutor, menuID uuid.UUID) (models.Inventory, error) {
+	return scanInventory(exec.QueryRowContext(ctx, `
+		SELECT `+inventoryColumns+` FROM menu WHERE id = $1 FOR UPDATE`, menuID))
+}
+
+// SetStock sets the stock of a menu item, nil to stop tracking it, and the
+// stock it gets back every day, nil for none. The stock set counts as
+// today's, so the next daily reset leaves it alone.
+func SetStock(ctx context.Context, exec SQLExecutor, menuID uuid.UUID, stock, dailyStock *int) (models.Inventory, error) {
+	return scanInventory(exec.QueryRowContext(ctx, `
+		UPDATE menu SET stock = $2, daily_stock = $3, stock_reset_at = NOW()
+		WHERE id = $1
+		RETURNING `+inventoryColumns, menuID, stock, dailyStock))
+}
+
+// SetAvailability flips the manual availability switch of a menu item.
+func SetAvailability(ctx context.Context, exec SQLExecutor, menuID uuid.UUID, available bool) (models.Inventory, error) {
+	return scanInventory(exec.QueryRowContext(ctx, `
+		UPDATE menu SET is_available = $2
+		WHERE id = $1
+		RETURNING `+inventoryColumns, menuID, available))
+}
+
+// EightySix takes a menu item off until the next daily reset, or with
+// off false puts it back.
+func EightySix(ctx context.Context, exec SQLExecutor, menuID uuid.UUID, off bool) (models.Inventory, error) {
+	return scanInventory(exec.QueryRowContext(ctx, `
+		UPDATE menu SET eighty_sixed_at = CASE WHEN $2 THEN coalesce(eighty_sixed_at, NOW()) END
+		WHERE id = $1
+		RETURNING `+inventoryColumns, menuID, off))
+}
+
+// ReserveStock takes the stock an order needs, failing with
+// ErrInvalidQuantity unless every quantity is positive, or with
+// *OutOfStockError, and untouched stock, when any item is unavailable or
+// short. The items stay locked until the transaction ends, so concurrent
+// orders cannot oversell. It returns the restaurants whose menus changed.
+func ReserveStock(ctx context.Context, exec SQLExecutor, lines []models.StockLine) ([]uuid.UUID, error) {
+	ids, quantities, err := stockLines(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	// lock in a fixed order so concurrent orders cannot deadlock
+	rows, err := exec.QueryContext(ctx, `
+		SELECT id, orderable, stock FROM menu
+		WHERE id = ANY($1::uuid[])
+		ORDER BY id
+		FOR UPDATE`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	wanted := stockTotals(lines)
+	var short []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		var orderable bool
+		var stock *int64
+		if err := rows.Scan(&id, &orderable, &stock); err != nil {
+			return nil, err
+		}
+		if !orderable || (stock != nil && *stock < wanted[id]) {
+			short = append(short, id)
+		}
+		delete(wanted, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for id := range wanted {
+		short = append(short, id) // not on any menu
+	}
+	if len(short) > 0 {
+		return nil, &OutOfStockError{Items: short}
+	}
+	return adjustStock(ctx, exec, ids, quantities, -1)
+}
+
+// RestoreStock gives back the stock of a cancelled order. Items whose stock
+// is not tracked are left alone. Like ReserveStock it takes only positive
+// quantities.
+func RestoreStock(ctx context.Context, exec SQLExecutor, lines []models.StockLine) ([]uuid.UUID, error) {
+	ids, quantities, err := stockLines(lines)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := exec.ExecContext(ctx, `
+		SELECT 1 FROM menu WHERE id = ANY($1::uuid[]) ORDER BY id FOR UPDATE`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	return adjustStock(ctx, exec, ids, quantities, 1)
+}
+
+func stockTotals(lines []models.StockLine) map[uuid.UUID]int64 {
+	totals := map[uuid.UUID]int64{}
+	for _, line := range lines {
+		totals[line.MenuItemID] += int64(line.Quantity)
+	}
+	return totals
+}
+
+// stockLines sums the quantities per item, as query parameters, or returns
+// ErrInvalidQuantity; a negative line would turn a reservation into a
+// restock.
+func stockLines(lines []models.StockLine) ([]string, []int64, error) {
+	for _, line := range lines {
+		if line.Quantity <= 0 {
+			return nil, nil, ErrInvalidQuantity
+		}
+	}
+	var ids []string
+	var quantities []int64
+	for id, quantity := range stockTotals(lines) {
+		ids = append(ids, id.String())
+		quantities = append(quantities, quantity)
+	}
+	return ids, quantities, nil
+}
+
+func adjustStock(ctx context.Context, exec SQLExecutor, ids []string, quantities []int64, sign int64) ([]uuid.UUID, error) {
+	rows, err := exec.QueryContext(ctx, `
+		UPDATE menu SET stock = menu.stock + $3 * d.quantity
+		FROM unnest($1::uuid[], $2::int[]) AS d(id, quantity)
+		WHERE menu.id = d.id AND menu.stock IS NOT NULL
+		RETURNING menu.restaurant_id`, pq.Array(ids), pq.Array(quantities), sign)
+	if err != nil {
+		return nil, err
+	}
+	return distinctIDs(rows)
+}
+
+// ResetDailyInventory restores the daily stock of items last reset before
+// since and brings back the items 86'd before it. It returns the
+// restaurants whose menus changed.
+func ResetDailyInventory(ctx context.Context, exec SQLExecutor, since time.Time) ([]uuid.UUID, error) {
+	rows, err := exec.QueryContext(ctx, `
+		WITH due AS (
+			SELECT id, daily_stock IS NOT NULL AND (stock_reset_at IS NULL OR stock_reset_at < $1) AS restock
+			FROM menu
+			WHERE (daily_stock IS NOT NULL AND (stock_reset_at IS NULL OR stock_reset_at < $1))
+				OR eighty_sixed_at < $1
+			FOR UPDATE
+		)
+		UPDATE menu SET
+			stock = CASE WHEN due.restock THEN menu.daily_stock ELSE menu.stock END,
+			stock_reset_at = CASE WHEN due.restock THEN NOW() ELSE menu.stock_reset_at END,
+			eighty_sixed_at = CASE WHEN menu.eighty_sixed_at < $1 THEN NULL ELSE menu.eighty_sixed_at END
+		FROM due
+		WHERE menu.id = due.id
+		RETURNING menu.restaurant_id`, since)
+	if err != nil {
+		return nil, err
+	}
+	return distinctIDs(rows)
+}
+
+func distinctIDs(rows *sql.Rows) ([]uuid.UUID, error) {
+	defer rows.Close()
+	var ids []uuid.UUID
+	seen := map[uuid.UUID]bool{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,65 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ray-remotestate/restro/models"
+)
+
+var errQueried = errors.New("queried the database")
+
+// noDatabase fails every query, for checks that must happen before any.
+type noDatabase struct{}
+
+func (noDatabase) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return nil, errQueried
+}
+
+func (noDatabase) QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error) {
+	return nil, errQueried
+}
+
+func (noDatabase) QueryRowContext(context.Context, string, ...interface{}) *sql.Row {
+	panic(errQueried)
+}
+
+func TestStockQuantities(t *testing.T) {
+	item := uuid.New()
+	tests := []struct {
+		name  string
+		lines []models.StockLine
+		want  error
+	}{
+		{"positive", []models.StockLine{{MenuItemID: item, Quantity: 2}}, errQueried},
+		{"zero", []models.StockLine{{MenuItemID: item, Quantity: 0}}, ErrInvalidQuantity},
+		{"negative", []models.StockLine{{MenuItemID: item, Quantity: -3}}, ErrInvalidQuantity},
+		{"negative offsetting a positive", []models.StockLine{{MenuItemID: item, Quantity: 5}, {MenuItemID: item, Quantity: -3}}, ErrInvalidQuantity},
+	}
+	for _, tt := range tests {
+		if _, err := ReserveStock(context.Background(), noDatabase{}, tt.lines); !errors.Is(err, tt.want) {
+			t.Errorf("%s: ReserveStock error = %v, want %v", tt.name, err, tt.want)
+		}
+		if _, err := RestoreStock(context.Background(), noDatabase{}, tt.lines); !errors.Is(err, tt.want) {
+			t.Errorf("%s: RestoreStock error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestStockLines(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	ids, quantities, err := stockLines([]models.StockLine{{MenuItemID: a, Quantity: 2}, {MenuItemID: b, Quantity: 1}, {MenuItemID: a, Quantity: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int64{}
+	for i, id := range ids {
+		got[id] = quantities[i]
+	}
+	if len(got) != 2 || got[a.String()] != 5 || got[b.String()] != 1 {
+		t.Errorf("stockLines = %v, want %s: 5 and %s: 1", got, a, b)
+	}
+}
@@ -0,0 +1,120 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ray-remotestate/restro/models"
+)
+
+// ListMenuRows returns a restaurant's menu as bulk export sees it, ordered
+// by SKU. With forUpdate the items, and the restaurant against other
+// imports, stay locked for the rest of the transaction.
+func ListMenuRows(ctx context.Context, exec SQLExecutor, restaurantID uuid.UUID, forUpdate bool) ([]models.MenuRow, error) {
+	lock := ""
+	if forUpdate {
+		var id uuid.UUID
+		if err := exec.QueryRowContext(ctx, `SELECT id FROM restaurants WHERE id = $1 FOR NO KEY UPDATE`, restaurantID).Scan(&id); err != nil {
+			return nil, err
+		}
+		lock = "FOR UPDATE OF m"
+	}
+	rows, err := exec.QueryContext(ctx, `
+		SELECT m.id, m.sku, m.name, coalesce(m.description, ''), (m.price_minor, r.currency), coalesce(m.is_available, TRUE),
+			coalesce((
+				SELECT array_agg(t.slug ORDER BY t.slug)
+				FROM menu_tags mt JOIN tags t ON t.id = mt.tag_id
+				WHERE mt.menu_id = m.id AND t.archived_at IS NULL
+			), '{}'),
+			coalesce(m.allergens, '{}')
+		FROM menu m
+		JOIN restaurants r ON r.id = m.restaurant_id
+		WHERE m.restaurant_id = $1
+		ORDER BY m.sku
+		`+lock, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.MenuRow{}
+	for rows.Next() {
+		var item models.MenuRow
+		var description string
+		var isAvailable bool
+		var allergens []string
+		if err := rows.Scan(&item.ID, &item.SKU, &item.Name, &description, &item.Price, &isAvailable,
+			pq.Array(&item.Tags), pq.Array(&allergens)); err != nil {
+			return nil, err
+		}
+		item.Description = &description
+		item.IsAvailable = &isAvailable
+		item.Allergens = make([]models.Allergen, len(allergens))
+		for i, a := range allergens {
+			item.Allergens[i] = models.Allergen(a)
+		}
+		slices.Sort(item.Allergens)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+var ErrSKUExists = errors.New("SKU already used on this menu")
+
+// InsertMenuRow adds an item to a restaurant's menu, with a random SKU when
+// it has none. Its price history starts with the price it is created with.
+func InsertMenuRow(ctx context.Context, exec SQLExecutor, restaurantID uuid.UUID, row models.MenuRow, createdBy uuid.UUID) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := exec.QueryRowContext(ctx, `
+		INSERT INTO menu (restaurant_id, sku, name, description, price_minor, is_available, created_by)
+		VALUES ($1, coalesce(nullif($2, ''), gen_random_uuid()::text), $3, $4, $5, coalesce($6, TRUE), $7)
+		RETURNING id`,
+		restaurantID, row.SKU, row.Name, row.Description, row.Price.Amount, row.IsAvailable, createdBy).Scan(&id)
+	if isUniqueViolation(err) {
+		return uuid.Nil, ErrSKUExists
+	} else if err != nil {
+		return uuid.Nil, err
+	}
+	if row.Tags != nil {
+		if _, err := SetMenuItemTags(ctx, exec, id, row.Tags); err != nil {
+			return uuid.Nil, err
+		}
+	}
+	if row.Allergens != nil {
+		if _, err := SetMenuItemAllergens(ctx, exec, id, row.Allergens); err != nil {
+			return uuid.Nil, err
+		}
+	}
+	return id, nil
+}
+
+// UpdateMenuRow brings an existing item in line with an imported row. Only
+// what changed is written, and a new price goes through the price history.
+func UpdateMenuRow(ctx context.Context, exec SQLExecutor, existing, row models.MenuRow, updatedBy uuid.UUID) error {
+	if _, err := exec.ExecContext(ctx, `
+		UPDATE menu SET name = $2, description = coalesce($3, description), is_available = coalesce($4, is_available)
+		WHERE id = $1 AND (name, coalesce(description, ''), coalesce(is_available, TRUE))
+			IS DISTINCT FROM ($2, coalesce($3, description, ''), coalesce($4, is_available, TRUE))`,
+		existing.ID, row.Name, row.Description, row.IsAvailable); err != nil {
+		return err
+	}
+	if row.Price != existing.Price {
+		if _, err := ChangePrice(ctx, exec, existing.ID, row.Price, nil, updatedBy); err != nil {
+			return err
+		}
+	}
+	if row.Tags != nil && !slices.Equal(row.Tags, existing.Tags) {
+		if _, err := SetMenuItemTags(ctx, exec, existing.ID, row.Tags); err != nil {
+			return err
+		}
+	}
+	if row.Allergens != nil && !slices.Equal(row.Allergens, existing.Allergens) {
+		if _, err := SetMenuItemAllergens(ctx, exec, existing.ID, row.Allergens); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/models"
+)
+
+// priceColumns reads the price as a row of the amount and the restaurant's
+// currency, which models.Money scans.
+const priceColumns = `id, menu_id, (price_minor, (
+		SELECT r.currency FROM menu m JOIN restaurants r ON r.id = m.restaurant_id WHERE m.id = menu_prices.menu_id
+	)), effective_at, applied_at, cancelled_at, created_by, created_at`
+
+func scanPriceChange(row interface{ Scan(...interface{}) error }) (models.PriceChange, error) {
+	var p models.PriceChange
+	err := row.Scan(&p.ID, &p.MenuItemID, &p.Price, &p.EffectiveAt, &p.AppliedAt, &p.CancelledAt, &p.CreatedBy, &p.CreatedAt)
+	return p, err
+}
+
+// ListPriceHistory returns the price changes of a menu item, scheduled and
+// cancelled ones included, latest first.
+func ListPriceHistory(ctx context.Context, menuID uuid.UUID) ([]models.PriceChange, error) {
+	rows, err := database.Restro.QueryContext(ctx, `
+		SELECT `+priceColumns+`
+		FROM menu_prices
+		WHERE menu_id = $1
+		ORDER BY effective_at DESC, created_at DESC`, menuID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []models.PriceChange{}
+	for rows.Next() {
+		p, err := scanPriceChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, p)
+	}
+	return history, rows.Err()
+}
+
+// CurrentPrice returns the price change a menu item is priced by now. With
+// forUpdate the menu item stays locked for the rest of the transaction.
+func CurrentPrice(ctx context.Context, exec SQLExecutor, menuID uuid.UUID, forUpdate bool) (models.PriceChange, error) {
+	if forUpdate {
+		var id uuid.UUID
+		if err := exec.QueryRowContext(ctx, `SELECT id FROM menu WHERE id = $1 FOR UPDATE`, menuID).Scan(&id); err != nil {
+			return models.PriceChange{}, err
+		}
+	}
+	return scanPriceChange(exec.QueryRowContext(ctx, `
+		SELECT `+priceColumns+`
+		FROM menu_prices
+		WHERE menu_id = $1 AND applied_at IS NOT NULL
+		ORDER BY effective_at DESC, created_at DESC
+		LIMIT 1`, menuID))
+}
+
+// PriceAt returns the price change in effect for a menu item at a time,
+// counting scheduled changes as applied from their effective time.
+func PriceAt(ctx context.Context, exec SQLExecutor, menuID uuid.UUID, at time.Time) (models.PriceChange, error) {
+	return scanPriceChange(exec.QueryRowContext(ctx, `
+		SELECT `+priceColumns+`
+		FROM menu_prices
+		WHERE menu_id = $1 AND cancelled_at IS NULL AND effective_at <= $2
+		ORDER BY effective_at DESC, created_at DESC
+		LIMIT 1`, menuID, at))
+}
+
+// ChangePrice reprices a menu item now, when effectiveAt is nil, or
+// schedules the change. The price must be in the restaurant's currency,
+// else it returns a *models.CurrencyMismatchError.
+func ChangePrice(ctx context.Context, exec SQLExecutor, menuID uuid.UUID, price models.Money, effectiveAt *time.Time, createdBy uuid.UUID) (models.PriceChange, error) {
+	currency, err := MenuItemCurrency(ctx, exec, menuID)
+	if err != nil {
+		return models.PriceChange{}, err
+	}
+	if price.Currency != currency {
+		return models.PriceChange{}, &models.CurrencyMismatchError{Left: currency, Right: price.Currency}
+	}
+
+	if effectiveAt != nil {
+		return scanPriceChange(exec.QueryRowContext(ctx, `
+			INSERT INTO menu_prices (menu_id, price_minor, effective_at, created_by)
+			VALUES ($1, $2, $3, $4)
+			RETURNING `+priceColumns, menuID, price.Amount, *effectiveAt, createdBy))
+	}
+
+	change, err := scanPriceChange(exec.QueryRowContext(ctx, `
+		INSERT INTO menu_prices (menu_id, price_minor, effective_at, applied_at, created_by)
+		VALUES ($1, $2, NOW(), NOW(), $3)
+		RETURNING `+priceColumns, menuID, price.Amount, createdBy))
+	if err != nil {
+		return change, err
+	}
+	_, err = exec.ExecContext(ctx, `
+		UPDATE menu SET price_minor = $2 WHERE id = $1 AND price_minor <> $2`, menuID, price.Amount)
+	return change, err
+}
+
+// CancelPriceChange cancels a scheduled price change that has not been
+// applied yet.
+func CancelPriceChange(ctx context.Context, exec SQLExecutor, menuID, id uuid.UUID) (models.PriceChange, error) {
+	return scanPriceChange(exec.QueryRowContext(ctx, `
+		UPDATE menu_prices SET cancelled_at = NOW()
+		WHERE id = $1 AND menu_id = $2 AND applied_at IS NULL AND cancelled_at IS NULL
+		RETURNING `+priceColumns, id, menuID))
+}
+
+// ApplyDuePrices puts scheduled price changes whose time has come on the
+// menu. Of the due changes of an item only the latest is applied; the
+// others, and any due change older than one applied meanwhile, never
+// reached the menu and are cancelled. It returns the restaurants whose
+// menus changed.
+func ApplyDuePrices(ctx context.Context, exec SQLExecutor) ([]uuid.UUID, error) {
+	rows, err := exec.QueryContext(ctx, `
+		SELECT p.id, p.menu_id, p.price_minor, p.effective_at, p.created_at, (
+			SELECT max(q.effective_at) FROM menu_prices q
+			WHERE q.menu_id = p.menu_id AND q.applied_at IS NOT NULL
+		)
+		FROM menu_prices p
+		WHERE p.applied_at IS NULL AND p.cancelled_at IS NULL AND p.effective_at <= NOW()
+		FOR UPDATE OF p`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []duePrice
+	for rows.Next() {
+		var d duePrice
+		if err := rows.Scan(&d.ID, &d.MenuItemID, &d.Price.Amount, &d.EffectiveAt, &d.CreatedAt, &d.lastApplied); err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+
+	latest, skipped := pickDuePrices(due)
+	applied := make([]string, 0, len(latest))
+	for _, p := range latest {
+		applied = append(applied, p.ID.String())
+	}
+	cancelled := make([]string, len(skipped))
+	for i, id := range skipped {
+		cancelled[i] = id.String()
+	}
+	if _, err := exec.ExecContext(ctx, `
+		UPDATE menu_prices SET applied_at = NOW() WHERE id = ANY($1::uuid[])`, pq.Array(applied)); err != nil {
+		return nil, err
+	}
+	if _, err := exec.ExecContext(ctx, `
+		UPDATE menu_prices SET cancelled_at = NOW() WHERE id = ANY($1::uuid[])`, pq.Array(cancelled)); err != nil {
+		return nil, err
+	}
+	if len(latest) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(latest))
+	prices := make([]int64, 0, len(latest))
+	for id, p := range latest {
+		ids = append(ids, id.String())
+		prices = append(prices, p.Price.Amount)
+	}
+	updated, err := exec.QueryContext(ctx, `
+		UPDATE menu SET price_minor = d.price
+		FROM unnest($1::uuid[], $2::bigint[]) AS d(id, price)
+		WHERE menu.id = d.id AND menu.price_minor <> d.price
+		RETURNING menu.restaurant_id`, pq.Array(ids), pq.Array(prices))
+	if err != nil {
+		return nil, err
+	}
+	return distinctIDs(updated)
+}
+
+// duePrice is a scheduled price change whose time has come, with when the
+// latest change applied to its item took effect.
+type duePrice struct {
+	models.PriceChange
+	lastApplied sql.NullTime
+}
+
+// pickDuePrices returns, by menu item, the due change to apply, the latest
+// by effective time and then by creation, and the ids of the others to
+// cancel. A change older than one already applied is cancelled too.
+func pickDuePrices(due []duePrice) (map[uuid.UUID]models.PriceChange, []uuid.UUID) {
+	latest := map[uuid.UUID]models.PriceChange{}
+	for _, d := range due {
+		if d.lastApplied.Valid && d.lastApplied.Time.After(d.EffectiveAt) {
+			continue
+		}
+		if l, ok := latest[d.MenuItemID]; ok && (l.EffectiveAt.After(d.EffectiveAt) ||
+			(l.EffectiveAt.Equal(d.EffectiveAt) && l.CreatedAt.After(d.CreatedAt))) {
+			continue
+		}
+		latest[d.MenuItemID] = d.PriceChange
+	}
+
+	var skipped []uuid.UUID
+	for _, d := range due {
+		if latest[d.MenuItemID].ID != d.ID {
+			skipped = append(skipped, d.ID)
+		}
+	}
+	return latest, skipped
+}
+
+const priceRuleColumns = `id, restaurant_id, menu_id, name, percent_off, amount_off_minor,
+	(SELECT r.currency FROM restaurants r WHERE r.id = price_rules.restaurant_id), days,
+	to_char(starts_at, 'HH24:MI'), to_char(ends_at, 'HH24:MI'), valid_from, valid_until, created_by, created_at`
+
+func scanPriceRule(row interface{ Scan(...interface{}) error }) (models.PriceRule, error) {
+	var rule models.PriceRule
+	var amountOff sql.NullInt64
+	var currency models.Currency
+	var days []int64
+	err := row.Scan(&rule.ID, &rule.RestaurantID, &rule.MenuItemID, &rule.Name, &rule.PercentOff, &amountOff, &currency,
+		pq.Array(&days), &rule.StartsAt, &rule.EndsAt, &rule.ValidFrom, &rule.ValidUntil, &rule.CreatedBy, &rule.CreatedAt)
+	if amountOff.Valid {
+		off := models.NewMoney(amountOff.Int64, currency)
+		rule.AmountOff = &off
+	}
+	rule.Days = make([]int, len(days))
+	for i, d := range days {
+		rule.Days[i] = int(d)
+	}
+	return rule, err
+}
+
+// ListPriceRules returns the active price rules of a restaurant. With a
+// menu item, only the rules that apply to it.
+func ListPriceRules(ctx context.Context, exec SQLExecutor, restaurantID uuid.UUID, menuID *uuid.UUID) ([]models.PriceRule, error) {
+	rows, err := exec.QueryContext(ctx, `
+		SELECT `+priceRuleColumns+`
+		FROM price_rules
+		WHERE restaurant_id = $1 AND archived_at IS NULL
+			AND ($2::uuid IS NULL OR menu_id IS NULL OR menu_id = $2)
+		ORDER BY created_at`, restaurantID, menuID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []models.PriceRule{}
+	for rows.Next() {
+		rule, err := scanPriceRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// PriceRulesAt returns the price rules of a restaurant that apply to a menu
+// item as they stood at a time: created by then and not yet archived.
+func PriceRulesAt(ctx context.Context, exec SQLExecutor, restaurantID, menuID uuid.UUID, at time.Time) ([]models.PriceRule, error) {
+	rows, err := exec.QueryContext(ctx, `
+		SELECT `+priceRuleColumns+`
+		FROM price_rules
+		WHERE restaurant_id = $1 AND created_at <= $3 AND (archived_at IS NULL OR archived_at > $3)
+			AND (menu_id IS NULL OR menu_id = $2)
+		ORDER BY created_at`, restaurantID, menuID, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []models.PriceRule{}
+	for rows.Next() {
+		rule, err := scanPriceRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// CreatePriceRule returns a *models.CurrencyMismatchError when AmountOff is
+// not in the restaurant's currency.
+func CreatePriceRule(ctx context.Context, exec SQLExecutor, rule models.PriceRule) (models.PriceRule, error) {
+	var amountOff *int64
+	if rule.AmountOff != nil {
+		currency, err := RestaurantCurrency(ctx, exec, rule.RestaurantID)
+		if err != nil {
+			return models.PriceRule{}, err
+		}
+		if rule.AmountOff.Currency != currency {
+			return models.PriceRule{}, &models.CurrencyMismatchError{Left: currency, Right: rule.AmountOff.Currency}
+		}
+		amountOff = &rule.AmountOff.Amount
+	}
+	days := make([]int64, len(rule.Days))
+	for i, d := range rule.Days {
+		days[i] = int64(d)
+	}
+	return scanPriceRule(exec.QueryRowContext(ctx, `
+		INSERT INTO price_rules (restaurant_id, menu_id, name, percent_off, amount_off_minor, days, starts_at, ends_at,
+			valid_from, valid_until, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7::time, $8::time, $9, $10, $11)
+		RETURNING `+priceRuleColumns,
+		rule.RestaurantID, rule.MenuItemID, rule.Name, rule.PercentOff, amountOff, pq.Array(days),
+		rule.StartsAt, rule.EndsAt, rule.ValidFrom, rule.ValidUntil, rule.CreatedBy))
+}
+
+// GetPriceRule returns an active price rule.
+func GetPriceRule(ctx context.Context, exec SQLExecutor, id uuid.UUID) (models.PriceRule, error) {
+	return scanPriceRule(exec.QueryRowContext(ctx, `
+		SELECT `+priceRuleColumns+`
+		FROM price_rules
+		WHERE id = $1 AND archived_at IS NULL`, id))
+}
+
+func ArchivePriceRule(ctx context.Context, exec SQLExecutor, id uuid.UUID) error {
+	res, err := exec.ExecContext(ctx, `UPDATE price_rules SET archived_at = NOW() WHERE id = $1 AND archived_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// BackfillCurrency gives the restaurants that predate currencies the
+// deployment's default, which their prices were entered in. Those prices
+// were converted to hundredths, so for a currency with another number of
+// decimals they are rescaled first. It returns how many restaurants it
+// changed, and should run in a transaction.
+func BackfillCurrency(ctx context.Context, exec SQLExecutor, currency models.Currency) (int64, error) {
+	// another instance starting at the same time waits here, then finds
+	// nothing left to do
+	if _, err := exec.ExecContext(ctx, `SELECT 1 FROM restaurants WHERE currency IS NULL FOR UPDATE`); err != nil {
+		return 0, err
+	}
+	if shift := currency.Exponent() - 2; shift != 0 {
+		// $1 is the factor from hundredths to minor units, such as 0.01 for yen
+		for _, query := range []string{
+			`UPDATE menu SET price_minor = round(price_minor * $1::numeric)
+			WHERE restaurant_id IN (SELECT id FROM restaurants WHERE currency IS NULL)`,
+			`UPDATE menu_prices SET price_minor = round(price_minor * $1::numeric)
+			WHERE menu_id IN (SELECT m.id FROM menu m JOIN restaurants r ON r.id = m.restaurant_id WHERE r.currency IS NULL)`,
+			`UPDATE price_rules SET amount_off_minor = greatest(round(amount_off_minor * $1::numeric), 1)
+			WHERE restaurant_id IN (SELECT id FROM restaurants WHERE currency IS NULL)`,
+		} {
+			if _, err := exec.ExecContext(ctx, query, math.Pow10(shift)); err != nil {
+				return 0, err
+			}
+		}
+	}
+	res, err := exec.ExecContext(ctx, `UPDATE restaurants SET currency = $1 WHERE currency IS NULL`, currency)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RestaurantCurrency returns the currency a restaurant's menu is priced in.
+func RestaurantCurrency(ctx context.Context, exec SQLExecutor, restaurantID uuid.UUID) (models.Currency, error) {
+	var currency models.Currency
+	err := exec.QueryRowContext(ctx, `SELECT currency FROM restaurants WHERE id = $1`, restaurantID).Scan(&currency)
+	return currency, err
+}
+
+// MenuItemCurrency returns the currency a menu item is priced in.
+func MenuItemCurrency(ctx context.Context, exec SQLExecutor, menuID uuid.UUID) (models.Currency, error) {
+	var currency models.Currency
+	err := exec.QueryRowContext(ctx, `
+		SELECT r.currency FROM menu m JOIN restaurants r ON r.id = m.restaurant_id WHERE m.id = $1`, menuID).Scan(&currency)
+	return currency, err
+}
@@ -0,0 +1,73 @@
+package dbhelper
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ray-remotestate/restro/models"
+)
+
+func TestPickDuePrices(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	item, other := uuid.New(), uuid.New()
+	change := func(menuID uuid.UUID, effective, created time.Duration, lastApplied ...time.Duration) duePrice {
+		d := duePrice{PriceChange: models.PriceChange{
+			ID:          uuid.New(),
+			MenuItemID:  menuID,
+			EffectiveAt: now.Add(-effective),
+			CreatedAt:   now.Add(-created),
+		}}
+		if len(lastApplied) > 0 {
+			d.lastApplied = sql.NullTime{Time: now.Add(-lastApplied[0]), Valid: true}
+		}
+		return d
+	}
+
+	older := change(item, 2*time.Hour, 5*time.Hour)
+	newer := change(item, time.Hour, 6*time.Hour)
+	sameTimeEarlier := change(item, time.Hour, 7*time.Hour)
+	sameTimeLater := change(item, time.Hour, 3*time.Hour)
+	otherItem := change(other, 2*time.Hour, 5*time.Hour)
+	overtaken := change(item, 2*time.Hour, 5*time.Hour, time.Hour)
+	afterApplied := change(item, time.Hour, 5*time.Hour, 2*time.Hour)
+	sameAsApplied := change(item, time.Hour, 5*time.Hour, time.Hour)
+
+	tests := []struct {
+		name    string
+		due     []duePrice
+		applied []duePrice
+		skipped []duePrice
+	}{
+		{"one change", []duePrice{older}, []duePrice{older}, nil},
+		{"latest by effective time", []duePrice{older, newer}, []duePrice{newer}, []duePrice{older}},
+		{"latest first", []duePrice{newer, older}, []duePrice{newer}, []duePrice{older}},
+		{"same time, latest created", []duePrice{sameTimeLater, sameTimeEarlier}, []duePrice{sameTimeLater}, []duePrice{sameTimeEarlier}},
+		{"items apart", []duePrice{older, otherItem, newer}, []duePrice{newer, otherItem}, []duePrice{older}},
+		{"older than the applied change", []duePrice{overtaken}, nil, []duePrice{overtaken}},
+		{"newer than the applied change", []duePrice{afterApplied}, []duePrice{afterApplied}, nil},
+		{"as old as the applied change", []duePrice{sameAsApplied}, []duePrice{sameAsApplied}, nil},
+	}
+	for _, tt := range tests {
+		latest, skipped := pickDuePrices(tt.due)
+
+		if len(latest) != len(tt.applied) {
+			t.Errorf("%s: applied %d changes, want %d", tt.name, len(latest), len(tt.applied))
+		}
+		for _, want := range tt.applied {
+			if got := latest[want.MenuItemID]; got.ID != want.ID {
+				t.Errorf("%s: applied %s to the item, want %s", tt.name, got.ID, want.ID)
+			}
+		}
+
+		var wantSkipped []uuid.UUID
+		for _, d := range tt.skipped {
+			wantSkipped = append(wantSkipped, d.ID)
+		}
+		if !slices.Equal(skipped, wantSkipped) {
+			t.Errorf("%s: cancelled %v, want %v", tt.name, skipped, wantSkipped)
+		}
+	}
+}
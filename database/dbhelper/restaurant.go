@@ -1 +0,0 @@
-package dbhelper
\ No newline at end of file
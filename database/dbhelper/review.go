@@ -0,0 +1,264 @@
+package dbhelper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ray-remotestate/restro/models"
+)
+
+const maxReviewLimit = 100
+
+// UnknownDishesError lists menu items, such as the dishes a review rates,
+// that are not on the restaurant's menu.
+type UnknownDishesError struct {
+	IDs []uuid.UUID
+}
+
+func (e *UnknownDishesError) Error() string {
+	ids := make([]string, len(e.IDs))
+	for i, id := range e.IDs {
+		ids[i] = id.String()
+	}
+	return "not on this restaurant's menu: " + strings.Join(ids, ", ")
+}
+
+const reviewColumns = `rv.id, rv.restaurant_id, rv.user_id, u.name, rv.rating, rv.body, rv.status, rv.moderation_reason,
+	rv.moderated_by, rv.moderated_at, rv.reply, rv.replied_by, rv.replied_at, rv.created_at, rv.updated_at`
+
+func scanReview(row interface{ Scan(...interface{}) error }) (models.Review, error) {
+	var rv models.Review
+	err := row.Scan(&rv.ID, &rv.RestaurantID, &rv.UserID, &rv.Author, &rv.Rating, &rv.Body, &rv.Status, &rv.ModerationReason,
+		&rv.ModeratedBy, &rv.ModeratedAt, &rv.Reply, &rv.RepliedBy, &rv.RepliedAt, &rv.CreatedAt, &rv.UpdatedAt)
+	rv.Dishes = []models.DishRating{}
+	return rv, err
+}
+
+// GetReview returns a review with its dish ratings. With forUpdate the
+// review stays locked for the rest of the transaction.
+func GetReview(ctx context.Context, exec SQLExecutor, id uuid.UUID, forUpdate bool) (models.Review, error) {
+	return getReview(ctx, exec, "rv.id = $1", forUpdate, id)
+}
+
+// GetUserReview returns the review a user wrote for a restaurant.
+func GetUserReview(ctx context.Context, exec SQLExecutor, restaurantID, userID uuid.UUID, forUpdate bool) (models.Review, error) {
+	return getReview(ctx, exec, "rv.restaurant_id = $1 AND rv.user_id = $2", forUpdate, restaurantID, userID)
+}
+
+func getReview(ctx context.Context, exec SQLExecutor, where string, forUpdate bool, args ...interface{}) (models.Review, error) {
+	lock := ""
+	if forUpdate {
+		lock = "FOR UPDATE OF rv"
+	}
+	rv, err := scanReview(exec.QueryRowContext(ctx, `
+		SELECT `+reviewColumns+`
+		FROM reviews rv JOIN users u ON u.id = rv.user_id
+		WHERE `+where+`
+		`+lock, args...))
+	if err != nil {
+		return rv, err
+	}
+	reviews := []models.Review{rv}
+	err = loadReviewDishes(ctx, exec, reviews)
+	return reviews[0], err
+}
+
+// ListReviews returns reviews newest first.
+func ListReviews(ctx context.Context, exec SQLExecutor, filter models.ReviewFilter) ([]models.Review, error) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if filter.RestaurantID != uuid.Nil {
+		add("rv.restaurant_id = $%d", filter.RestaurantID)
+	}
+	if len(filter.Statuses) > 0 {
+		statuses := make([]string, len(filter.Statuses))
+		for i, s := range filter.Statuses {
+			statuses[i] = string(s)
+		}
+		add("rv.status = ANY($%d::review_status[])", pq.Array(statuses))
+	} else {
+		conds = append(conds, "rv.status <> 'hidden'")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > maxReviewLimit {
+		limit = maxReviewLimit
+	}
+	args = append(args, limit, filter.Offset)
+
+	rows, err := exec.QueryContext(ctx, fmt.Sprintf(`
+		SELECT `+reviewColumns+`
+		FROM reviews rv JOIN users u ON u.id = rv.user_id
+		WHERE %s
+		ORDER BY rv.created_at DESC, rv.id
+		LIMIT $%d OFFSET $%d`, strings.Join(conds, " AND "), len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []models.Review{}
+	for rows.Next() {
+		rv, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, rv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reviews, loadReviewDishes(ctx, exec, reviews)
+}
+
+func loadReviewDishes(ctx context.Context, exec SQLExecutor, reviews []models.Review) error {
+	if len(reviews) == 0 {
+		return nil
+	}
+	index := make(map[uuid.UUID]int, len(reviews))
+	ids := make([]string, len(reviews))
+	for i, rv := range reviews {
+		index[rv.ID] = i
+		ids[i] = rv.ID.String()
+	}
+
+	rows, err := exec.QueryContext(ctx, `
+		SELECT review_id, menu_id, rating
+		FROM review_dishes
+		WHERE review_id = ANY($1::uuid[])
+		ORDER BY menu_id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reviewID uuid.UUID
+		var d models.DishRating
+		if err := rows.Scan(&reviewID, &d.MenuItemID, &d.Rating); err != nil {
+			return err
+		}
+		i := index[reviewID]
+		reviews[i].Dishes = append(reviews[i].Dishes, d)
+	}
+	return rows.Err()
+}
+
+// SaveReview creates or replaces the caller's review of a restaurant and
+// its dish ratings. Moderation and replies are kept.
+func SaveReview(ctx context.Context, exec SQLExecutor, rv models.Review) (models.Review, error) {
+	var id uuid.UUID
+	err := exec.QueryRowContext(ctx, `
+		INSERT INTO reviews (restaurant_id, user_id, rating, body)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (restaurant_id, user_id) DO UPDATE
+		SET rating = EXCLUDED.rating, body = EXCLUDED.body, updated_at = NOW()
+		RETURNING id`, rv.RestaurantID, rv.UserID, rv.Rating, rv.Body).Scan(&id)
+	if err != nil {
+		return rv, err
+	}
+	if err := setReviewDishes(ctx, exec, id, rv.RestaurantID, rv.Dishes); err != nil {
+		return rv, err
+	}
+	return GetReview(ctx, exec, id, false)
+}
+
+// setReviewDishes replaces the dish ratings of a review, failing with
+// *UnknownDishesError for dishes of other restaurants.
+func setReviewDishes(ctx context.Context, exec SQLExecutor, reviewID, restaurantID uuid.UUID, dishes []models.DishRating) error {
+	ids := make([]string, len(dishes))
+	ratings := make([]int64, len(dishes))
+	for i, d := range dishes {
+		ids[i] = d.MenuItemID.String()
+		ratings[i] = int64(d.Rating)
+	}
+
+	if err := checkDishes(ctx, exec, restaurantID, ids); err != nil {
+		return err
+	}
+
+	// only touch rows that change, so an unchanged set moves no versions
+	if _, err := exec.ExecContext(ctx, `
+		DELETE FROM review_dishes WHERE review_id = $1 AND NOT (menu_id = ANY($2::uuid[]))`,
+		reviewID, pq.Array(ids)); err != nil {
+		return err
+	}
+	_, err := exec.ExecContext(ctx, `
+		INSERT INTO review_dishes (review_id, menu_id, rating)
+		SELECT $1, d.menu_id, d.rating FROM unnest($2::uuid[], $3::smallint[]) AS d(menu_id, rating)
+		ON CONFLICT (review_id, menu_id) DO UPDATE SET rating = EXCLUDED.rating
+		WHERE review_dishes.rating <> EXCLUDED.rating`, reviewID, pq.Array(ids), pq.Array(ratings))
+	return err
+}
+
+func DeleteReview(ctx context.Context, exec SQLExecutor, id uuid.UUID) error {
+	_, err := exec.ExecContext(ctx, `DELETE FROM reviews WHERE id = $1`, id)
+	return err
+}
+
+// ModerateReview sets the status of a review. reason is cleared when a
+// review is made visible again.
+func ModerateReview(ctx context.Context, exec SQLExecutor, id uuid.UUID, status models.ReviewStatus, reason *string, moderatorID uuid.UUID) (models.Review, error) {
+	if _, err := exec.ExecContext(ctx, `
+		UPDATE reviews SET status = $2, moderation_reason = $3, moderated_by = $4, moderated_at = NOW()
+		WHERE id = $1`, id, status, reason, moderatorID); err != nil {
+		return models.Review{}, err
+	}
+	return GetReview(ctx, exec, id, false)
+}
+
+// SetReviewReply sets the restaurant's reply to a review, or removes it
+// when reply is nil.
+func SetReviewReply(ctx context.Context, exec SQLExecutor, id uuid.UUID, reply *string, userID uuid.UUID) (models.Review, error) {
+	if _, err := exec.ExecContext(ctx, `
+		UPDATE reviews
+		SET reply = $2,
+			replied_by = CASE WHEN $2::text IS NULL THEN NULL ELSE $3::uuid END,
+			replied_at = CASE WHEN $2::text IS NULL THEN NULL ELSE NOW() END
+		WHERE id = $1`, id, reply, userID); err != nil {
+		return models.Review{}, err
+	}
+	return GetReview(ctx, exec, id, false)
+}
+
+// RestaurantOwner returns the owner of a restaurant.
+func RestaurantOwner(ctx context.Context, exec SQLExecutor, restaurantID uuid.UUID) (uuid.UUID, error) {
+	var ownerID uuid.UUID
+	err := exec.QueryRowContext(ctx, `SELECT owner_id FROM restaurants WHERE id = $1`, restaurantID).Scan(&ownerID)
+	return ownerID, err
+}
+
+// checkDishes returns an *UnknownDishesError when any of the menu items is
+// not on the restaurant's menu.
+func checkDishes(ctx context.Context, exec SQLExecutor, restaurantID uuid.UUID, ids []string) error {
+	rows, err := exec.QueryContext(ctx, `
+		SELECT d.id FROM unnest($1::uuid[]) AS d(id)
+		WHERE NOT EXISTS (SELECT 1 FROM menu m WHERE m.id = d.id AND m.restaurant_id = $2)`,
+		pq.Array(ids), restaurantID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var unknown []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		unknown = append(unknown, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(unknown) > 0 {
+		return &UnknownDishesError{IDs: unknown}
+	}
+	return nil
+}
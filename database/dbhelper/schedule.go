@@ -0,0 +1,268 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ray-remotestate/restro/models"
+)
+
+var (
+	ErrMenuExists = errors.New("a menu with this name already exists")
+	ErrNoDraft    = errors.New("menu has no draft to publish")
+	ErrEmptyDraft = errors.New("menu draft has no dishes to publish")
+)
+
+const namedMenuColumns = `mn.id, mn.restaurant_id, mn.name, pv.number,
+	(SELECT dv.number FROM menu_versions dv WHERE dv.menu_id = mn.id AND dv.published_at IS NULL),
+	mn.created_by, mn.created_at`
+
+func scanNamedMenu(row interface{ Scan(...interface{}) error }) (models.NamedMenu, error) {
+	var mn models.NamedMenu
+	err := row.Scan(&mn.ID, &mn.RestaurantID, &mn.Name, &mn.PublishedVersion, &mn.DraftVersion, &mn.CreatedBy, &mn.CreatedAt)
+	return mn, err
+}
+
+// CreateNamedMenu adds a menu to a restaurant, with an empty draft as its
+// first version.
+func CreateNamedMenu(ctx context.Context, exec SQLExecutor, restaurantID uuid.UUID, name string, createdBy uuid.UUID) (models.NamedMenu, error) {
+	var id uuid.UUID
+	err := exec.QueryRowContext(ctx, `
+		INSERT INTO menus (restaurant_id, name, created_by) VALUES ($1, $2, $3) RETURNING id`,
+		restaurantID, name, createdBy).Scan(&id)
+	if isUniqueViolation(err) {
+		return models.NamedMenu{}, ErrMenuExists
+	} else if err != nil {
+		return models.NamedMenu{}, err
+	}
+	if _, err := exec.ExecContext(ctx, `
+		INSERT INTO menu_versions (menu_id, number, created_by) VALUES ($1, 1, $2)`, id, createdBy); err != nil {
+		return models.NamedMenu{}, err
+	}
+	return GetNamedMenu(ctx, exec, id, false)
+}
+
+// ListNamedMenus returns a restaurant's menus, archived ones left out,
+// oldest first.
+func ListNamedMenus(ctx context.Context, exec SQLExecutor, restaurantID uuid.UUID) ([]models.NamedMenu, error) {
+	rows, err := exec.QueryContext(ctx, `
+		SELECT `+namedMenuColumns+`
+		FROM menus mn
+		LEFT JOIN menu_versions pv ON pv.id = mn.published_version_id
+		WHERE mn.restaurant_id = $1 AND mn.archived_at IS NULL
+		ORDER BY mn.created_at`, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	menus := []models.NamedMenu{}
+	for rows.Next() {
+		mn, err := scanNamedMenu(rows)
+		if err != nil {
+			return nil, err
+		}
+		menus = append(menus, mn)
+	}
+	return menus, rows.Err()
+}
+
+// GetNamedMenu returns a menu that is not archived. With forUpdate the menu
+// stays locked for the rest of the transaction.
+func GetNamedMenu(ctx context.Context, exec SQLExecutor, id uuid.UUID, forUpdate bool) (models.NamedMenu, error) {
+	lock := ""
+	if forUpdate {
+		lock = "FOR UPDATE OF mn"
+	}
+	return scanNamedMenu(exec.QueryRowContext(ctx, `
+		SELECT `+namedMenuColumns+`
+		FROM menus mn
+		LEFT JOIN menu_versions pv ON pv.id = mn.published_version_id
+		WHERE mn.id = $1 AND mn.archived_at IS NULL
+		`+lock, id))
+}
+
+func ArchiveNamedMenu(ctx context.Context, exec SQLExecutor, id uuid.UUID) error {
+	res, err := exec.ExecContext(ctx, `UPDATE menus SET archived_at = NOW() WHERE id = $1 AND archived_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// menuVersionColumns reads the items and windows of a version as JSON, so
+// a version is one row.
+const menuVersionColumns = `v.id, v.menu_id, v.number,
+	coalesce((
+		SELECT json_agg(vi.item_id ORDER BY vi.position)
+		FROM menu_version_items vi WHERE vi.version_id = v.id
+	), '[]'),
+	coalesce((
+		SELECT json_agg(json_build_object('days', w.days, 'starts_at', to_char(w.starts_at, 'HH24:MI'),
+			'ends_at', to_char(w.ends_at, 'HH24:MI')) ORDER BY w.starts_at, w.id)
+		FROM menu_version_windows w WHERE w.version_id = v.id
+	), '[]'),
+	v.id IS NOT DISTINCT FROM (SELECT mn.published_version_id FROM menus mn WHERE mn.id = v.menu_id),
+	v.created_by, v.created_at, v.published_by, v.published_at`
+
+func scanMenuVersion(row interface{ Scan(...interface{}) error }) (models.MenuVersion, error) {
+	var v models.MenuVersion
+	var items, windows []byte
+	if err := row.Scan(&v.ID, &v.MenuID, &v.Number, &items, &windows, &v.Current,
+		&v.CreatedBy, &v.CreatedAt, &v.PublishedBy, &v.PublishedAt); err != nil {
+		return v, err
+	}
+	if err := json.Unmarshal(items, &v.Items); err != nil {
+		return v, err
+	}
+	err := json.Unmarshal(windows, &v.Windows)
+	return v, err
+}
+
+// ListMenuVersions returns the versions of a menu, latest first.
+func ListMenuVersions(ctx context.Context, exec SQLExecutor, menuID uuid.UUID) ([]models.MenuVersion, error) {
+	rows, err := exec.QueryContext(ctx, `
+		SELECT `+menuVersionColumns+`
+		FROM menu_versions v
+		WHERE v.menu_id = $1
+		ORDER BY v.number DESC`, menuID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []models.MenuVersion{}
+	for rows.Next() {
+		v, err := scanMenuVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+func GetMenuVersion(ctx context.Context, exec SQLExecutor, menuID uuid.UUID, number int) (models.MenuVersion, error) {
+	return scanMenuVersion(exec.QueryRowContext(ctx, `
+		SELECT `+menuVersionColumns+`
+		FROM menu_versions v
+		WHERE v.menu_id = $1 AND v.number = $2`, menuID, number))
+}
+
+// SaveMenuDraft replaces what a menu's draft holds and when it is served,
+// starting a draft after the latest version when there is none. Items must
+// be on the restaurant's menu, else it returns an *UnknownDishesError. The
+// menu should be locked.
+func SaveMenuDraft(ctx context.Context, exec SQLExecutor, menu models.NamedMenu, items []uuid.UUID, windows []models.MenuWindow, createdBy uuid.UUID) (models.MenuVersion, error) {
+	ids := make([]string, len(items))
+	for i, id := range items {
+		ids[i] = id.String()
+	}
+	if err := checkDishes(ctx, exec, menu.RestaurantID, ids); err != nil {
+		return models.MenuVersion{}, err
+	}
+
+	var versionID uuid.UUID
+	var err error
+	if menu.DraftVersion != nil {
+		err = exec.QueryRowContext(ctx, `
+			SELECT id FROM menu_versions WHERE menu_id = $1 AND published_at IS NULL`, menu.ID).Scan(&versionID)
+	} else {
+		err = exec.QueryRowContext(ctx, `
+			INSERT INTO menu_versions (menu_id, number, created_by)
+			SELECT $1, coalesce(max(number), 0) + 1, $2 FROM menu_versions WHERE menu_id = $1
+			RETURNING id`, menu.ID, createdBy).Scan(&versionID)
+	}
+	if err != nil {
+		return models.MenuVersion{}, err
+	}
+
+	if _, err := exec.ExecContext(ctx, `DELETE FROM menu_version_items WHERE version_id = $1`, versionID); err != nil {
+		return models.MenuVersion{}, err
+	}
+	if _, err := exec.ExecContext(ctx, `
+		INSERT INTO menu_version_items (version_id, item_id, position)
+		SELECT $1, d.id, d.position FROM unnest($2::uuid[]) WITH ORDINALITY AS d(id, position)
+		ON CONFLICT (version_id, item_id) DO NOTHING`, versionID, pq.Array(ids)); err != nil {
+		return models.MenuVersion{}, err
+	}
+	if _, err := exec.ExecContext(ctx, `DELETE FROM menu_version_windows WHERE version_id = $1`, versionID); err != nil {
+		return models.MenuVersion{}, err
+	}
+	for _, w := range windows {
+		days := make([]int64, len(w.Days))
+		for i, d := range w.Days {
+			days[i] = int64(d)
+		}
+		if _, err := exec.ExecContext(ctx, `
+			INSERT INTO menu_version_windows (version_id, days, starts_at, ends_at) VALUES ($1, $2, $3::time, $4::time)`,
+			versionID, pq.Array(days), w.StartsAt, w.EndsAt); err != nil {
+			return models.MenuVersion{}, err
+		}
+	}
+	return scanMenuVersion(exec.QueryRowContext(ctx, `
+		SELECT `+menuVersionColumns+`
+		FROM menu_versions v
+		WHERE v.id = $1`, versionID))
+}
+
+// PublishMenuDraft makes a menu's draft the version being served. It
+// returns ErrNoDraft without a draft, and ErrEmptyDraft for one without
+// dishes, which would otherwise be served as nothing all day. The menu
+// should be locked.
+func PublishMenuDraft(ctx context.Context, exec SQLExecutor, menuID, publishedBy uuid.UUID) (models.MenuVersion, error) {
+	var number int
+	var empty bool
+	err := exec.QueryRowContext(ctx, `
+		SELECT v.number, NOT EXISTS (SELECT 1 FROM menu_version_items vi WHERE vi.version_id = v.id)
+		FROM menu_versions v
+		WHERE v.menu_id = $1 AND v.published_at IS NULL`, menuID).Scan(&number, &empty)
+	if err == sql.ErrNoRows {
+		return models.MenuVersion{}, ErrNoDraft
+	} else if err != nil {
+		return models.MenuVersion{}, err
+	}
+	if empty {
+		return models.MenuVersion{}, ErrEmptyDraft
+	}
+	if _, err := exec.ExecContext(ctx, `
+		UPDATE menu_versions SET published_at = NOW(), published_by = $3
+		WHERE menu_id = $1 AND number = $2`, menuID, number, publishedBy); err != nil {
+		return models.MenuVersion{}, err
+	}
+	return serveMenuVersion(ctx, exec, menuID, number)
+}
+
+// RollbackMenu serves an earlier published version of a menu again. It
+// returns sql.ErrNoRows for a version that does not exist or was never
+// published.
+func RollbackMenu(ctx context.Context, exec SQLExecutor, menuID uuid.UUID, number int) (models.MenuVersion, error) {
+	var id uuid.UUID
+	if err := exec.QueryRowContext(ctx, `
+		SELECT id FROM menu_versions WHERE menu_id = $1 AND number = $2 AND published_at IS NOT NULL`,
+		menuID, number).Scan(&id); err != nil {
+		return models.MenuVersion{}, err
+	}
+	return serveMenuVersion(ctx, exec, menuID, number)
+}
+
+func serveMenuVersion(ctx context.Context, exec SQLExecutor, menuID uuid.UUID, number int) (models.MenuVersion, error) {
+	// only a change of version bumps the restaurant's menu version
+	if _, err := exec.ExecContext(ctx, `
+		UPDATE menus SET published_version_id = v.id
+		FROM menu_versions v
+		WHERE menus.id = $1 AND v.menu_id = menus.id AND v.number = $2
+			AND menus.published_version_id IS DISTINCT FROM v.id`, menuID, number); err != nil {
+		return models.MenuVersion{}, err
+	}
+	return GetMenuVersion(ctx, exec, menuID, number)
+}
@@ -0,0 +1,100 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"html"
+	"strings"
+
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/models"
+)
+
+const (
+	// fuzzyThreshold is the pg_trgm word similarity a name needs to match a
+	// query that full-text search missed, such as "biriyani" for "biryani".
+	fuzzyThreshold = "0.4"
+	// fuzzyWeight scales trigram similarity below full-text ranks.
+	fuzzyWeight = 0.6
+
+	// ts_headline cannot escape HTML, so matches are delimited with control
+	// characters and turned into <mark> after escaping.
+	markStart = "\x02"
+	markStop  = "\x03"
+)
+
+var headlineOptions = "StartSel=" + markStart + ", StopSel=" + markStop +
+	`, MaxWords=25, MinWords=8, MaxFragments=2, FragmentDelimiter=" … "`
+
+// SearchCatalog finds up to limit restaurants and dishesLimit dishes whose
+// name or description match query, best first.
+func SearchCatalog(ctx context.Context, query string, limit, dishesLimit int) ([]models.RestaurantMatch, []models.DishMatch, error) {
+	var restaurants []models.RestaurantMatch
+	var dishes []models.DishMatch
+
+	err := database.Tx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`, fuzzyThreshold); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+			WITH q AS (SELECT websearch_to_tsquery('english', $1) AS tsq)
+			SELECT r.id, r.name, coalesce(r.description, ''), r.latitude, r.longitude,
+				GREATEST(ts_rank_cd(r.search_vector, q.tsq, 32), word_similarity($1, r.name) * $3) AS rank,
+				ts_headline('english', r.name || '. ' || coalesce(r.description, ''), q.tsq, $4)
+			FROM restaurants r, q
+			WHERE r.search_vector @@ q.tsq OR $1 <% r.name
+			ORDER BY rank DESC
+			LIMIT $2`, query, limit, fuzzyWeight, headlineOptions)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var m models.RestaurantMatch
+			if err := rows.Scan(&m.ID, &m.Name, &m.Description, &m.Latitude, &m.Longitude, &m.Rank, &m.Snippet); err != nil {
+				return err
+			}
+			m.Snippet = highlight(m.Snippet)
+			restaurants = append(restaurants, m)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows, err = tx.QueryContext(ctx, `
+			WITH q AS (SELECT websearch_to_tsquery('english', $1) AS tsq)
+			SELECT m.id, m.name, coalesce(m.description, ''), (m.price_minor, r.currency), m.orderable,
+				GREATEST(ts_rank_cd(m.search_vector, q.tsq, 32), word_similarity($1, m.name) * $3) AS rank,
+				ts_headline('english', m.name || '. ' || coalesce(m.description, ''), q.tsq, $4),
+				r.id, r.name, coalesce(r.description, ''), r.latitude, r.longitude
+			FROM menu m
+			JOIN restaurants r ON r.id = m.restaurant_id, q
+			WHERE m.search_vector @@ q.tsq OR $1 <% m.name
+			ORDER BY rank DESC
+			LIMIT $2`, query, dishesLimit, fuzzyWeight, headlineOptions)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var m models.DishMatch
+			r := &m.Restaurant
+			if err := rows.Scan(&m.ID, &m.Name, &m.Description, &m.Price, &m.IsAvailable, &m.Rank, &m.Snippet,
+				&r.ID, &r.Name, &r.Description, &r.Latitude, &r.Longitude); err != nil {
+				return err
+			}
+			m.Snippet = highlight(m.Snippet)
+			dishes = append(dishes, m)
+		}
+		return rows.Err()
+	})
+	return restaurants, dishes, err
+}
+
+func highlight(headline string) string {
+	escaped := html.EscapeString(headline)
+	return strings.NewReplacer(markStart, "<mark>", markStop, "</mark>").Replace(escaped)
+}
@@ -0,0 +1,127 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/models"
+)
+
+func CreateSession(ctx context.Context, exec SQLExecutor, sessionID, userID uuid.UUID, refreshTokenHash, userAgent, ip string, expiresAt time.Time) error {
+	_, err := exec.ExecContext(ctx, `
+		INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		sessionID, userID, refreshTokenHash, userAgent, ip, expiresAt)
+	return err
+}
+
+func GetActiveSession(ctx context.Context, sessionID uuid.UUID) (models.Session, error) {
+	var s models.Session
+	err := database.Restro.QueryRowContext(ctx, `
+		SELECT id, user_id, refresh_token_hash, user_agent, ip_address, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()`, sessionID).
+		Scan(&s.ID, &s.UserID, &s.RefreshTokenHash, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+	return s, err
+}
+
+// RotateSession swaps the refresh token of an active session, so a refresh
+// token can only be exchanged once.
+func RotateSession(ctx context.Context, sessionID uuid.UUID, oldHash, newHash, userAgent, ip string, expiresAt time.Time) (bool, error) {
+	res, err := database.Restro.ExecContext(ctx, `
+		UPDATE sessions
+		SET refresh_token_hash = $3, user_agent = $4, ip_address = $5, expires_at = $6, last_seen_at = NOW()
+		WHERE id = $1 AND refresh_token_hash = $2 AND revoked_at IS NULL`,
+		sessionID, oldHash, newHash, userAgent, ip, expiresAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// TouchSession bumps last_seen_at and reports whether the session is still usable.
+func TouchSession(ctx context.Context, sessionID uuid.UUID) (bool, error) {
+	var id uuid.UUID
+	err := database.Restro.QueryRowContext(ctx, `
+		UPDATE sessions SET last_seen_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING id`, sessionID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	rows, err := database.Restro.QueryContext(ctx, `
+		SELECT id, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_seen_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var s models.Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+func RevokeSession(ctx context.Context, exec SQLExecutor, userID, sessionID uuid.UUID) (bool, error) {
+	res, err := exec.ExecContext(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// RevokeSessionsExcept revokes every active session of the user other than
+// keep (pass uuid.Nil to revoke them all) and returns the revoked IDs.
+func RevokeSessionsExcept(ctx context.Context, exec SQLExecutor, userID, keep uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := exec.QueryContext(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+		RETURNING id`, userID, keep)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteStaleSessions removes sessions that expired or were revoked before cutoff.
+func DeleteStaleSessions(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := database.Restro.ExecContext(ctx, `
+		DELETE FROM sessions
+		WHERE expires_at < $1 OR revoked_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,242 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/models"
+)
+
+var ErrTagExists = errors.New("tag already exists")
+
+// UnknownTagsError lists the slugs that name no active tag.
+type UnknownTagsError struct {
+	Slugs []string
+}
+
+func (e *UnknownTagsError) Error() string {
+	return "unknown tags: " + strings.Join(e.Slugs, ", ")
+}
+
+const tagColumns = `id, slug, name, kind, created_at, archived_at`
+
+func scanTag(row interface{ Scan(...interface{}) error }) (models.Tag, error) {
+	var t models.Tag
+	err := row.Scan(&t.ID, &t.Slug, &t.Name, &t.Kind, &t.CreatedAt, &t.ArchivedAt)
+	return t, err
+}
+
+func CreateTag(ctx context.Context, exec SQLExecutor, slug, name string, kind models.TagKind, createdBy uuid.UUID) (models.Tag, error) {
+	tag, err := scanTag(exec.QueryRowContext(ctx, `
+		INSERT INTO tags (slug, name, kind, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+tagColumns, slug, name, kind, createdBy))
+	if isUniqueViolation(err) {
+		return tag, ErrTagExists
+	}
+	return tag, err
+}
+
+// ListTags returns the active tags, of one kind unless kind is empty.
+func ListTags(ctx context.Context, kind models.TagKind) ([]models.Tag, error) {
+	rows, err := database.Restro.QueryContext(ctx, `
+		SELECT `+tagColumns+`
+		FROM tags
+		WHERE archived_at IS NULL AND ($1 = '' OR kind::text = $1)
+		ORDER BY kind, name`, string(kind))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []models.Tag{}
+	for rows.Next() {
+		tag, err := scanTag(rows)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// GetTagForUpdate locks an active tag for the rest of the transaction.
+func GetTagForUpdate(ctx context.Context, tx *sql.Tx, id uuid.UUID) (models.Tag, error) {
+	return scanTag(tx.QueryRowContext(ctx, `
+		SELECT `+tagColumns+`
+		FROM tags
+		WHERE id = $1 AND archived_at IS NULL
+		FOR UPDATE`, id))
+}
+
+func UpdateTag(ctx context.Context, exec SQLExecutor, id uuid.UUID, name string, kind models.TagKind) (models.Tag, error) {
+	return scanTag(exec.QueryRowContext(ctx, `
+		UPDATE tags SET name = $2, kind = $3
+		WHERE id = $1 AND archived_at IS NULL
+		RETURNING `+tagColumns, id, name, kind))
+}
+
+// ArchiveTag retires a tag. It disappears from listings but keeps its
+// taggings, and its slug can be reused.
+func ArchiveTag(ctx context.Context, exec SQLExecutor, id uuid.UUID) error {
+	res, err := exec.ExecContext(ctx, `UPDATE tags SET archived_at = NOW() WHERE id = $1 AND archived_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// tagIDs resolves slugs to active tag IDs, failing with *UnknownTagsError.
+func tagIDs(ctx context.Context, exec SQLExecutor, slugs []string) ([]string, error) {
+	rows, err := exec.QueryContext(ctx, `
+		SELECT s.slug, t.id
+		FROM unnest($1::text[]) AS s(slug)
+		LEFT JOIN tags t ON t.slug = s.slug AND t.archived_at IS NULL`, pq.Array(slugs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	var unknown []string
+	for rows.Next() {
+		var slug string
+		var id uuid.NullUUID
+		if err := rows.Scan(&slug, &id); err != nil {
+			return nil, err
+		}
+		if !id.Valid {
+			unknown = append(unknown, slug)
+			continue
+		}
+		ids = append(ids, id.UUID.String())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(unknown) > 0 {
+		return nil, &UnknownTagsError{Slugs: unknown}
+	}
+	return ids, nil
+}
+
+// UnknownTags returns which of slugs name no active tag.
+func UnknownTags(ctx context.Context, exec SQLExecutor, slugs []string) ([]string, error) {
+	var unknown *UnknownTagsError
+	if _, err := tagIDs(ctx, exec, slugs); errors.As(err, &unknown) {
+		return unknown.Slugs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// SetRestaurantTags replaces the active tags of a restaurant with slugs and
+// returns the ones it had before.
+func SetRestaurantTags(ctx context.Context, exec SQLExecutor, restaurantID uuid.UUID, slugs []string) ([]string, error) {
+	return setTags(ctx, exec, "restaurant_tags", "restaurant_id", restaurantID, slugs)
+}
+
+// SetMenuItemTags replaces the active tags of a menu item with slugs and
+// returns the ones it had before.
+func SetMenuItemTags(ctx context.Context, exec SQLExecutor, menuID uuid.UUID, slugs []string) ([]string, error) {
+	return setTags(ctx, exec, "menu_tags", "menu_id", menuID, slugs)
+}
+
+func setTags(ctx context.Context, exec SQLExecutor, table, column string, id uuid.UUID, slugs []string) ([]string, error) {
+	ids, err := tagIDs(ctx, exec, slugs)
+	if err != nil {
+		return nil, err
+	}
+
+	before := []string{}
+	rows, err := exec.QueryContext(ctx, fmt.Sprintf(`
+		SELECT t.slug FROM %[1]s x JOIN tags t ON t.id = x.tag_id
+		WHERE x.%[2]s = $1 AND t.archived_at IS NULL
+		ORDER BY t.slug`, table, column), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		before = append(before, slug)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// only touch rows that change, so an unchanged set moves no versions
+	if _, err := exec.ExecContext(ctx, fmt.Sprintf(`
+		DELETE FROM %[1]s x USING tags t
+		WHERE x.%[2]s = $1 AND t.id = x.tag_id AND t.archived_at IS NULL AND NOT (x.tag_id = ANY($2))`, table, column),
+		id, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	_, err = exec.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %[1]s (%[2]s, tag_id)
+		SELECT $1, unnest($2::uuid[])
+		ON CONFLICT DO NOTHING`, table, column), id, pq.Array(ids))
+	return before, err
+}
+
+// SetMenuItemAllergens replaces the declared allergens of a menu item and
+// returns the ones it had before.
+func SetMenuItemAllergens(ctx context.Context, exec SQLExecutor, menuID uuid.UUID, allergens []models.Allergen) ([]models.Allergen, error) {
+	values := make([]string, len(allergens))
+	for i, a := range allergens {
+		values[i] = string(a)
+	}
+
+	var before []string
+	err := exec.QueryRowContext(ctx, `SELECT allergens FROM menu WHERE id = $1 FOR UPDATE`, menuID).Scan(pq.Array(&before))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := exec.ExecContext(ctx, `
+		UPDATE menu SET allergens = $2::allergen[]
+		WHERE id = $1 AND allergens IS DISTINCT FROM $2::allergen[]`, menuID, pq.Array(values)); err != nil {
+		return nil, err
+	}
+
+	result := make([]models.Allergen, len(before))
+	for i, a := range before {
+		result[i] = models.Allergen(a)
+	}
+	return result, nil
+}
+
+// RestaurantCreator returns who created a restaurant, or uuid.Nil for ones
+// that predate the record.
+func RestaurantCreator(ctx context.Context, exec SQLExecutor, restaurantID uuid.UUID) (uuid.UUID, error) {
+	var createdBy uuid.NullUUID
+	err := exec.QueryRowContext(ctx, `SELECT created_by FROM restaurants WHERE id = $1`, restaurantID).Scan(&createdBy)
+	return createdBy.UUID, err
+}
+
+// MenuItemCreator returns the restaurant of a menu item and who created it,
+// or uuid.Nil for items that predate the record.
+func MenuItemCreator(ctx context.Context, exec SQLExecutor, menuID uuid.UUID) (restaurantID, createdBy uuid.UUID, err error) {
+	var creator uuid.NullUUID
+	err = exec.QueryRowContext(ctx, `SELECT restaurant_id, created_by FROM menu WHERE id = $1`, menuID).Scan(&restaurantID, &creator)
+	return restaurantID, creator.UUID, err
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -1,41 +1,48 @@
 package dbhelper
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"errors"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"github.com/ray-remotestate/restro/database"
 	"github.com/ray-remotestate/restro/models"
+	"github.com/ray-remotestate/restro/tracing"
 )
 
+var ErrIncorrectPassword = errors.New("incorrect password")
+
+// SQLExecutor is satisfied by both *sql.DB and *sql.Tx.
 type SQLExecutor interface {
-	Exec(query string, args ...interface{}) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
-func CreateUser(tx *sql.Tx, name, email, hashedPassword string) (uuid.UUID, error) {
+func CreateUser(ctx context.Context, tx *sql.Tx, name, email, hashedPassword string) (uuid.UUID, error) {
 	var id uuid.UUID
-	err := tx.QueryRow(`INSERT INTO users (name, email, password, created_by) VALUES ($1, $2, $3, $4) RETURNING id`,
-		name, email, hashedPassword, uuid.Nil).Scan(&id)
+	err := tx.QueryRowContext(ctx, `INSERT INTO users (name, email, password, created_by) VALUES ($1, $2, $3, $4) RETURNING id`,
+		name, email, hashedPassword, nil).Scan(&id)
 	return id, err
 }
 
-func IsUserExists(email string) (bool, error) {
+func IsUserExists(ctx context.Context, email string) (bool, error) {
 	var count int
-	err := database.Restro.QueryRow(`SELECT COUNT(*) FROM users WHERE LOWER(email) = LOWER($1)`, email).Scan(&count)
+	err := database.Restro.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE LOWER(email) = LOWER($1)`, email).Scan(&count)
 	return count > 0, err
 }
 
-func AssignRole(tx *sql.Tx, userID uuid.UUID, role models.Role) error {
-	_, err := tx.Exec(`INSERT INTO user_roles (user_id, role) VALUES ($1, $2)`, userID, role)
+func AssignRole(ctx context.Context, tx *sql.Tx, userID uuid.UUID, role models.Role) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO user_roles (user_id, role) VALUES ($1, $2)`, userID, role)
 	return err
 }
 
-func GetUserByEmail(email string) (uuid.UUID, error) {
+func GetUserByEmail(ctx context.Context, email string) (uuid.UUID, error) {
 	var userID uuid.UUID
 
-	err := database.Restro.QueryRow(`
+	err := database.Restro.QueryRowContext(ctx, `
 		SELECT id FROM users
 		WHERE email = $1 AND archived_at IS NULL`, email).
 		Scan(&userID)
@@ -46,12 +53,12 @@ func GetUserByEmail(email string) (uuid.UUID, error) {
 	return userID, nil
 }
 
-func GetUserByPassword(email, password string) (uuid.UUID, string, error) {
+func GetUserByPassword(ctx context.Context, email, password string) (uuid.UUID, string, error) {
 	var id uuid.UUID
 	var hashedPassword string
 	var name string
 
-	err := database.Restro.QueryRow(`
+	err := database.Restro.QueryRowContext(ctx, `
 		SELECT id, name, password FROM users 
 		WHERE LOWER(email) = LOWER($1) AND archived_at IS NULL`, email).
 		Scan(&id, &name, &hashedPassword)
@@ -59,15 +66,15 @@ func GetUserByPassword(email, password string) (uuid.UUID, string, error) {
 		return uuid.Nil, "", err
 	}
 
-	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) != nil {
-		return uuid.Nil, "", fmt.Errorf("incorrect password")
+	if !comparePassword(ctx, hashedPassword, password) {
+		return uuid.Nil, "", ErrIncorrectPassword
 	}
 
 	return id, name, nil
 }
 
-func GetUserRoleByUserID(userID uuid.UUID) (*sql.Rows, error) {
-	rows, err := database.Restro.Query(`
+func GetUserRoleByUserID(ctx context.Context, userID uuid.UUID) (*sql.Rows, error) {
+	rows, err := database.Restro.QueryContext(ctx, `
 		SELECT role FROM user_roles
 		WHERE user_id = $1 AND archived_at IS NULL`, userID)
 	if err != nil {
@@ -77,9 +84,9 @@ func GetUserRoleByUserID(userID uuid.UUID) (*sql.Rows, error) {
 	return rows, nil
 }
 
-func IsSubAdmin(id uuid.UUID) (bool, error) {
+func IsSubAdmin(ctx context.Context, id uuid.UUID) (bool, error) {
 	var roleExists bool
-	err := database.Restro.QueryRow(`
+	err := database.Restro.QueryRowContext(ctx, `
 		SELECT EXISTS (
 			SELECT 1 FROM user_roles
 			WHERE user_id = $1 AND role = 'subadmin' AND archived_at IS NULL
@@ -91,15 +98,15 @@ func IsSubAdmin(id uuid.UUID) (bool, error) {
 	return roleExists, nil
 }
 
-func MakeSubAdmin(id uuid.UUID) error {
-	_, err := database.Restro.Exec(`
+func MakeSubAdmin(ctx context.Context, exec SQLExecutor, id uuid.UUID) error {
+	_, err := exec.ExecContext(ctx, `
 		INSERT INTO user_roles (user_id, role)
 		VALUES ($1, 'subadmin')`, id)
 	return err
 }
 
-func ListAllSubadmins() (*sql.Rows, error){
-	rows, err := database.Restro.Query(`
+func ListAllSubadmins(ctx context.Context) (*sql.Rows, error){
+	rows, err := database.Restro.QueryContext(ctx, `
 		SELECT u.id, u.name, u.email
 		FROM users u
 		JOIN user_roles ur ON u.id = ur.user_id
@@ -109,4 +116,55 @@ func ListAllSubadmins() (*sql.Rows, error){
 		return &sql.Rows{}, err
 	}
 	return rows, nil
-}
\ No newline at end of file
+}
+
+func GetUserByID(ctx context.Context, id uuid.UUID) (models.User, error) {
+	var user models.User
+	err := database.Restro.QueryRowContext(ctx, `
+		SELECT id, name, email, created_at FROM users
+		WHERE id = $1 AND archived_at IS NULL`, id).
+		Scan(&user.ID, &user.Name, &user.Email, &user.CreatedAt)
+	return user, err
+}
+
+func CheckPassword(ctx context.Context, id uuid.UUID, password string) (bool, error) {
+	var hashedPassword string
+	err := database.Restro.QueryRowContext(ctx, `
+		SELECT password FROM users
+		WHERE id = $1 AND archived_at IS NULL`, id).Scan(&hashedPassword)
+	if err != nil {
+		return false, err
+	}
+
+	return comparePassword(ctx, hashedPassword, password), nil
+}
+
+// comparePassword gets its own span since bcrypt usually dominates login latency.
+func comparePassword(ctx context.Context, hashedPassword, password string) bool {
+	_, span := tracing.Tracer().Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil
+}
+
+func UpdatePassword(ctx context.Context, exec SQLExecutor, id uuid.UUID, hashedPassword string) error {
+	_, err := exec.ExecContext(ctx, `UPDATE users SET password = $2 WHERE id = $1`, id, hashedPassword)
+	return err
+}
+
+// LockAdminBootstrap serialises concurrent attempts to create the first admin
+// until tx ends.
+func LockAdminBootstrap(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('restro_admin_bootstrap'))`)
+	return err
+}
+
+func AdminExists(ctx context.Context, exec SQLExecutor) (bool, error) {
+	var exists bool
+	err := exec.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM user_roles ur
+			JOIN users u ON u.id = ur.user_id
+			WHERE ur.role = 'admin' AND ur.archived_at IS NULL AND u.archived_at IS NULL
+		)`).Scan(&exists)
+	return exists, err
+}
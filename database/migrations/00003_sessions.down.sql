@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS active_sessions;
+DROP TABLE IF EXISTS sessions;
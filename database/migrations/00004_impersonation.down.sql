@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS impersonation_audit_subject;
+DROP INDEX IF EXISTS impersonation_audit_actor;
+DROP TABLE IF EXISTS impersonation_audit;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS impersonation_audit (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    actor_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    subject_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id UUID REFERENCES sessions(id) ON DELETE SET NULL,
+    method VARCHAR(10) NOT NULL,
+    path TEXT NOT NULL,
+    status INT,
+    ip_address TEXT,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS impersonation_audit_actor ON impersonation_audit(actor_id, created_at);
+CREATE INDEX IF NOT EXISTS impersonation_audit_subject ON impersonation_audit(subject_id, created_at);
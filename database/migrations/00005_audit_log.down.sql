@@ -0,0 +1,6 @@
+ALTER TABLE restaurants DROP COLUMN IF EXISTS created_by;
+
+DROP INDEX IF EXISTS audit_log_created;
+DROP INDEX IF EXISTS audit_log_resource;
+DROP INDEX IF EXISTS audit_log_actor;
+DROP TABLE IF EXISTS audit_log;
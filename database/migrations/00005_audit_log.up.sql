@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    impersonator_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(100) NOT NULL,
+    resource_type VARCHAR(50) NOT NULL,
+    resource_id TEXT,
+    before JSONB,
+    after JSONB,
+    diff JSONB,
+    ip_address TEXT,
+    request_id TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS audit_log_actor ON audit_log(actor_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_resource ON audit_log(resource_type, resource_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_created ON audit_log(created_at);
+
+ALTER TABLE restaurants ADD COLUMN IF NOT EXISTS created_by UUID REFERENCES users(id);
@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS restaurants_updated_at ON restaurants;
+DROP FUNCTION IF EXISTS set_updated_at();
+DROP TRIGGER IF EXISTS menu_version ON menu;
+DROP FUNCTION IF EXISTS bump_menu_version();
+ALTER TABLE restaurants
+    DROP COLUMN IF EXISTS menu_updated_at,
+    DROP COLUMN IF EXISTS menu_version,
+    DROP COLUMN IF EXISTS updated_at;
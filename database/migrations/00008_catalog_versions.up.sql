@@ -0,0 +1,34 @@
+ALTER TABLE restaurants
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS menu_version BIGINT NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS menu_updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+-- menu_version changes with every write to a restaurant's menu, so it can
+-- serve as the ETag of the menu without reading it
+CREATE OR REPLACE FUNCTION bump_menu_version() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        UPDATE restaurants SET menu_version = menu_version + 1, menu_updated_at = NOW()
+        WHERE id = OLD.restaurant_id;
+    END IF;
+    IF TG_OP = 'INSERT' OR (TG_OP = 'UPDATE' AND NEW.restaurant_id <> OLD.restaurant_id) THEN
+        UPDATE restaurants SET menu_version = menu_version + 1, menu_updated_at = NOW()
+        WHERE id = NEW.restaurant_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER menu_version AFTER INSERT OR UPDATE OR DELETE ON menu
+    FOR EACH ROW EXECUTE FUNCTION bump_menu_version();
+
+CREATE OR REPLACE FUNCTION set_updated_at() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = NOW();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- only the listed columns, so menu version bumps leave updated_at alone
+CREATE TRIGGER restaurants_updated_at BEFORE UPDATE OF name, owner_id, description, latitude, longitude ON restaurants
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
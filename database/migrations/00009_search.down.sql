@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS menu_name_trgm;
+DROP INDEX IF EXISTS restaurants_name_trgm;
+DROP INDEX IF EXISTS menu_search;
+DROP INDEX IF EXISTS restaurants_search;
+ALTER TABLE menu DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE restaurants DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,20 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE restaurants ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(description, '')), 'B')
+    ) STORED;
+
+ALTER TABLE menu ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(description, '')), 'B')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS restaurants_search ON restaurants USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS menu_search ON menu USING GIN (search_vector);
+
+-- trigram indexes back the fuzzy match on names for misspelled queries
+CREATE INDEX IF NOT EXISTS restaurants_name_trgm ON restaurants USING GIN (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS menu_name_trgm ON menu USING GIN (name gin_trgm_ops);
@@ -0,0 +1,12 @@
+DROP TRIGGER IF EXISTS tags_touch ON tags;
+DROP FUNCTION IF EXISTS touch_tag_users();
+DROP TRIGGER IF EXISTS menu_tags_touch ON menu_tags;
+DROP FUNCTION IF EXISTS touch_tagged_menu();
+DROP TRIGGER IF EXISTS restaurant_tags_touch ON restaurant_tags;
+DROP FUNCTION IF EXISTS touch_tagged_restaurant();
+ALTER TABLE menu DROP COLUMN IF EXISTS allergens;
+DROP TYPE IF EXISTS allergen;
+DROP TABLE IF EXISTS menu_tags;
+DROP TABLE IF EXISTS restaurant_tags;
+DROP TABLE IF EXISTS tags;
+DROP TYPE IF EXISTS tag_kind;
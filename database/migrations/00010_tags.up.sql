@@ -0,0 +1,93 @@
+CREATE TYPE tag_kind AS ENUM (
+    'cuisine',
+    'dietary',
+    'label'
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    slug VARCHAR(50) NOT NULL CHECK (slug ~ '^[a-z0-9]+(-[a-z0-9]+)*$'),
+    name VARCHAR(100) NOT NULL,
+    kind tag_kind NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    archived_at TIMESTAMP WITH TIME ZONE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS active_tag ON tags(slug) WHERE archived_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS restaurant_tags (
+    restaurant_id UUID NOT NULL REFERENCES restaurants(id) ON DELETE CASCADE,
+    tag_id UUID NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (restaurant_id, tag_id)
+);
+CREATE INDEX IF NOT EXISTS restaurant_tags_tag ON restaurant_tags(tag_id);
+
+CREATE TABLE IF NOT EXISTS menu_tags (
+    menu_id UUID NOT NULL REFERENCES menu(id) ON DELETE CASCADE,
+    tag_id UUID NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (menu_id, tag_id)
+);
+CREATE INDEX IF NOT EXISTS menu_tags_tag ON menu_tags(tag_id);
+
+-- the 14 allergens food businesses must declare under EU FIC and UK law
+CREATE TYPE allergen AS ENUM (
+    'celery',
+    'crustaceans',
+    'eggs',
+    'fish',
+    'gluten',
+    'lupin',
+    'milk',
+    'molluscs',
+    'mustard',
+    'nuts',
+    'peanuts',
+    'sesame',
+    'soya',
+    'sulphites'
+);
+
+ALTER TABLE menu ADD COLUMN IF NOT EXISTS allergens allergen[] NOT NULL DEFAULT '{}';
+
+-- tags are part of the listings, so tagging moves the same versions that
+-- edits to the restaurant or its menu do
+CREATE OR REPLACE FUNCTION touch_tagged_restaurant() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE restaurants SET updated_at = NOW()
+    WHERE id = CASE WHEN TG_OP = 'DELETE' THEN OLD.restaurant_id ELSE NEW.restaurant_id END;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER restaurant_tags_touch AFTER INSERT OR DELETE ON restaurant_tags
+    FOR EACH ROW EXECUTE FUNCTION touch_tagged_restaurant();
+
+CREATE OR REPLACE FUNCTION touch_tagged_menu() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE restaurants SET menu_version = menu_version + 1, menu_updated_at = NOW()
+    WHERE id = (
+        SELECT restaurant_id FROM menu
+        WHERE id = CASE WHEN TG_OP = 'DELETE' THEN OLD.menu_id ELSE NEW.menu_id END
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER menu_tags_touch AFTER INSERT OR DELETE ON menu_tags
+    FOR EACH ROW EXECUTE FUNCTION touch_tagged_menu();
+
+CREATE OR REPLACE FUNCTION touch_tag_users() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE restaurants SET updated_at = NOW()
+    WHERE id IN (SELECT restaurant_id FROM restaurant_tags WHERE tag_id = NEW.id);
+    UPDATE restaurants SET menu_version = menu_version + 1, menu_updated_at = NOW()
+    WHERE id IN (
+        SELECT m.restaurant_id FROM menu_tags mt JOIN menu m ON m.id = mt.menu_id
+        WHERE mt.tag_id = NEW.id
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER tags_touch AFTER UPDATE OF name, kind, archived_at ON tags
+    FOR EACH ROW EXECUTE FUNCTION touch_tag_users();
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS menu_images_touch ON images;
+DROP TRIGGER IF EXISTS restaurant_images_touch ON images;
+DROP TABLE IF EXISTS images;
+DROP TYPE IF EXISTS image_slot;
@@ -0,0 +1,30 @@
+CREATE TYPE image_slot AS ENUM (
+    'logo',
+    'banner',
+    'photo'
+);
+
+-- the renditions live in the blob store under images/<id>/<variant>
+CREATE TABLE IF NOT EXISTS images (
+    id UUID PRIMARY KEY,
+    restaurant_id UUID REFERENCES restaurants(id) ON DELETE CASCADE,
+    menu_id UUID REFERENCES menu(id) ON DELETE CASCADE,
+    slot image_slot NOT NULL,
+    format VARCHAR(10) NOT NULL,
+    width INT NOT NULL,
+    height INT NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK ((restaurant_id IS NULL) <> (menu_id IS NULL)),
+    CHECK ((menu_id IS NULL) = (slot <> 'photo'))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS restaurant_image ON images(restaurant_id, slot) WHERE restaurant_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS menu_image ON images(menu_id) WHERE menu_id IS NOT NULL;
+
+-- images are part of the listings like tags; the functions from 00010 only
+-- read restaurant_id and menu_id, and one of them is NULL here
+CREATE TRIGGER restaurant_images_touch AFTER INSERT OR DELETE ON images
+    FOR EACH ROW EXECUTE FUNCTION touch_tagged_restaurant();
+
+CREATE TRIGGER menu_images_touch AFTER INSERT OR DELETE ON images
+    FOR EACH ROW EXECUTE FUNCTION touch_tagged_menu();
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS review_dishes_touch ON review_dishes;
+DROP TRIGGER IF EXISTS reviews_touch_update ON reviews;
+DROP TRIGGER IF EXISTS reviews_touch ON reviews;
+DROP FUNCTION IF EXISTS touch_reviewed_restaurant();
+DROP TABLE IF EXISTS review_dishes;
+DROP TABLE IF EXISTS reviews;
+DROP TYPE IF EXISTS review_status;
@@ -0,0 +1,63 @@
+CREATE TYPE review_status AS ENUM (
+    'visible',
+    'flagged',
+    'hidden'
+);
+
+CREATE TABLE IF NOT EXISTS reviews (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    restaurant_id UUID NOT NULL REFERENCES restaurants(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    body TEXT NOT NULL DEFAULT '' CHECK (length(body) <= 4000),
+    status review_status NOT NULL DEFAULT 'visible',
+    moderation_reason TEXT,
+    moderated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    moderated_at TIMESTAMP WITH TIME ZONE,
+    reply TEXT CHECK (length(reply) <= 4000),
+    replied_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    replied_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS one_review_per_user ON reviews(restaurant_id, user_id);
+CREATE INDEX IF NOT EXISTS reviews_restaurant ON reviews(restaurant_id, created_at);
+CREATE INDEX IF NOT EXISTS reviews_moderation ON reviews(status, created_at) WHERE status <> 'visible';
+
+CREATE TABLE IF NOT EXISTS review_dishes (
+    review_id UUID NOT NULL REFERENCES reviews(id) ON DELETE CASCADE,
+    menu_id UUID NOT NULL REFERENCES menu(id) ON DELETE CASCADE,
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    PRIMARY KEY (review_id, menu_id)
+);
+CREATE INDEX IF NOT EXISTS review_dishes_menu ON review_dishes(menu_id);
+
+-- ratings are part of the listings: a review moves the restaurant's
+-- updated_at, and its menu version too when it rates dishes
+CREATE OR REPLACE FUNCTION touch_reviewed_restaurant() RETURNS TRIGGER AS $$
+DECLARE
+    review reviews;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        review := OLD;
+    ELSE
+        review := NEW;
+    END IF;
+    UPDATE restaurants SET updated_at = NOW() WHERE id = review.restaurant_id;
+    IF TG_OP = 'UPDATE' AND OLD.status IS DISTINCT FROM NEW.status
+        AND EXISTS (SELECT 1 FROM review_dishes WHERE review_id = review.id) THEN
+        UPDATE restaurants SET menu_version = menu_version + 1, menu_updated_at = NOW()
+        WHERE id = review.restaurant_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER reviews_touch AFTER INSERT OR DELETE ON reviews
+    FOR EACH ROW EXECUTE FUNCTION touch_reviewed_restaurant();
+CREATE TRIGGER reviews_touch_update AFTER UPDATE OF rating, status ON reviews
+    FOR EACH ROW WHEN (OLD.rating IS DISTINCT FROM NEW.rating OR OLD.status IS DISTINCT FROM NEW.status)
+    EXECUTE FUNCTION touch_reviewed_restaurant();
+
+CREATE TRIGGER review_dishes_touch AFTER INSERT OR DELETE OR UPDATE OF rating ON review_dishes
+    FOR EACH ROW EXECUTE FUNCTION touch_tagged_menu();
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS menu_daily_reset;
+ALTER TABLE menu
+    DROP COLUMN IF EXISTS orderable,
+    DROP COLUMN IF EXISTS eighty_sixed_at,
+    DROP COLUMN IF EXISTS stock_reset_at,
+    DROP COLUMN IF EXISTS daily_stock,
+    DROP COLUMN IF EXISTS stock;
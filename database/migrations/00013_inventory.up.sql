@@ -0,0 +1,15 @@
+-- stock is NULL for items whose stock is not tracked; daily_stock, when set,
+-- is what stock goes back to at every daily reset
+ALTER TABLE menu
+    ADD COLUMN IF NOT EXISTS stock INTEGER CHECK (stock >= 0),
+    ADD COLUMN IF NOT EXISTS daily_stock INTEGER CHECK (daily_stock >= 0),
+    ADD COLUMN IF NOT EXISTS stock_reset_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS eighty_sixed_at TIMESTAMP WITH TIME ZONE;
+
+-- is_available stays the manual switch; orderable is what listings show
+ALTER TABLE menu
+    ADD COLUMN IF NOT EXISTS orderable BOOLEAN GENERATED ALWAYS AS (
+        coalesce(is_available, TRUE) AND eighty_sixed_at IS NULL AND coalesce(stock > 0, TRUE)
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS menu_daily_reset ON menu(id) WHERE daily_stock IS NOT NULL OR eighty_sixed_at IS NOT NULL;
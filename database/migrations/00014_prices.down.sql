@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS price_rules;
+DROP TRIGGER IF EXISTS menu_initial_price ON menu;
+DROP FUNCTION IF EXISTS record_initial_price();
+DROP TABLE IF EXISTS menu_prices;
+
+ALTER TABLE menu ALTER COLUMN price_minor TYPE NUMERIC(10,2) USING price_minor / 100.0;
+ALTER TABLE menu RENAME COLUMN price_minor TO price;
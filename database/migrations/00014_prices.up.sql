@@ -0,0 +1,54 @@
+-- prices move to integer minor units (cents)
+ALTER TABLE menu RENAME COLUMN price TO price_minor;
+ALTER TABLE menu ALTER COLUMN price_minor TYPE BIGINT USING round(price_minor * 100)::BIGINT;
+
+-- every price a menu item had, has and is scheduled to have; price_minor on
+-- menu is the latest applied one
+CREATE TABLE IF NOT EXISTS menu_prices (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    menu_id UUID NOT NULL REFERENCES menu(id) ON DELETE CASCADE,
+    price_minor BIGINT NOT NULL CHECK (price_minor >= 0),
+    effective_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE,
+    cancelled_at TIMESTAMP WITH TIME ZONE,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (applied_at IS NULL OR cancelled_at IS NULL)
+);
+CREATE INDEX IF NOT EXISTS menu_prices_menu ON menu_prices(menu_id, effective_at);
+CREATE INDEX IF NOT EXISTS menu_prices_due ON menu_prices(effective_at) WHERE applied_at IS NULL AND cancelled_at IS NULL;
+
+INSERT INTO menu_prices (menu_id, price_minor, effective_at, applied_at, created_by, created_at)
+SELECT id, price_minor, coalesce(created_at, NOW()), coalesce(created_at, NOW()), created_by, coalesce(created_at, NOW())
+FROM menu;
+
+-- new items start their history with the price they are created with
+CREATE OR REPLACE FUNCTION record_initial_price() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO menu_prices (menu_id, price_minor, effective_at, applied_at, created_by)
+    VALUES (NEW.id, NEW.price_minor, NOW(), NOW(), NEW.created_by);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER menu_initial_price AFTER INSERT ON menu
+    FOR EACH ROW EXECUTE FUNCTION record_initial_price();
+
+CREATE TABLE IF NOT EXISTS price_rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    restaurant_id UUID NOT NULL REFERENCES restaurants(id) ON DELETE CASCADE,
+    menu_id UUID REFERENCES menu(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    percent_off SMALLINT CHECK (percent_off BETWEEN 1 AND 100),
+    amount_off_minor BIGINT CHECK (amount_off_minor > 0),
+    days SMALLINT[] NOT NULL CHECK (days <@ '{1,2,3,4,5,6,7}' AND cardinality(days) > 0),
+    starts_at TIME NOT NULL,
+    ends_at TIME NOT NULL CHECK (ends_at <> starts_at),
+    valid_from TIMESTAMP WITH TIME ZONE,
+    valid_until TIMESTAMP WITH TIME ZONE CHECK (valid_until > valid_from),
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    archived_at TIMESTAMP WITH TIME ZONE,
+    CHECK ((percent_off IS NULL) <> (amount_off_minor IS NULL))
+);
+CREATE INDEX IF NOT EXISTS price_rules_restaurant ON price_rules(restaurant_id) WHERE archived_at IS NULL;
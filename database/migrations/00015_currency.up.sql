@@ -0,0 +1,6 @@
+-- prices are stored in minor units of the restaurant's currency. Existing
+-- restaurants are left without one: SQL cannot read DEFAULT_CURRENCY, so the
+-- server fills it in on startup (dbhelper.BackfillCurrency) before serving.
+-- New restaurants always name theirs.
+ALTER TABLE restaurants
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) CHECK (currency ~ '^[A-Z]{3}$');
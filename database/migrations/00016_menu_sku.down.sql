@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS menu_sku;
+ALTER TABLE menu DROP COLUMN IF EXISTS sku;
+CREATE UNIQUE INDEX IF NOT EXISTS unique_menu ON menu(restaurant_id);
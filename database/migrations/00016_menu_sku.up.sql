@@ -0,0 +1,8 @@
+-- unique_menu allowed a single item per restaurant
+DROP INDEX IF EXISTS unique_menu;
+
+-- the SKU identifies an item within its restaurant across bulk imports;
+-- items that were not given one get a random one
+ALTER TABLE menu
+    ADD COLUMN IF NOT EXISTS sku VARCHAR(64) NOT NULL DEFAULT gen_random_uuid()::text CHECK (sku <> '');
+CREATE UNIQUE INDEX IF NOT EXISTS menu_sku ON menu(restaurant_id, sku);
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS menus_version ON menus;
+DROP TABLE IF EXISTS menu_version_windows;
+DROP TABLE IF EXISTS menu_version_items;
+ALTER TABLE IF EXISTS menus DROP CONSTRAINT IF EXISTS menus_published_version;
+DROP TABLE IF EXISTS menu_versions;
+DROP TABLE IF EXISTS menus;
@@ -0,0 +1,53 @@
+-- named menus, such as breakfast or dinner, group a restaurant's dishes by
+-- when they are served; restaurants without a published one serve every dish
+CREATE TABLE IF NOT EXISTS menus (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    restaurant_id UUID NOT NULL REFERENCES restaurants(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    published_version_id UUID,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    archived_at TIMESTAMP WITH TIME ZONE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS menus_name ON menus(restaurant_id, lower(name)) WHERE archived_at IS NULL;
+
+-- versions are numbered per menu; the one not yet published is the draft,
+-- and published ones never change, so any of them can be put back
+CREATE TABLE IF NOT EXISTS menu_versions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    menu_id UUID NOT NULL REFERENCES menus(id) ON DELETE CASCADE,
+    number INTEGER NOT NULL CHECK (number > 0),
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    published_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    published_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (menu_id, number)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS menu_versions_draft ON menu_versions(menu_id) WHERE published_at IS NULL;
+
+ALTER TABLE menus
+    ADD CONSTRAINT menus_published_version FOREIGN KEY (published_version_id) REFERENCES menu_versions(id);
+
+CREATE TABLE IF NOT EXISTS menu_version_items (
+    version_id UUID NOT NULL REFERENCES menu_versions(id) ON DELETE CASCADE,
+    item_id UUID NOT NULL REFERENCES menu(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (version_id, item_id)
+);
+CREATE INDEX IF NOT EXISTS menu_version_items_item ON menu_version_items(item_id);
+
+-- days are ISO weekdays; a window that ends before it starts runs past
+-- midnight. A version without windows is served all day.
+CREATE TABLE IF NOT EXISTS menu_version_windows (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    version_id UUID NOT NULL REFERENCES menu_versions(id) ON DELETE CASCADE,
+    days SMALLINT[] NOT NULL CHECK (cardinality(days) > 0 AND days <@ ARRAY[1, 2, 3, 4, 5, 6, 7]::smallint[]),
+    starts_at TIME NOT NULL,
+    ends_at TIME NOT NULL,
+    CHECK (starts_at <> ends_at)
+);
+CREATE INDEX IF NOT EXISTS menu_version_windows_version ON menu_version_windows(version_id);
+
+-- publishing, rolling back and archiving change what the catalog serves
+CREATE TRIGGER menus_version AFTER UPDATE OF published_version_id, archived_at OR DELETE ON menus
+    FOR EACH ROW EXECUTE FUNCTION bump_menu_version();
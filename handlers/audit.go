@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/middlewares"
+	"github.com/ray-remotestate/restro/models"
+	"github.com/ray-remotestate/restro/utils"
+)
+
+// recordAudit writes an audit entry through exec, which should be the
+// transaction performing the change. actorID may be uuid.Nil, in which case
+// the authenticated user is used.
+func recordAudit(ctx context.Context, exec dbhelper.SQLExecutor, r *http.Request, actorID uuid.UUID, action, resourceType, resourceID string, before, after interface{}) error {
+	entry := models.AuditLog{
+		ActorID:      actorID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		IPAddress:    middlewares.ClientIP(r),
+		RequestID:    middlewares.GetRequestID(r.Context()),
+	}
+
+	if claims, err := middlewares.GetAuthenticatedUser(r); err == nil {
+		if entry.ActorID == uuid.Nil {
+			entry.ActorID = claims.UserID
+		}
+		if claims.IsImpersonated() {
+			entry.ImpersonatorID = &claims.ActorID
+		}
+	}
+
+	var err error
+	if before != nil {
+		if entry.Before, err = json.Marshal(before); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		if entry.After, err = json.Marshal(after); err != nil {
+			return err
+		}
+	}
+	if entry.Diff, err = utils.JSONDiff(before, after); err != nil {
+		return err
+	}
+
+	return dbhelper.InsertAuditLog(ctx, exec, entry)
+}
+
+func ListAuditLogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		ResourceType: query.Get("resource_type"),
+		ResourceID:   query.Get("resource_id"),
+	}
+
+	var err error
+	if v := query.Get("actor_id"); v != "" {
+		if filter.ActorID, err = uuid.Parse(v); err != nil {
+			http.Error(w, "invalid actor_id", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "invalid from, expected RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "invalid to, expected RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	logs, err := dbhelper.ListAuditLogs(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "failed to query audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logs)
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ray-remotestate/restro/cache"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/models"
+)
+
+type restaurantListing struct {
+	ID          uuid.UUID                      `json:"id"`
+	Name        string                         `json:"name"`
+	Description string                         `json:"description"`
+	Latitude    float64                        `json:"latitude"`
+	Longitude   float64                        `json:"longitude"`
+	Currency    models.Currency                `json:"currency"`
+	Tags        []string                       `json:"tags"`
+	Images      map[models.ImageSlot]imageURLs `json:"images"`
+	Rating      rating                         `json:"rating"`
+	CreatedAt   time.Time                      `json:"created_at"`
+}
+
+type dish struct {
+	ID          uuid.UUID         `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Price       models.Money      `json:"price"`
+	IsAvailable bool              `json:"is_available"`
+	Tags        []string          `json:"tags"`
+	Allergens   []models.Allergen `json:"allergens"`
+	Image       imageURLs         `json:"image"` // null without a photo
+	Rating      rating            `json:"rating"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// restaurantsEntry and menuEntry are what the catalog cache holds.
+type restaurantsEntry struct {
+	Restaurants  []restaurantListing `json:"restaurants"`
+	LastModified time.Time           `json:"last_modified"`
+}
+
+type menuEntry struct {
+	Version   int64           `json:"version"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Dishes    []dish          `json:"dishes"`
+	Schedules []scheduledMenu `json:"schedules"` // published named menus
+}
+
+// scheduledMenu is the published version of a named menu.
+type scheduledMenu struct {
+	ID      uuid.UUID           `json:"id"`
+	Name    string              `json:"name"`
+	Items   []uuid.UUID         `json:"items"`
+	Windows []models.MenuWindow `json:"windows"`
+}
+
+const (
+	restaurantsCacheKey = "restaurants"
+	tagsCacheKey        = "tags"
+)
+
+func menuCacheKey(restaurantID uuid.UUID) string {
+	return "menu:" + restaurantID.String()
+}
+
+func loadRestaurants(ctx context.Context) (restaurantsEntry, error) {
+	var entry restaurantsEntry
+	data, err := cache.Catalog.Get(ctx, restaurantsCacheKey, func(ctx context.Context) ([]byte, error) {
+		rows, err := database.Restro.QueryContext(ctx, `
+			SELECT r.id, r.name, r.description, r.latitude, r.longitude, r.currency, r.created_at, r.updated_at,
+				coalesce((
+					SELECT array_agg(t.slug ORDER BY t.slug)
+					FROM restaurant_tags rt JOIN tags t ON t.id = rt.tag_id
+					WHERE rt.restaurant_id = r.id AND t.archived_at IS NULL
+				), '{}'),
+				coalesce((SELECT json_object_agg(i.slot, i.id) FROM images i WHERE i.restaurant_id = r.id), '{}'),
+				rating.average, rating.count
+			FROM restaurants r
+			CROSS JOIN LATERAL (
+				SELECT coalesce(round(avg(rv.rating), 2), 0)::float8 AS average, count(*) AS count
+				FROM reviews rv
+				WHERE rv.restaurant_id = r.id AND rv.status <> 'hidden'
+			) rating
+			ORDER BY r.created_at DESC
+		`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var entry restaurantsEntry
+		for rows.Next() {
+			var r restaurantListing
+			var updatedAt time.Time
+			var images []byte
+			if err := rows.Scan(&r.ID, &r.Name, &r.Description, &r.Latitude, &r.Longitude, &r.Currency, &r.CreatedAt, &updatedAt, pq.Array(&r.Tags), &images,
+				&r.Rating.Average, &r.Rating.Count); err != nil {
+				return nil, err
+			}
+			var imageIDs map[models.ImageSlot]uuid.UUID
+			if err := json.Unmarshal(images, &imageIDs); err != nil {
+				return nil, err
+			}
+			r.Images = map[models.ImageSlot]imageURLs{}
+			for slot, id := range imageIDs {
+				r.Images[slot] = newImageURLs(id)
+			}
+			entry.Restaurants = append(entry.Restaurants, r)
+			if updatedAt.After(entry.LastModified) {
+				entry.LastModified = updatedAt
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return json.Marshal(entry)
+	})
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(data, &entry)
+	return entry, err
+}
+
+// loadMenu returns sql.ErrNoRows when the restaurant does not exist.
+func loadMenu(ctx context.Context, restaurantID uuid.UUID) (menuEntry, error) {
+	var entry menuEntry
+	data, err := cache.Catalog.Get(ctx, menuCacheKey(restaurantID), func(ctx context.Context) ([]byte, error) {
+		// one statement, so the version and the dishes come from the same snapshot
+		rows, err := database.Restro.QueryContext(ctx, `
+			SELECT r.menu_version, r.menu_updated_at, r.currency, m.id, m.name, m.description, m.price_minor, m.orderable, m.created_at,
+				m.allergens, coalesce((
+					SELECT array_agg(t.slug ORDER BY t.slug)
+					FROM menu_tags mt JOIN tags t ON t.id = mt.tag_id
+					WHERE mt.menu_id = m.id AND t.archived_at IS NULL
+				), '{}'),
+				(SELECT i.id FROM images i WHERE i.menu_id = m.id),
+				rating.average, rating.count, s.schedules
+			FROM restaurants r
+			CROSS JOIN LATERAL (
+				SELECT coalesce(json_agg(json_build_object(
+					'id', mn.id,
+					'name', mn.name,
+					'items', coalesce((
+						SELECT json_agg(vi.item_id ORDER BY vi.position)
+						FROM menu_version_items vi WHERE vi.version_id = mn.published_version_id
+					), '[]'),
+					'windows', coalesce((
+						SELECT json_agg(json_build_object('days', w.days, 'starts_at', to_char(w.starts_at, 'HH24:MI'),
+							'ends_at', to_char(w.ends_at, 'HH24:MI')))
+						FROM menu_version_windows w WHERE w.version_id = mn.published_version_id
+					), '[]')
+				) ORDER BY mn.created_at), '[]') AS schedules
+				FROM menus mn
+				WHERE mn.restaurant_id = r.id AND mn.published_version_id IS NOT NULL AND mn.archived_at IS NULL
+			) s
+			LEFT JOIN menu m ON m.restaurant_id = r.id
+			CROSS JOIN LATERAL (
+				SELECT coalesce(round(avg(rd.rating), 2), 0)::float8 AS average, count(*) AS count
+				FROM review_dishes rd JOIN reviews rv ON rv.id = rd.review_id
+				WHERE rd.menu_id = m.id AND rv.status <> 'hidden'
+			) rating
+			WHERE r.id = $1
+			ORDER BY m.created_at DESC
+		`, restaurantID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var entry menuEntry
+		found := false
+		for rows.Next() {
+			var id uuid.NullUUID
+			var name, description sql.NullString
+			var currency models.Currency
+			var price sql.NullInt64
+			var isAvailable sql.NullBool
+			var createdAt sql.NullTime
+			var allergens, tags []string
+			var imageID uuid.NullUUID
+			var dishRating rating
+			var schedules []byte
+			if err := rows.Scan(&entry.Version, &entry.UpdatedAt, &currency, &id, &name, &description, &price, &isAvailable, &createdAt,
+				pq.Array(&allergens), pq.Array(&tags), &imageID, &dishRating.Average, &dishRating.Count, &schedules); err != nil {
+				return nil, err
+			}
+			if !found {
+				// every row carries the restaurant's schedules
+				if err := json.Unmarshal(schedules, &entry.Schedules); err != nil {
+					return nil, err
+				}
+				found = true
+			}
+			if !id.Valid {
+				continue // restaurant without dishes
+			}
+			var image imageURLs
+			if imageID.Valid {
+				image = newImageURLs(imageID.UUID)
+			}
+			entry.Dishes = append(entry.Dishes, dish{
+				ID:          id.UUID,
+				Name:        name.String,
+				Description: description.String,
+				Price:       models.NewMoney(price.Int64, currency),
+				IsAvailable: isAvailable.Bool,
+				Tags:        tags,
+				Allergens:   toAllergens(allergens),
+				Image:       image,
+				Rating:      dishRating,
+				CreatedAt:   createdAt.Time,
+			})
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, sql.ErrNoRows
+		}
+		return json.Marshal(entry)
+	})
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(data, &entry)
+	return entry, err
+}
+
+func loadTags(ctx context.Context) ([]models.Tag, error) {
+	var tags []models.Tag
+	data, err := cache.Catalog.Get(ctx, tagsCacheKey, func(ctx context.Context) ([]byte, error) {
+		tags, err := dbhelper.ListTags(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(tags)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &tags)
+	return tags, err
+}
+
+func toAllergens(values []string) []models.Allergen {
+	allergens := make([]models.Allergen, len(values))
+	for i, v := range values {
+		allergens[i] = models.Allergen(v)
+	}
+	return allergens
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// catalogCacheControl lets clients keep catalog responses but makes them
+// revalidate with the ETag before every use.
+const catalogCacheControl = "private, no-cache"
+
+// contentETag is a strong ETag over the exact bytes of a response.
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified sets the validators of the response and, when the client's copy
+// is still current, writes 304 and returns true. If-None-Match takes
+// precedence over If-Modified-Since.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", catalogCacheControl)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag, true) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || lastModified.IsZero() || lastModified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// ifMatches guards updates against lost writes: it reports whether the
+// request has no If-Match or one that matches etag. Updates check it in the
+// transaction that locks the resource, and answer 412 with preconditionFailed.
+func ifMatches(r *http.Request, etag string) bool {
+	im := r.Header.Get("If-Match")
+	return im == "" || etagMatches(im, etag, false)
+}
+
+func preconditionFailed(w http.ResponseWriter) {
+	http.Error(w, "resource was modified, fetch it again and retry", http.StatusPreconditionFailed)
+}
+
+// etagMatches reports whether etag is in the header's list, using the weak
+// comparison for If-None-Match and the strong one for If-Match (RFC 9110).
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if !weak && (strings.HasPrefix(candidate, "W/") || strings.HasPrefix(etag, "W/")) {
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCachedJSON writes v with a content ETag, or 304 when the client
+// already has it.
+func writeCachedJSON(w http.ResponseWriter, r *http.Request, v any, lastModified time.Time) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+
+	if notModified(w, r, contentETag(body), lastModified) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name         string
+		header, etag string
+		weak         bool
+		want         bool
+	}{
+		{"same", `"a"`, `"a"`, false, true},
+		{"different", `"b"`, `"a"`, false, false},
+		{"any", `*`, `"a"`, false, true},
+		{"in a list", `"b", "a"`, `"a"`, false, true},
+		{"list without spaces", `"b","a"`, `"a"`, false, true},
+		{"not in a list", `"b", "c"`, `"a"`, true, false},
+		{"any in a list", `"b", *`, `"a"`, false, true},
+		{"weak header, weak comparison", `W/"a"`, `"a"`, true, true},
+		{"weak header, strong comparison", `W/"a"`, `"a"`, false, false},
+		{"weak etag, weak comparison", `"a"`, `W/"a"`, true, true},
+		{"weak etag, strong comparison", `"a"`, `W/"a"`, false, false},
+		{"both weak, strong comparison", `W/"a"`, `W/"a"`, false, false},
+		{"weak in a list, strong comparison", `W/"a", "a"`, `"a"`, false, true},
+		{"unquoted", `a`, `"a"`, true, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, tt.etag, tt.weak); got != tt.want {
+			t.Errorf("%s: etagMatches(%s, %s, %v) = %v, want %v", tt.name, tt.header, tt.etag, tt.weak, got, tt.want)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	const etag = `"v1"`
+	modified := time.Date(2026, 10, 19, 8, 30, 15, 500, time.UTC)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	same := modified.Format(http.TimeFormat)
+	after := modified.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name                    string
+		ifNoneMatch, ifModSince string
+		lastModified            time.Time
+		want                    bool
+	}{
+		{"no validators", "", "", modified, false},
+		{"etag matches", etag, "", modified, true},
+		{"weak etag matches", `W/"v1"`, "", modified, true},
+		{"etag differs", `"v0"`, "", modified, false},
+		{"any etag", "*", "", modified, true},
+		{"not modified since", "", same, modified, true},
+		{"not modified since, later date", "", after, modified, true},
+		{"modified since", "", before, modified, false},
+		{"bad date", "", "yesterday", modified, false},
+		{"date without last modified", "", after, time.Time{}, false},
+		{"etag differs, date current", `"v0"`, after, modified, false},
+		{"etag matches, date stale", etag, before, modified, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", tt.ifNoneMatch)
+		}
+		if tt.ifModSince != "" {
+			r.Header.Set("If-Modified-Since", tt.ifModSince)
+		}
+		w := httptest.NewRecorder()
+		got := notModified(w, r, etag, tt.lastModified)
+		if got != tt.want {
+			t.Errorf("%s: notModified = %v, want %v", tt.name, got, tt.want)
+		}
+		if got && w.Code != http.StatusNotModified {
+			t.Errorf("%s: status = %d, want 304", tt.name, w.Code)
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Errorf("%s: ETag = %q, want %q", tt.name, w.Header().Get("ETag"), etag)
+		}
+	}
+}
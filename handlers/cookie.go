@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/ray-remotestate/restro/config"
+	"github.com/ray-remotestate/restro/middlewares"
+	"github.com/ray-remotestate/restro/utils"
+)
+
+// newCookie applies the configured cookie attributes. maxAge below zero
+// deletes the cookie.
+func newCookie(name, value string, httpOnly bool, maxAge time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   config.Cookie.Domain,
+		HttpOnly: httpOnly,
+		Secure:   config.Cookie.Secure,
+		SameSite: config.Cookie.SameSite,
+	}
+	if maxAge < 0 {
+		cookie.Expires = time.Unix(0, 0)
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = time.Now().Add(maxAge)
+		cookie.MaxAge = int(maxAge.Seconds())
+	}
+	return cookie
+}
+
+// setAuthCookies sets the refresh token cookie along with a fresh CSRF token,
+// which is returned so clients on another origin can read it from the body.
+func setAuthCookies(w http.ResponseWriter, refreshToken string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	csrfToken := base64.RawURLEncoding.EncodeToString(buf)
+
+	http.SetCookie(w, newCookie(middlewares.RefreshCookie, refreshToken, true, utils.RefreshTokenTTL))
+	// readable by scripts so it can be echoed in the X-CSRF-Token header
+	http.SetCookie(w, newCookie(middlewares.CSRFCookie, csrfToken, false, utils.RefreshTokenTTL))
+	return csrfToken, nil
+}
+
+func clearAuthCookies(w http.ResponseWriter) {
+	http.SetCookie(w, newCookie(middlewares.RefreshCookie, "", true, -1))
+	http.SetCookie(w, newCookie(middlewares.CSRFCookie, "", false, -1))
+}
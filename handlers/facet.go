@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ray-remotestate/restro/models"
+)
+
+type tagFacet struct {
+	Slug  string         `json:"slug"`
+	Name  string         `json:"name"`
+	Kind  models.TagKind `json:"kind"`
+	Count int            `json:"count"`
+}
+
+type allergenFacet struct {
+	Allergen models.Allergen `json:"allergen"`
+	Count    int             `json:"count"`
+}
+
+type restaurantFacets struct {
+	Total int        `json:"total"`
+	Tags  []tagFacet `json:"tags"`
+}
+
+type dishFacets struct {
+	Total     int             `json:"total"`
+	Tags      []tagFacet      `json:"tags"`
+	Allergens []allergenFacet `json:"allergens"`
+}
+
+// queryList reads a query parameter given repeatedly or comma separated.
+func queryList(query url.Values, name string) []string {
+	var values []string
+	for _, v := range query[name] {
+		values = append(values, strings.Split(v, ",")...)
+	}
+	return values
+}
+
+// tagFilter is the tags every listed item has to carry.
+func tagFilter(query url.Values) []string {
+	return normalizeSlugs(queryList(query, "tag"))
+}
+
+// allergenFilter is the allergens no listed dish may contain.
+func allergenFilter(query url.Values) ([]models.Allergen, error) {
+	values := queryList(query, "exclude_allergen")
+	allergens := make([]models.Allergen, len(values))
+	for i, v := range values {
+		allergens[i] = models.Allergen(v)
+	}
+	return parseAllergens(allergens)
+}
+
+func filterRestaurants(restaurants []restaurantListing, tags []string) []restaurantListing {
+	if len(tags) == 0 {
+		return restaurants
+	}
+	filtered := []restaurantListing{}
+	for _, r := range restaurants {
+		if hasAll(r.Tags, tags) {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+func filterDishes(dishes []dish, tags []string, excluded []models.Allergen) []dish {
+	if len(tags) == 0 && len(excluded) == 0 {
+		return dishes
+	}
+	filtered := []dish{}
+	for _, d := range dishes {
+		if hasAll(d.Tags, tags) && !slices.ContainsFunc(d.Allergens, func(a models.Allergen) bool {
+			return slices.Contains(excluded, a)
+		}) {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered
+}
+
+func hasAll(have, want []string) bool {
+	for _, tag := range want {
+		if !slices.Contains(have, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// countTags counts how many items carry each tag, so clients can show how
+// many results adding the tag to the filter would leave.
+func countTags(ctx context.Context, items [][]string) ([]tagFacet, error) {
+	counts := map[string]int{}
+	for _, tags := range items {
+		for _, tag := range tags {
+			counts[tag]++
+		}
+	}
+
+	taxonomy, err := loadTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	facets := []tagFacet{}
+	for _, t := range taxonomy {
+		if counts[t.Slug] > 0 {
+			facets = append(facets, tagFacet{Slug: t.Slug, Name: t.Name, Kind: t.Kind, Count: counts[t.Slug]})
+		}
+	}
+	slices.SortStableFunc(facets, func(a, b tagFacet) int {
+		if a.Kind != b.Kind {
+			return strings.Compare(string(a.Kind), string(b.Kind))
+		}
+		return b.Count - a.Count
+	})
+	return facets, nil
+}
+
+// RestaurantFacets counts the tags of the restaurants ListRestaurants returns
+// for the same filter.
+func RestaurantFacets(w http.ResponseWriter, r *http.Request) {
+	entry, err := loadRestaurants(r.Context())
+	if err != nil {
+		http.Error(w, "failed to query restaurants", http.StatusInternalServerError)
+		return
+	}
+
+	restaurants := filterRestaurants(entry.Restaurants, tagFilter(r.URL.Query()))
+	tags := make([][]string, len(restaurants))
+	for i, restaurant := range restaurants {
+		tags[i] = restaurant.Tags
+	}
+	facets, err := countTags(r.Context(), tags)
+	if err != nil {
+		http.Error(w, "failed to query tags", http.StatusInternalServerError)
+		return
+	}
+
+	writeCachedJSON(w, r, restaurantFacets{Total: len(restaurants), Tags: facets}, entry.LastModified)
+}
+
+// DishFacets counts the tags and allergens of the dishes
+// GetDishesByRestaurant returns for the same filter.
+func DishFacets(w http.ResponseWriter, r *http.Request) {
+	restaurantID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid restaurant ID", http.StatusBadRequest)
+		return
+	}
+	excluded, err := allergenFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	at := time.Now()
+	if v := r.URL.Query().Get("at"); v != "" {
+		if at, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "invalid at, expected RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+
+	menu, err := loadMenu(r.Context(), restaurantID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Restaurant not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch dishes", http.StatusInternalServerError)
+		return
+	}
+
+	served, _ := activeDishes(menu, at)
+	dishes := filterDishes(served, tagFilter(r.URL.Query()), excluded)
+	tags := make([][]string, len(dishes))
+	allergenCounts := map[models.Allergen]int{}
+	for i, d := range dishes {
+		tags[i] = d.Tags
+		for _, a := range d.Allergens {
+			allergenCounts[a]++
+		}
+	}
+	facets, err := countTags(r.Context(), tags)
+	if err != nil {
+		http.Error(w, "failed to query tags", http.StatusInternalServerError)
+		return
+	}
+	allergens := []allergenFacet{}
+	for _, a := range models.Allergens {
+		if allergenCounts[a] > 0 {
+			allergens = append(allergens, allergenFacet{Allergen: a, Count: allergenCounts[a]})
+		}
+	}
+
+	lastModified := menu.UpdatedAt
+	if len(menu.Schedules) > 0 {
+		lastModified = time.Time{} // what is served changes with the time of day
+	}
+	writeCachedJSON(w, r, dishFacets{Total: len(dishes), Tags: facets, Allergens: allergens}, lastModified)
+}
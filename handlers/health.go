@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ray-remotestate/restro/database"
+)
+
+const readinessCheckTimeout = 2 * time.Second
+
+// draining is set once shutdown begins so load balancers stop routing new
+// traffic here before the listener closes.
+var draining atomic.Bool
+
+func StartDraining() {
+	draining.Store(true)
+}
+
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+var readinessChecks = map[string]func(ctx context.Context) error{
+	"database":   checkDatabase,
+	"migrations": checkMigrations,
+	"draining":   checkNotDraining,
+}
+
+// Livez only reports that the process is able to serve HTTP.
+func Livez(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+	})
+}
+
+// Readyz runs every readiness check concurrently and fails if any of them does.
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]checkResult, len(readinessChecks))
+	ready := true
+
+	for name, check := range readinessChecks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) error) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(ctx)
+			result := checkResult{
+				Status:    "ok",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = "fail"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = result
+			if err != nil {
+				ready = false
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "fail", http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": status,
+		"checks": results,
+	})
+}
+
+func checkDatabase(ctx context.Context) error {
+	if database.Restro == nil {
+		return errors.New("not connected")
+	}
+	return database.Restro.PingContext(ctx)
+}
+
+func checkMigrations(ctx context.Context) error {
+	if database.Restro == nil {
+		return errors.New("not connected")
+	}
+	expected, err := database.ExpectedMigrationVersion()
+	if err != nil {
+		return err
+	}
+	current, dirty, err := database.MigrationVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("migration %d is dirty", current)
+	}
+	if current != expected {
+		return fmt.Errorf("schema at version %d, expected %d", current, expected)
+	}
+	return nil
+}
+
+func checkNotDraining(ctx context.Context) error {
+	if draining.Load() {
+		return errors.New("server is shutting down")
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ray-remotestate/restro/cache"
+	"github.com/ray-remotestate/restro/config"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/imaging"
+	"github.com/ray-remotestate/restro/middlewares"
+	"github.com/ray-remotestate/restro/models"
+	"github.com/ray-remotestate/restro/storage"
+)
+
+// imageFormField is the multipart field uploads carry the image in.
+const imageFormField = "image"
+
+// imageURLs maps the variants of an image to their URLs. The blobs of an
+// image never change, so the URLs can be cached for good.
+type imageURLs map[string]string
+
+func newImageURLs(id uuid.UUID) imageURLs {
+	urls := imageURLs{}
+	for _, v := range imaging.Variants {
+		urls[v.Name] = config.Storage.PublicURL + "/" + imageKey(id, v.Name)
+	}
+	return urls
+}
+
+func imageKey(id uuid.UUID, variant string) string {
+	return "images/" + id.String() + "/" + variant
+}
+
+func imageKeys(id uuid.UUID) []string {
+	keys := make([]string, len(imaging.Variants))
+	for i, v := range imaging.Variants {
+		keys[i] = imageKey(id, v.Name)
+	}
+	return keys
+}
+
+type imageResponse struct {
+	ID     uuid.UUID        `json:"id"`
+	Slot   models.ImageSlot `json:"slot"`
+	Width  int              `json:"width"`
+	Height int              `json:"height"`
+	URLs   imageURLs        `json:"urls"`
+}
+
+// UploadRestaurantImage sets the logo or banner of a restaurant from a
+// multipart upload.
+func UploadRestaurantImage(w http.ResponseWriter, r *http.Request) {
+	claims, restaurantID, slot, ok := parseRestaurantImage(w, r)
+	if !ok {
+		return
+	}
+	creator, err := dbhelper.RestaurantCreator(r.Context(), database.Restro, restaurantID)
+	if !imageAllowed(w, err, canManage(claims, creator), "restaurant not found") {
+		return
+	}
+
+	img := models.Image{RestaurantID: &restaurantID, Slot: slot, CreatedBy: claims.UserID}
+	uploadImage(w, r, img, "restaurant.image", "restaurant", restaurantID, restaurantsCacheKey)
+}
+
+// UploadMenuItemImage sets the photo of a menu item from a multipart upload.
+func UploadMenuItemImage(w http.ResponseWriter, r *http.Request) {
+	claims, menuID, ok := parseMenuItemImage(w, r)
+	if !ok {
+		return
+	}
+	restaurantID, err := authorizeMenuItem(r.Context(), database.Restro, claims, menuID)
+	if !imageAllowed(w, err, err != errNotCreator, "menu item not found") {
+		return
+	}
+
+	img := models.Image{MenuID: &menuID, Slot: models.ImageSlotPhoto, CreatedBy: claims.UserID}
+	uploadImage(w, r, img, "menu.image", "menu", menuID, menuCacheKey(restaurantID))
+}
+
+func DeleteRestaurantImage(w http.ResponseWriter, r *http.Request) {
+	claims, restaurantID, slot, ok := parseRestaurantImage(w, r)
+	if !ok {
+		return
+	}
+	creator, err := dbhelper.RestaurantCreator(r.Context(), database.Restro, restaurantID)
+	if !imageAllowed(w, err, canManage(claims, creator), "restaurant not found") {
+		return
+	}
+
+	deleteImage(w, r, &restaurantID, nil, slot, "restaurant.image.delete", "restaurant", restaurantID, restaurantsCacheKey)
+}
+
+func DeleteMenuItemImage(w http.ResponseWriter, r *http.Request) {
+	claims, menuID, ok := parseMenuItemImage(w, r)
+	if !ok {
+		return
+	}
+	restaurantID, err := authorizeMenuItem(r.Context(), database.Restro, claims, menuID)
+	if !imageAllowed(w, err, err != errNotCreator, "menu item not found") {
+		return
+	}
+
+	deleteImage(w, r, nil, &menuID, models.ImageSlotPhoto, "menu.image.delete", "menu", menuID, menuCacheKey(restaurantID))
+}
+
+// ServeImage serves a rendition from the blob store when images are not
+// served from a public bucket or CDN.
+func ServeImage(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	variant := mux.Vars(r)["variant"]
+	known := false
+	for _, v := range imaging.Variants {
+		known = known || v.Name == variant
+	}
+	if !known {
+		http.NotFound(w, r)
+		return
+	}
+
+	blob, err := storage.Images.Get(r.Context(), imageKey(id, variant))
+	if err == storage.ErrNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to read image", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	body := bufio.NewReaderSize(blob, 512)
+	head, _ := body.Peek(512)
+	w.Header().Set("Content-Type", http.DetectContentType(head))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	io.Copy(w, body)
+}
+
+func parseRestaurantImage(w http.ResponseWriter, r *http.Request) (*middlewares.Claims, uuid.UUID, models.ImageSlot, bool) {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, uuid.Nil, "", false
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid restaurant ID", http.StatusBadRequest)
+		return nil, uuid.Nil, "", false
+	}
+	slot := models.ImageSlot(mux.Vars(r)["slot"])
+	if slot != models.ImageSlotLogo && slot != models.ImageSlotBanner {
+		http.Error(w, "slot must be logo or banner", http.StatusBadRequest)
+		return nil, uuid.Nil, "", false
+	}
+	return claims, id, slot, true
+}
+
+func parseMenuItemImage(w http.ResponseWriter, r *http.Request) (*middlewares.Claims, uuid.UUID, bool) {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, uuid.Nil, false
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid menu item ID", http.StatusBadRequest)
+		return nil, uuid.Nil, false
+	}
+	return claims, id, true
+}
+
+// imageAllowed writes the error response for a failed lookup or a caller
+// that may not change the restaurant or menu item.
+func imageAllowed(w http.ResponseWriter, err error, allowed bool, notFound string) bool {
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, notFound, http.StatusNotFound)
+	case !allowed:
+		http.Error(w, "forbidden", http.StatusForbidden)
+	case err != nil:
+		http.Error(w, "failed to look up owner", http.StatusInternalServerError)
+	default:
+		return true
+	}
+	return false
+}
+
+// readUpload returns the image of a multipart/form-data request, writing
+// the error response if there is none or it is larger than allowed.
+func readUpload(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		http.Error(w, "expected multipart/form-data", http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+
+	limit := config.Storage.MaxUploadBytes
+	// leave room for the multipart framing around the image
+	r.Body = http.MaxBytesReader(w, r.Body, limit+64<<10)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "invalid multipart body", http.StatusBadRequest)
+		return nil, false
+	}
+	for {
+		part, err := reader.NextPart()
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			http.Error(w, "image is too large", http.StatusRequestEntityTooLarge)
+			return nil, false
+		} else if err != nil {
+			http.Error(w, "missing "+imageFormField+" field", http.StatusBadRequest)
+			return nil, false
+		}
+		if part.FormName() != imageFormField {
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, limit+1))
+		if errors.As(err, &maxBytes) || int64(len(data)) > limit {
+			http.Error(w, "image is too large", http.StatusRequestEntityTooLarge)
+			return nil, false
+		} else if err != nil {
+			http.Error(w, "invalid multipart body", http.StatusBadRequest)
+			return nil, false
+		}
+		return data, true
+	}
+}
+
+// uploadImage renders the uploaded image, stores the renditions and puts
+// img in its slot, replacing the image that was there.
+func uploadImage(w http.ResponseWriter, r *http.Request, img models.Image, action, resourceType string, resourceID uuid.UUID, cacheKey string) {
+	data, ok := readUpload(w, r)
+	if !ok {
+		return
+	}
+	result, err := imaging.Process(data)
+	if err == imaging.ErrUnsupported {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	} else if err == imaging.ErrTooLarge {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to process image", http.StatusInternalServerError)
+		return
+	}
+
+	img.ID = uuid.New()
+	img.Format = result.Format
+	img.Width, img.Height = result.Width, result.Height
+	for _, rendition := range result.Renditions {
+		if err := storage.Images.Put(r.Context(), imageKey(img.ID, rendition.Variant), bytes.NewReader(rendition.Data), result.ContentType); err != nil {
+			removeBlobs(r.Context(), img.ID)
+			http.Error(w, "failed to store image", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var old *models.Image
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if old, err = dbhelper.ReplaceImage(ctx, tx, img); err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, cacheKey); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, action, resourceType, resourceID.String(), old, img)
+	})
+	if err != nil {
+		removeBlobs(r.Context(), img.ID)
+		http.Error(w, "failed to save image", http.StatusInternalServerError)
+		return
+	}
+	cache.Catalog.Forget(r.Context(), cacheKey)
+	if old != nil {
+		removeBlobs(r.Context(), old.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(imageResponse{
+		ID:     img.ID,
+		Slot:   img.Slot,
+		Width:  img.Width,
+		Height: img.Height,
+		URLs:   newImageURLs(img.ID),
+	})
+}
+
+func deleteImage(w http.ResponseWriter, r *http.Request, restaurantID, menuID *uuid.UUID, slot models.ImageSlot, action, resourceType string, resourceID uuid.UUID, cacheKey string) {
+	var old *models.Image
+	err := database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if old, err = dbhelper.DeleteImage(ctx, tx, restaurantID, menuID, slot); err != nil {
+			return err
+		}
+		if old == nil {
+			return sql.ErrNoRows
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, cacheKey); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, action, resourceType, resourceID.String(), old, nil)
+	})
+	if err == sql.ErrNoRows {
+		http.Error(w, "no image in this slot", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to delete image", http.StatusInternalServerError)
+		return
+	}
+	cache.Catalog.Forget(r.Context(), cacheKey)
+	removeBlobs(r.Context(), old.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Image deleted",
+	})
+}
+
+// removeBlobs deletes the renditions of an image that is no longer
+// referenced. Failures only leave orphaned blobs behind, so they are logged.
+func removeBlobs(ctx context.Context, id uuid.UUID) {
+	if err := storage.Images.Delete(context.WithoutCancel(ctx), imageKeys(id)...); err != nil {
+		middlewares.Logger(ctx).WithError(err).WithField("image_id", id).Warn("failed to delete image blobs")
+	}
+}
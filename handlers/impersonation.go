@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/middlewares"
+	"github.com/ray-remotestate/restro/models"
+	"github.com/ray-remotestate/restro/utils"
+)
+
+func Impersonate(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Reason string `json:"reason"`
+	}
+
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	subjectID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if subjectID == claims.UserID {
+		http.Error(w, "cannot impersonate yourself", http.StatusBadRequest)
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := dbhelper.GetUserByID(r.Context(), subjectID); err == sql.ErrNoRows {
+		http.Error(w, "user does not exist", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	roles, err := fetchRoles(r.Context(), subjectID)
+	if err != nil {
+		http.Error(w, "could not fetch roles", http.StatusInternalServerError)
+		return
+	}
+	if slices.Contains(roles, string(models.RoleAdmin)) {
+		http.Error(w, "admins cannot be impersonated", http.StatusForbidden)
+		return
+	}
+
+	// bound to the admin's own session so logging out also ends the impersonation
+	token, expiresAt, err := utils.GenerateImpersonationToken(claims.UserID, subjectID, claims.SessionID, roles)
+	if err != nil {
+		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	err = dbhelper.LogImpersonation(r.Context(), models.ImpersonationAudit{
+		ActorID:   claims.UserID,
+		SubjectID: subjectID,
+		SessionID: claims.SessionID,
+		Method:    r.Method,
+		Path:      r.URL.RequestURI(),
+		Status:    http.StatusOK,
+		IPAddress: middlewares.ClientIP(r),
+		Reason:    req.Reason,
+	})
+	if err == nil {
+		err = recordAudit(r.Context(), database.Restro, r, uuid.Nil, "user.impersonate", "user", subjectID.String(), nil, map[string]interface{}{
+			"reason":     req.Reason,
+			"expires_at": expiresAt,
+		})
+	}
+	if err != nil {
+		http.Error(w, "failed to record impersonation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token":  token,
+		"expires_at":    expiresAt,
+		"impersonating": subjectID,
+		"actor_id":      claims.UserID,
+		"roles":         roles,
+	})
+}
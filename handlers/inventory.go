@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ray-remotestate/restro/cache"
+	"github.com/ray-remotestate/restro/config"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/middlewares"
+	"github.com/ray-remotestate/restro/models"
+	"github.com/sirupsen/logrus"
+)
+
+// GetInventory lists the stock and availability of a restaurant's menu.
+// Subadmins only see restaurants they created.
+func GetInventory(w http.ResponseWriter, r *http.Request) {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid restaurant ID", http.StatusBadRequest)
+		return
+	}
+
+	creator, err := dbhelper.RestaurantCreator(r.Context(), database.Restro, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "restaurant not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to query inventory", http.StatusInternalServerError)
+		return
+	}
+	if !canManage(claims, creator) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	items, err := dbhelper.ListInventory(r.Context(), id)
+	if err != nil {
+		http.Error(w, "failed to query inventory", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// SetStock sets the stock of a menu item and, optionally, the stock it gets
+// back every day. A null stock stops tracking it.
+func SetStock(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Stock      *int `json:"stock"`
+		DailyStock *int `json:"daily_stock"`
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if (req.Stock != nil && *req.Stock < 0) || (req.DailyStock != nil && *req.DailyStock < 0) {
+		http.Error(w, "stock cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if req.Stock == nil {
+		// a daily stock starts today
+		req.Stock = req.DailyStock
+	}
+
+	updateInventory(w, r, "menu.stock", func(ctx context.Context, tx *sql.Tx, id uuid.UUID) (models.Inventory, error) {
+		return dbhelper.SetStock(ctx, tx, id, req.Stock, req.DailyStock)
+	})
+}
+
+// SetAvailability switches a menu item on or off until switched back.
+func SetAvailability(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Available *bool `json:"available"`
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Available == nil {
+		http.Error(w, "invalid request, expected available", http.StatusBadRequest)
+		return
+	}
+
+	updateInventory(w, r, "menu.availability", func(ctx context.Context, tx *sql.Tx, id uuid.UUID) (models.Inventory, error) {
+		return dbhelper.SetAvailability(ctx, tx, id, *req.Available)
+	})
+}
+
+// EightySix takes a menu item off for the rest of the day.
+func EightySix(w http.ResponseWriter, r *http.Request) {
+	updateInventory(w, r, "menu.86", func(ctx context.Context, tx *sql.Tx, id uuid.UUID) (models.Inventory, error) {
+		return dbhelper.EightySix(ctx, tx, id, true)
+	})
+}
+
+// UndoEightySix puts an 86'd menu item back before the daily reset.
+func UndoEightySix(w http.ResponseWriter, r *http.Request) {
+	updateInventory(w, r, "menu.86", func(ctx context.Context, tx *sql.Tx, id uuid.UUID) (models.Inventory, error) {
+		return dbhelper.EightySix(ctx, tx, id, false)
+	})
+}
+
+// updateInventory applies update to the menu item in the {id} path
+// variable, if the caller may manage it, and responds with its inventory.
+func updateInventory(w http.ResponseWriter, r *http.Request, action string,
+	update func(ctx context.Context, tx *sql.Tx, id uuid.UUID) (models.Inventory, error)) {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid menu item ID", http.StatusBadRequest)
+		return
+	}
+
+	var inv models.Inventory
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := authorizeMenuItem(ctx, tx, claims, id); err != nil {
+			return err
+		}
+		before, err := dbhelper.GetInventoryForUpdate(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if inv, err = update(ctx, tx, id); err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, menuCacheKey(inv.RestaurantID)); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, action, "menu", id.String(), before, inv)
+	})
+	if err == sql.ErrNoRows {
+		http.Error(w, "menu item not found", http.StatusNotFound)
+		return
+	} else if err == errNotCreator {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to update inventory", http.StatusInternalServerError)
+		return
+	}
+	cache.Catalog.Forget(r.Context(), menuCacheKey(inv.RestaurantID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inv)
+}
+
+// ResetInventory restores daily stock and brings back 86'd items once the
+// day's reset time has passed. It is safe to run often and on every
+// instance.
+func ResetInventory(ctx context.Context) error {
+	since := lastInventoryReset(time.Now())
+	var restaurants []uuid.UUID
+	err := database.Tx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if restaurants, err = dbhelper.ResetDailyInventory(ctx, tx, since); err != nil {
+			return err
+		}
+		return dbhelper.NotifyCacheInvalidation(ctx, tx, menuCacheKeys(restaurants)...)
+	})
+	if err != nil {
+		return err
+	}
+	if len(restaurants) > 0 {
+		cache.Catalog.Forget(ctx, menuCacheKeys(restaurants)...)
+		logrus.WithField("restaurants", len(restaurants)).Info("reset daily inventory")
+	}
+	return nil
+}
+
+// lastInventoryReset is the latest daily reset time at or before now.
+func lastInventoryReset(now time.Time) time.Time {
+	now = now.In(config.Timezone)
+	year, month, day := now.Date()
+	reset := time.Date(year, month, day, 0, 0, 0, 0, now.Location()).Add(config.Inventory.ResetAt)
+	if reset.After(now) {
+		reset = time.Date(year, month, day-1, 0, 0, 0, 0, now.Location()).Add(config.Inventory.ResetAt)
+	}
+	return reset
+}
+
+func menuCacheKeys(restaurantIDs []uuid.UUID) []string {
+	keys := make([]string, len(restaurantIDs))
+	for i, id := range restaurantIDs {
+		keys[i] = menuCacheKey(id)
+	}
+	return keys
+}
@@ -0,0 +1,498 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ray-remotestate/restro/cache"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/models"
+)
+
+const (
+	maxImportBytes = 2 << 20
+	maxImportRows  = 2000
+	maxSKULength   = 64
+)
+
+// menuColumns are the CSV columns of a menu, in export order. sku, name and
+// price are required on import; currency, when given, must be the
+// restaurant's. Lists are separated by semicolons.
+var menuColumns = []string{"sku", "name", "description", "price", "currency", "is_available", "tags", "allergens"}
+
+// ImportMenu creates and updates a restaurant's menu items from a CSV or
+// JSON body, matching them by SKU. Items missing from the body are left
+// alone. The import is all or nothing: with any invalid row nothing is
+// applied and the report lists every problem by row and column. With
+// dry_run=true the report says what would change without changing it.
+func ImportMenu(w http.ResponseWriter, r *http.Request) {
+	claims, id, ok := parseRestaurantRequest(w, r)
+	if !ok {
+		return
+	}
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid dry_run, expected true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	creator, err := dbhelper.RestaurantCreator(r.Context(), database.Restro, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "restaurant not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to import menu", http.StatusInternalServerError)
+		return
+	}
+	if !canManage(claims, creator) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	currency, err := dbhelper.RestaurantCurrency(r.Context(), database.Restro, id)
+	if err != nil {
+		http.Error(w, "failed to import menu", http.StatusInternalServerError)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("import is larger than %d bytes", maxImportBytes), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to read import", http.StatusBadRequest)
+		return
+	}
+
+	var rows []models.MenuRow
+	var lines []int
+	var problems []models.ImportError
+	switch mediaType {
+	case "text/csv":
+		rows, lines, problems = parseMenuCSV(body, currency)
+	case "application/json":
+		if rows, lines, problems, err = parseMenuJSON(body, currency); err != nil {
+			http.Error(w, "invalid JSON, expected an array of menu items", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "expected text/csv or application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	if len(rows) > maxImportRows {
+		http.Error(w, fmt.Sprintf("import has more than %d rows", maxImportRows), http.StatusBadRequest)
+		return
+	}
+	problems = append(problems, validateMenuRows(rows, lines)...)
+	unknown, err := unknownMenuTags(r.Context(), rows, lines)
+	if err != nil {
+		http.Error(w, "failed to import menu", http.StatusInternalServerError)
+		return
+	}
+	problems = append(problems, unknown...)
+	slices.SortStableFunc(problems, func(a, b models.ImportError) int { return a.Row - b.Row })
+
+	report := models.ImportReport{DryRun: dryRun, Rows: len(rows), Errors: problems}
+	if len(problems) > 0 {
+		writeImportReport(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+
+	if dryRun {
+		err = importMenu(r.Context(), database.Restro, id, rows, &report, claims.UserID)
+	} else {
+		err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+			if err := importMenu(ctx, tx, id, rows, &report, claims.UserID); err != nil {
+				return err
+			}
+			if report.Created+report.Updated == 0 {
+				return nil
+			}
+			if err := dbhelper.NotifyCacheInvalidation(ctx, tx, menuCacheKey(id)); err != nil {
+				return err
+			}
+			return recordAudit(ctx, tx, r, uuid.Nil, "menu.import", "restaurant", id.String(), nil, report)
+		})
+	}
+	if err != nil {
+		http.Error(w, "failed to import menu", http.StatusInternalServerError)
+		return
+	}
+	if !dryRun && report.Created+report.Updated > 0 {
+		cache.Catalog.Forget(r.Context(), menuCacheKey(id))
+	}
+	writeImportReport(w, http.StatusOK, report)
+}
+
+// ExportMenu returns a restaurant's menu in the shape ImportMenu takes, as
+// JSON or, with format=csv, as CSV.
+func ExportMenu(w http.ResponseWriter, r *http.Request) {
+	claims, id, ok := parseRestaurantRequest(w, r)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, "invalid format, expected json or csv", http.StatusBadRequest)
+		return
+	}
+
+	creator, err := dbhelper.RestaurantCreator(r.Context(), database.Restro, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "restaurant not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to export menu", http.StatusInternalServerError)
+		return
+	}
+	if !canManage(claims, creator) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	items, err := dbhelper.ListMenuRows(r.Context(), database.Restro, id, false)
+	if err != nil {
+		http.Error(w, "failed to export menu", http.StatusInternalServerError)
+		return
+	}
+
+	if format != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(versionedPrices(r.Context(), items))
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="menu-`+id.String()+`.csv"`)
+	out := csv.NewWriter(w)
+	out.Write(menuColumns)
+	for _, item := range items {
+		allergens := make([]string, len(item.Allergens))
+		for i, a := range item.Allergens {
+			allergens[i] = string(a)
+		}
+		out.Write([]string{
+			item.SKU, item.Name, *item.Description, item.Price.Decimal(), string(item.Price.Currency),
+			strconv.FormatBool(*item.IsAvailable), strings.Join(item.Tags, ";"), strings.Join(allergens, ";"),
+		})
+	}
+	out.Flush()
+}
+
+// importMenu matches rows to the restaurant's items by SKU and counts what
+// changes in report, writing the changes unless it is a dry run.
+func importMenu(ctx context.Context, exec dbhelper.SQLExecutor, restaurantID uuid.UUID, rows []models.MenuRow,
+	report *models.ImportReport, userID uuid.UUID) error {
+	existing, err := dbhelper.ListMenuRows(ctx, exec, restaurantID, !report.DryRun)
+	if err != nil {
+		return err
+	}
+	bySKU := make(map[string]models.MenuRow, len(existing))
+	for _, item := range existing {
+		bySKU[item.SKU] = item
+	}
+
+	for _, row := range rows {
+		item, ok := bySKU[row.SKU]
+		switch {
+		case !ok:
+			report.Created++
+			if !report.DryRun {
+				if _, err := dbhelper.InsertMenuRow(ctx, exec, restaurantID, row, userID); err != nil {
+					return err
+				}
+			}
+		case menuRowChanged(item, row):
+			report.Updated++
+			if !report.DryRun {
+				if err := dbhelper.UpdateMenuRow(ctx, exec, item, row, userID); err != nil {
+					return err
+				}
+			}
+		default:
+			report.Unchanged++
+		}
+	}
+	return nil
+}
+
+func menuRowChanged(item, row models.MenuRow) bool {
+	return item.Name != row.Name || item.Price != row.Price ||
+		(row.Description != nil && *row.Description != *item.Description) ||
+		(row.IsAvailable != nil && *row.IsAvailable != *item.IsAvailable) ||
+		(row.Tags != nil && !slices.Equal(row.Tags, item.Tags)) ||
+		(row.Allergens != nil && !slices.Equal(row.Allergens, item.Allergens))
+}
+
+// parseMenuCSV reads a CSV import whose header names the columns. lines are
+// the line each row starts on.
+func parseMenuCSV(body []byte, currency models.Currency) (rows []models.MenuRow, lines []int, problems []models.ImportError) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(body, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, []models.ImportError{{Row: 1, Message: "missing header"}}
+	} else if err != nil {
+		return nil, nil, []models.ImportError{csvProblem(err)}
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case !slices.Contains(menuColumns, name):
+			problems = append(problems, models.ImportError{Row: 1, Column: name, Message: "unknown column"})
+		case columns[name] != 0:
+			problems = append(problems, models.ImportError{Row: 1, Column: name, Message: "duplicate column"})
+		}
+		columns[name] = i + 1
+	}
+	for _, name := range []string{"sku", "name", "price"} {
+		if columns[name] == 0 {
+			problems = append(problems, models.ImportError{Row: 1, Column: name, Message: "missing column"})
+		}
+	}
+	if len(problems) > 0 {
+		return nil, nil, problems
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return rows, lines, append(problems, csvProblem(err))
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) != len(header) {
+			problems = append(problems, models.ImportError{Row: line,
+				Message: fmt.Sprintf("expected %d columns, got %d", len(header), len(record))})
+			continue
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		value := func(name string) (string, bool) {
+			if columns[name] == 0 {
+				return "", false
+			}
+			return record[columns[name]-1], true
+		}
+
+		var row models.MenuRow
+		fail := func(column, message string) {
+			problems = append(problems, models.ImportError{Row: line, Column: column, Message: message})
+		}
+		row.SKU, _ = value("sku")
+		row.Name, _ = value("name")
+		row.SKU, row.Name = strings.TrimSpace(row.SKU), strings.TrimSpace(row.Name)
+		if v, ok := value("description"); ok {
+			v = strings.TrimSpace(v)
+			row.Description = &v
+		}
+		if v, ok := value("currency"); ok && strings.TrimSpace(v) != "" {
+			if c, err := models.ParseCurrency(v); err != nil {
+				fail("currency", err.Error())
+			} else if c != currency {
+				fail("currency", "the restaurant's menu is priced in "+string(currency))
+			}
+		}
+		if v, _ := value("price"); strings.TrimSpace(v) == "" {
+			fail("price", "required")
+		} else if price, err := models.ParseMoney(strings.TrimSpace(v), currency); err != nil {
+			fail("price", fmt.Sprintf("expected a non-negative amount with at most %d decimals", currency.Exponent()))
+		} else {
+			row.Price = price
+		}
+		if v, ok := value("is_available"); ok && strings.TrimSpace(v) != "" {
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err != nil {
+				fail("is_available", "expected true or false")
+			} else {
+				row.IsAvailable = &b
+			}
+		}
+		if v, ok := value("tags"); ok {
+			row.Tags = normalizeSlugs(splitList(v))
+		}
+		if v, ok := value("allergens"); ok {
+			allergens := make([]models.Allergen, 0)
+			for _, a := range splitList(v) {
+				allergens = append(allergens, models.Allergen(a))
+			}
+			if row.Allergens, err = parseAllergens(allergens); err != nil {
+				fail("allergens", err.Error())
+			}
+		}
+		rows = append(rows, row)
+		lines = append(lines, line)
+	}
+	return rows, lines, problems
+}
+
+func csvProblem(err error) models.ImportError {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return models.ImportError{Row: parseErr.StartLine, Message: parseErr.Err.Error()}
+	}
+	return models.ImportError{Message: err.Error()}
+}
+
+// splitList splits a semicolon-separated CSV value.
+func splitList(v string) []string {
+	if strings.TrimSpace(v) == "" {
+		return []string{}
+	}
+	return strings.Split(v, ";")
+}
+
+// parseMenuJSON reads a JSON import: an array of objects with the fields
+// of models.MenuRow. A price may also be given as a bare decimal in the
+// restaurant's currency. It fails only when the body is not an array of
+// objects.
+func parseMenuJSON(body []byte, currency models.Currency) (rows []models.MenuRow, lines []int, problems []models.ImportError, err error) {
+	var records []map[string]json.RawMessage
+	if err := json.Unmarshal(body, &records); err != nil {
+		return nil, nil, nil, err
+	}
+
+	for i, record := range records {
+		line := i + 1
+		fail := func(column, message string) {
+			problems = append(problems, models.ImportError{Row: line, Column: column, Message: message})
+		}
+		var row models.MenuRow
+		for _, name := range sortedKeys(record) {
+			raw := record[name]
+			var err error
+			switch name {
+			case "sku":
+				err = json.Unmarshal(raw, &row.SKU)
+				row.SKU = strings.TrimSpace(row.SKU)
+			case "name":
+				err = json.Unmarshal(raw, &row.Name)
+				row.Name = strings.TrimSpace(row.Name)
+			case "description":
+				err = json.Unmarshal(raw, &row.Description)
+			case "price":
+				row.Price, err = parseJSONPrice(raw, currency)
+			case "is_available":
+				err = json.Unmarshal(raw, &row.IsAvailable)
+			case "tags":
+				if err = json.Unmarshal(raw, &row.Tags); err == nil && row.Tags != nil {
+					row.Tags = normalizeSlugs(row.Tags)
+				}
+			case "allergens":
+				if err = json.Unmarshal(raw, &row.Allergens); err == nil && row.Allergens != nil {
+					row.Allergens, err = parseAllergens(row.Allergens)
+				}
+			default:
+				err = errors.New("unknown field")
+			}
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				fail(name, "expected "+jsonFieldTypes[name])
+			} else if err != nil {
+				fail(name, err.Error())
+			}
+		}
+		if _, ok := record["price"]; !ok {
+			fail("price", "required")
+		}
+		rows = append(rows, row)
+		lines = append(lines, line)
+	}
+	return rows, lines, problems, nil
+}
+
+var jsonFieldTypes = map[string]string{
+	"sku":          "a string",
+	"name":         "a string",
+	"description":  "a string",
+	"price":        "an amount",
+	"is_available": "true or false",
+	"tags":         "a list of strings",
+	"allergens":    "a list of strings",
+}
+
+func sortedKeys(record map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(record))
+	for k := range record {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// validateMenuRows checks what both formats require of a row.
+func validateMenuRows(rows []models.MenuRow, lines []int) []models.ImportError {
+	var problems []models.ImportError
+	seen := map[string]int{}
+	for i, row := range rows {
+		fail := func(column, message string) {
+			problems = append(problems, models.ImportError{Row: lines[i], Column: column, Message: message})
+		}
+		switch {
+		case row.SKU == "":
+			fail("sku", "required")
+		case len(row.SKU) > maxSKULength:
+			fail("sku", fmt.Sprintf("at most %d characters", maxSKULength))
+		case seen[row.SKU] != 0:
+			fail("sku", fmt.Sprintf("duplicate, first on row %d", seen[row.SKU]))
+		default:
+			seen[row.SKU] = lines[i]
+		}
+		if row.Name == "" || len(row.Name) > 100 {
+			fail("name", "required and at most 100 characters")
+		}
+	}
+	return problems
+}
+
+// unknownMenuTags reports the tags rows use that are not in the taxonomy.
+func unknownMenuTags(ctx context.Context, rows []models.MenuRow, lines []int) ([]models.ImportError, error) {
+	var slugs []string
+	for _, row := range rows {
+		slugs = append(slugs, row.Tags...)
+	}
+	if len(slugs) == 0 {
+		return nil, nil
+	}
+	slices.Sort(slugs)
+	unknown, err := dbhelper.UnknownTags(ctx, database.Restro, slices.Compact(slugs))
+	if err != nil || len(unknown) == 0 {
+		return nil, err
+	}
+
+	var problems []models.ImportError
+	for i, row := range rows {
+		for _, slug := range row.Tags {
+			if slices.Contains(unknown, slug) {
+				problems = append(problems, models.ImportError{Row: lines[i], Column: "tags", Message: "unknown tag " + slug})
+			}
+		}
+	}
+	return problems, nil
+}
+
+func writeImportReport(w http.ResponseWriter, status int, report models.ImportReport) {
+	if report.Errors == nil {
+		report.Errors = []models.ImportError{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ray-remotestate/restro/models"
+)
+
+// problemList renders import problems as "row column: message" for
+// comparison.
+func problemList(problems []models.ImportError) []string {
+	var out []string
+	for _, p := range problems {
+		out = append(out, fmt.Sprintf("%d %s: %s", p.Row, p.Column, p.Message))
+	}
+	return out
+}
+
+func TestParseMenuCSV(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		skus     []string
+		prices   []int64
+		lines    []int
+		problems []string
+	}{
+		{
+			name:   "plain",
+			body:   "sku,name,price\nA1,Eggs,4.50\nA2,Toast,2\n",
+			skus:   []string{"A1", "A2"},
+			prices: []int64{450, 200},
+			lines:  []int{2, 3},
+		},
+		{
+			name:   "byte order mark",
+			body:   "\ufeffsku,name,price\nA1,Eggs,4.50\n",
+			skus:   []string{"A1"},
+			prices: []int64{450},
+			lines:  []int{2},
+		},
+		{
+			name:   "header case, spacing and matching currency",
+			body:   " SKU ,Name,PRICE,currency\n A1 ,Eggs,4.50,usd\n",
+			skus:   []string{"A1"},
+			prices: []int64{450},
+			lines:  []int{2},
+		},
+		{
+			name:   "quoted line breaks",
+			body:   "sku,name,description,price\nA1,Eggs,\"two\nlines\",4.50\nA2,Toast,,2\n",
+			skus:   []string{"A1", "A2"},
+			prices: []int64{450, 200},
+			lines:  []int{2, 4},
+		},
+		{
+			name:     "empty",
+			body:     "",
+			problems: []string{"1 : missing header"},
+		},
+		{
+			name:     "missing columns",
+			body:     "sku,description\nA1,Eggs\n",
+			problems: []string{"1 name: missing column", "1 price: missing column"},
+		},
+		{
+			name:     "duplicate column",
+			body:     "sku,name,price,name\nA1,Eggs,4.50,Eggs\n",
+			problems: []string{"1 name: duplicate column"},
+		},
+		{
+			name:     "unknown column",
+			body:     "sku,name,price,colour\nA1,Eggs,4.50,red\n",
+			problems: []string{"1 colour: unknown column"},
+		},
+		{
+			name:     "bad price",
+			body:     "sku,name,price\nA1,Eggs,4.505\nA2,Toast,-1\nA3,Jam,\n",
+			skus:     []string{"A1", "A2", "A3"},
+			lines:    []int{2, 3, 4},
+			prices:   []int64{0, 0, 0},
+			problems: []string{"2 price: expected a non-negative amount with at most 2 decimals", "3 price: expected a non-negative amount with at most 2 decimals", "4 price: required"},
+		},
+		{
+			name:     "currency mismatch",
+			body:     "sku,name,price,currency\nA1,Eggs,4.50,EUR\nA2,Toast,2,XYZ\n",
+			skus:     []string{"A1", "A2"},
+			lines:    []int{2, 3},
+			prices:   []int64{450, 200},
+			problems: []string{"2 currency: the restaurant's menu is priced in USD", "3 currency: " + models.ErrInvalidCurrency.Error()},
+		},
+		{
+			name:     "wrong number of fields",
+			body:     "sku,name,price\nA1,Eggs\nA2,Toast,2\n",
+			skus:     []string{"A2"},
+			lines:    []int{3},
+			prices:   []int64{200},
+			problems: []string{"2 : expected 3 columns, got 2"},
+		},
+		{
+			name:     "bad lists",
+			body:     "sku,name,price,is_available,allergens\nA1,Eggs,4.50,maybe,eggs;glitter\n",
+			skus:     []string{"A1"},
+			lines:    []int{2},
+			prices:   []int64{450},
+			problems: []string{"2 is_available: expected true or false", "2 allergens: unknown allergen glitter"},
+		},
+	}
+	for _, tt := range tests {
+		rows, lines, problems := parseMenuCSV([]byte(tt.body), "USD")
+		var skus []string
+		var prices []int64
+		for _, row := range rows {
+			skus = append(skus, row.SKU)
+			prices = append(prices, row.Price.Amount)
+		}
+		if !slices.Equal(skus, tt.skus) || !slices.Equal(prices, tt.prices) || !slices.Equal(lines, tt.lines) {
+			t.Errorf("%s: rows %v priced %v on lines %v, want %v priced %v on lines %v", tt.name, skus, prices, lines, tt.skus, tt.prices, tt.lines)
+		}
+		if got := problemList(problems); !slices.Equal(got, tt.problems) {
+			t.Errorf("%s: problems = %q, want %q", tt.name, got, tt.problems)
+		}
+	}
+}
+
+func TestParseMenuJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		skus     []string
+		prices   []int64
+		problems []string
+		err      bool
+	}{
+		{
+			name:   "money and bare prices",
+			body:   `[{"sku":" A1 ","name":"Eggs","price":{"amount":"4.50","currency":"USD"}},{"sku":"A2","name":"Toast","price":2},{"sku":"A3","name":"Jam","price":"1.25"}]`,
+			skus:   []string{"A1", "A2", "A3"},
+			prices: []int64{450, 200, 125},
+		},
+		{
+			name:     "bad price",
+			body:     `[{"sku":"A1","name":"Eggs","price":"4.505"},{"sku":"A2","name":"Toast"}]`,
+			skus:     []string{"A1", "A2"},
+			prices:   []int64{0, 0},
+			problems: []string{"1 price: expected a non-negative amount with at most 2 decimals", "2 price: required"},
+		},
+		{
+			name:     "currency mismatch",
+			body:     `[{"sku":"A1","name":"Eggs","price":{"amount":"4.50","currency":"EUR"}}]`,
+			skus:     []string{"A1"},
+			prices:   []int64{450},
+			problems: []string{"1 price: the restaurant's menu is priced in USD"},
+		},
+		{
+			name:     "wrong types and unknown fields",
+			body:     `[{"sku":"A1","name":5,"price":1,"is_available":"yes","colour":"red"}]`,
+			skus:     []string{"A1"},
+			prices:   []int64{100},
+			problems: []string{"1 colour: unknown field", "1 is_available: expected true or false", "1 name: expected a string"},
+		},
+		{name: "not an array", body: `{"sku":"A1"}`, err: true},
+		{name: "not JSON", body: `sku,name,price`, err: true},
+	}
+	for _, tt := range tests {
+		rows, _, problems, err := parseMenuJSON([]byte(tt.body), "USD")
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		var skus []string
+		var prices []int64
+		for _, row := range rows {
+			skus = append(skus, row.SKU)
+			prices = append(prices, row.Price.Amount)
+		}
+		if !slices.Equal(skus, tt.skus) || !slices.Equal(prices, tt.prices) {
+			t.Errorf("%s: rows %v priced %v, want %v priced %v", tt.name, skus, prices, tt.skus, tt.prices)
+		}
+		if got := problemList(problems); !slices.Equal(got, tt.problems) {
+			t.Errorf("%s: problems = %q, want %q", tt.name, got, tt.problems)
+		}
+	}
+}
+
+func TestParseJSONPrice(t *testing.T) {
+	tests := []struct {
+		raw      string
+		currency models.Currency
+		want     int64
+		wantErr  bool
+	}{
+		{`4.5`, "USD", 450, false},
+		{`"4.50"`, "USD", 450, false},
+		{` {"amount":"4.50","currency":"USD"} `, "USD", 450, false},
+		{`{"amount_minor":450,"currency":"USD"}`, "USD", 450, false},
+		{`1200`, "JPY", 1200, false},
+		{`12.5`, "JPY", 0, true},
+		{`4.505`, "USD", 0, true},
+		{`-1`, "USD", 0, true},
+		{`null`, "USD", 0, true},
+		{`{"amount":"4.50","currency":"EUR"}`, "USD", 0, true},
+		{`{"amount":"4.50"}`, "USD", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseJSONPrice([]byte(tt.raw), tt.currency)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseJSONPrice(%s, %s) error = %v, want error %v", tt.raw, tt.currency, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (got.Amount != tt.want || got.Currency != tt.currency) {
+			t.Errorf("parseJSONPrice(%s, %s) = %v, want %d", tt.raw, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestValidateMenuRows(t *testing.T) {
+	rows := []models.MenuRow{
+		{SKU: "A1", Name: "Eggs"},
+		{SKU: "A2", Name: "Toast"},
+		{SKU: "A1", Name: "Eggs again"},
+		{SKU: "", Name: "Jam"},
+		{SKU: strings.Repeat("x", maxSKULength+1), Name: "Long"},
+		{SKU: "A3", Name: ""},
+	}
+	want := []string{
+		"4 sku: duplicate, first on row 2",
+		"5 sku: required",
+		"6 sku: at most 64 characters",
+		"7 name: required and at most 100 characters",
+	}
+	if got := problemList(validateMenuRows(rows, []int{2, 3, 4, 5, 6, 7})); !slices.Equal(got, want) {
+		t.Errorf("problems = %q, want %q", got, want)
+	}
+}
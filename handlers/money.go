@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ray-remotestate/restro/middlewares"
+	"github.com/ray-remotestate/restro/models"
+)
+
+// parseJSONPrice reads a models.Money object in the restaurant's currency or
+// a bare decimal, as a string or a number, which version 1 of the API sent
+// before amounts carried their currency.
+func parseJSONPrice(raw json.RawMessage, currency models.Currency) (models.Money, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] != '{' {
+		price, err := models.ParseMoney(strings.Trim(string(raw), `"`), currency)
+		if err != nil {
+			return price, fmt.Errorf("expected a non-negative amount with at most %d decimals", currency.Exponent())
+		}
+		return price, nil
+	}
+	var price models.Money
+	if err := json.Unmarshal(raw, &price); err != nil {
+		return price, err
+	}
+	if price.Currency != currency {
+		return price, errors.New("the restaurant's menu is priced in " + string(currency))
+	}
+	return price, nil
+}
+
+// versionedPrices picks how the amounts in a response are sent: version 1
+// sends them as decimal numbers in the restaurant's currency, as it did
+// before amounts carried their currency, and later versions as models.Money
+// objects.
+func versionedPrices(ctx context.Context, v any) any {
+	return middlewares.Versioned(ctx, map[int]func() any{
+		1: func() any { return decimalPrices(v) },
+		2: func() any { return v },
+	})
+}
+
+// decimalPrices returns v as generic JSON with every models.Money object
+// replaced by its decimal amount.
+func decimalPrices(v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v // the encoder reports the error
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out any
+	if err := dec.Decode(&out); err != nil {
+		return v
+	}
+	return replaceMoney(out)
+}
+
+func replaceMoney(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		if amount, ok := v["amount"].(string); ok && len(v) == 3 && v["amount_minor"] != nil && v["currency"] != nil {
+			return json.Number(amount)
+		}
+		for k, e := range v {
+			v[k] = replaceMoney(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = replaceMoney(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ray-remotestate/restro/models"
+)
+
+func TestDecimalPrices(t *testing.T) {
+	description := "fried"
+	rows := []models.MenuRow{{SKU: "A1", Name: "Eggs", Description: &description, Price: models.NewMoney(450, "USD")}}
+
+	out, err := json.Marshal(decimalPrices(rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `"price":4.50`) {
+		t.Errorf("decimalPrices = %s, want the price as 4.50", out)
+	}
+	var decoded []map[string]any
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || decoded[0]["sku"] != "A1" {
+		t.Errorf("decimalPrices = %s, want the other fields kept", out)
+	}
+
+	// what /v1 exports, the import takes back
+	raw, _ := json.Marshal(decoded[0]["price"])
+	if price, err := parseJSONPrice(raw, "USD"); err != nil || price != models.NewMoney(450, "USD") {
+		t.Errorf("parseJSONPrice(%s) = %v, %v", raw, price, err)
+	}
+}
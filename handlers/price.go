@@ -0,0 +1,540 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ray-remotestate/restro/cache"
+	"github.com/ray-remotestate/restro/config"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/middlewares"
+	"github.com/ray-remotestate/restro/models"
+	"github.com/sirupsen/logrus"
+)
+
+var errPreconditionFailed = errors.New("precondition failed")
+
+type effectivePrice struct {
+	MenuItemID uuid.UUID         `json:"menu_item_id"`
+	At         time.Time         `json:"at"`
+	BasePrice  models.Money      `json:"base_price"`
+	Price      models.Money      `json:"price"`
+	Rule       *models.PriceRule `json:"rule"` // the discount applied, if any
+}
+
+// GetEffectivePrice returns what a menu item costs at the time in the at
+// query parameter, now by default, with scheduled changes and happy hours
+// applied.
+func GetEffectivePrice(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid menu item ID", http.StatusBadRequest)
+		return
+	}
+	at := time.Now()
+	if v := r.URL.Query().Get("at"); v != "" {
+		if at, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "invalid at, expected RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+
+	restaurantID, _, err := dbhelper.MenuItemCreator(r.Context(), database.Restro, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "menu item not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to query price", http.StatusInternalServerError)
+		return
+	}
+	base, err := dbhelper.PriceAt(r.Context(), database.Restro, id, at)
+	if err == sql.ErrNoRows {
+		http.Error(w, "menu item has no price at this time", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to query price", http.StatusInternalServerError)
+		return
+	}
+	rules, err := dbhelper.PriceRulesAt(r.Context(), database.Restro, restaurantID, id, at)
+	if err != nil {
+		http.Error(w, "failed to query price", http.StatusInternalServerError)
+		return
+	}
+
+	price, rule, err := applyPriceRules(base.Price, rules, at)
+	if err != nil {
+		http.Error(w, "failed to apply price rules", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versionedPrices(r.Context(), effectivePrice{
+		MenuItemID: id,
+		At:         at,
+		BasePrice:  base.Price,
+		Price:      price,
+		Rule:       rule,
+	}))
+}
+
+// ListPriceHistory lists the price changes of a menu item, latest first. The
+// ETag names the current price, for If-Match on UpdatePrice.
+func ListPriceHistory(w http.ResponseWriter, r *http.Request) {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid menu item ID", http.StatusBadRequest)
+		return
+	}
+
+	var current models.PriceChange
+	if _, err = authorizeMenuItem(r.Context(), database.Restro, claims, id); err == nil {
+		current, err = dbhelper.CurrentPrice(r.Context(), database.Restro, id, false)
+	}
+	if !priceDone(w, err, "failed to query prices") {
+		return
+	}
+	history, err := dbhelper.ListPriceHistory(r.Context(), id)
+	if err != nil {
+		http.Error(w, "failed to query prices", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", priceETag(current))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versionedPrices(r.Context(), history))
+}
+
+// UpdatePrice reprices a menu item now or, with effective_at in the future,
+// schedules the change. With If-Match it only applies when the current
+// price is still the one the client saw.
+func UpdatePrice(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Price       json.RawMessage `json:"price"`
+		EffectiveAt *time.Time      `json:"effective_at"`
+	}
+
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid menu item ID", http.StatusBadRequest)
+		return
+	}
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Price) == 0 {
+		http.Error(w, "invalid request, expected price", http.StatusBadRequest)
+		return
+	}
+	// a restaurant's currency never changes, so it can be read up front
+	currency, err := dbhelper.MenuItemCurrency(r.Context(), database.Restro, id)
+	if !priceDone(w, err, "failed to update price") {
+		return
+	}
+	price, err := parseJSONPrice(req.Price, currency)
+	if err != nil {
+		http.Error(w, "invalid price: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.EffectiveAt != nil && !req.EffectiveAt.After(time.Now()) {
+		req.EffectiveAt = nil
+	}
+
+	var change models.PriceChange
+	var restaurantID uuid.UUID
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if restaurantID, err = authorizeMenuItem(ctx, tx, claims, id); err != nil {
+			return err
+		}
+		current, err := dbhelper.CurrentPrice(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+		if !ifMatches(r, priceETag(current)) {
+			return errPreconditionFailed
+		}
+		if change, err = dbhelper.ChangePrice(ctx, tx, id, price, req.EffectiveAt, claims.UserID); err != nil {
+			return err
+		}
+		action := "menu.price"
+		if req.EffectiveAt != nil {
+			action = "menu.price.schedule"
+		} else if err := dbhelper.NotifyCacheInvalidation(ctx, tx, menuCacheKey(restaurantID)); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, action, "menu", id.String(), current, change)
+	})
+	if !priceDone(w, err, "failed to update price") {
+		return
+	}
+	if change.AppliedAt != nil {
+		cache.Catalog.Forget(r.Context(), menuCacheKey(restaurantID))
+		w.Header().Set("ETag", priceETag(change))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versionedPrices(r.Context(), change))
+}
+
+// CancelPriceChange cancels a scheduled price change before it applies.
+func CancelPriceChange(w http.ResponseWriter, r *http.Request) {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid menu item ID", http.StatusBadRequest)
+		return
+	}
+	changeID, err := uuid.Parse(mux.Vars(r)["change_id"])
+	if err != nil {
+		http.Error(w, "invalid price change ID", http.StatusBadRequest)
+		return
+	}
+
+	var change models.PriceChange
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := authorizeMenuItem(ctx, tx, claims, id); err != nil {
+			return err
+		}
+		var err error
+		if change, err = dbhelper.CancelPriceChange(ctx, tx, id, changeID); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "menu.price.cancel", "menu", id.String(), nil, change)
+	})
+	if err == sql.ErrNoRows {
+		http.Error(w, "no scheduled price change with this ID", http.StatusNotFound)
+		return
+	}
+	if !priceDone(w, err, "failed to cancel price change") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versionedPrices(r.Context(), change))
+}
+
+// ListPriceRules lists the active price rules of a restaurant.
+func ListPriceRules(w http.ResponseWriter, r *http.Request) {
+	claims, id, ok := parseRestaurantRequest(w, r)
+	if !ok {
+		return
+	}
+
+	creator, err := dbhelper.RestaurantCreator(r.Context(), database.Restro, id)
+	if err == nil && !canManage(claims, creator) {
+		err = errNotCreator
+	}
+	if !priceDone(w, err, "failed to query price rules") {
+		return
+	}
+	rules, err := dbhelper.ListPriceRules(r.Context(), database.Restro, id, nil)
+	if err != nil {
+		http.Error(w, "failed to query price rules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versionedPrices(r.Context(), rules))
+}
+
+// CreatePriceRule adds a discount window, such as a happy hour, to a
+// restaurant's menu or to one item of it.
+func CreatePriceRule(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		models.PriceRule
+		AmountOff json.RawMessage `json:"amount_off"`
+	}
+
+	claims, id, ok := parseRestaurantRequest(w, r)
+	if !ok {
+		return
+	}
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	rule := req.PriceRule
+	if len(req.AmountOff) > 0 && string(req.AmountOff) != "null" {
+		currency, err := dbhelper.RestaurantCurrency(r.Context(), database.Restro, id)
+		if !priceDone(w, err, "failed to create price rule") {
+			return
+		}
+		amountOff, err := parseJSONPrice(req.AmountOff, currency)
+		if err != nil {
+			http.Error(w, "invalid amount_off: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		rule.AmountOff = &amountOff
+	}
+	rule.RestaurantID = id
+	rule.CreatedBy = &claims.UserID
+	if msg := validatePriceRule(&rule); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err := database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		creator, err := dbhelper.RestaurantCreator(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !canManage(claims, creator) {
+			return errNotCreator
+		}
+		if rule.MenuItemID != nil {
+			restaurantID, _, err := dbhelper.MenuItemCreator(ctx, tx, *rule.MenuItemID)
+			if err == sql.ErrNoRows || (err == nil && restaurantID != id) {
+				return errNotOnMenu
+			} else if err != nil {
+				return err
+			}
+		}
+		if rule, err = dbhelper.CreatePriceRule(ctx, tx, rule); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "price_rule.create", "price_rule", rule.ID.String(), nil, rule)
+	})
+	if !priceDone(w, err, "failed to create price rule") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versionedPrices(r.Context(), rule))
+}
+
+// ArchivePriceRule ends a price rule.
+func ArchivePriceRule(w http.ResponseWriter, r *http.Request) {
+	claims, id, ok := parseRestaurantRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		before, err := dbhelper.GetPriceRule(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		creator, err := dbhelper.RestaurantCreator(ctx, tx, before.RestaurantID)
+		if err != nil {
+			return err
+		}
+		if !canManage(claims, creator) {
+			return errNotCreator
+		}
+		if err := dbhelper.ArchivePriceRule(ctx, tx, id); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "price_rule.archive", "price_rule", id.String(), before, nil)
+	})
+	if !priceDone(w, err, "failed to archive price rule") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Price rule archived",
+	})
+}
+
+// ApplyScheduledPrices puts scheduled price changes on the menu once their
+// time has come. It is safe to run often and on every instance.
+func ApplyScheduledPrices(ctx context.Context) error {
+	var restaurants []uuid.UUID
+	err := database.Tx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if restaurants, err = dbhelper.ApplyDuePrices(ctx, tx); err != nil {
+			return err
+		}
+		return dbhelper.NotifyCacheInvalidation(ctx, tx, menuCacheKeys(restaurants)...)
+	})
+	if err != nil {
+		return err
+	}
+	if len(restaurants) > 0 {
+		cache.Catalog.Forget(ctx, menuCacheKeys(restaurants)...)
+		logrus.WithField("restaurants", len(restaurants)).Info("applied scheduled prices")
+	}
+	return nil
+}
+
+var errNotOnMenu = errors.New("menu item is not on this restaurant's menu")
+
+func priceETag(current models.PriceChange) string {
+	return `"` + current.ID.String() + `"`
+}
+
+// priceDone writes the error response for err, if any.
+func priceDone(w http.ResponseWriter, err error, failed string) bool {
+	switch {
+	case err == nil:
+		return true
+	case err == sql.ErrNoRows:
+		http.Error(w, "not found", http.StatusNotFound)
+	case err == errNotCreator:
+		http.Error(w, "forbidden", http.StatusForbidden)
+	case err == errNotOnMenu:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err == errPreconditionFailed:
+		preconditionFailed(w)
+	case errors.As(err, new(*models.CurrencyMismatchError)):
+		http.Error(w, "amount must be in the restaurant's currency: "+err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, failed, http.StatusInternalServerError)
+	}
+	return false
+}
+
+func parseRestaurantRequest(w http.ResponseWriter, r *http.Request) (*middlewares.Claims, uuid.UUID, bool) {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, uuid.Nil, false
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid ID", http.StatusBadRequest)
+		return nil, uuid.Nil, false
+	}
+	return claims, id, true
+}
+
+// validatePriceRule checks a rule and puts its days in order, returning
+// what is wrong with it, if anything.
+func validatePriceRule(rule *models.PriceRule) string {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" || len(rule.Name) > 100 {
+		return "name is required and at most 100 characters"
+	}
+	if (rule.PercentOff == nil) == (rule.AmountOff == nil) {
+		return "set exactly one of percent_off and amount_off"
+	}
+	if rule.PercentOff != nil && (*rule.PercentOff < 1 || *rule.PercentOff > 100) {
+		return "percent_off must be 1 to 100"
+	}
+	if rule.AmountOff != nil && rule.AmountOff.Amount <= 0 {
+		return "amount_off must be positive"
+	}
+	if msg := validateWindow(&rule.Days, rule.StartsAt, rule.EndsAt); msg != "" {
+		return msg
+	}
+	if rule.ValidFrom != nil && rule.ValidUntil != nil && !rule.ValidUntil.After(*rule.ValidFrom) {
+		return "valid_until must be after valid_from"
+	}
+	return ""
+}
+
+// validateWindow checks a weekly time window, defaulting its days to all
+// week and putting them in order.
+func validateWindow(days *[]int, startsAt, endsAt string) string {
+	if len(*days) == 0 {
+		*days = []int{1, 2, 3, 4, 5, 6, 7}
+	}
+	for _, d := range *days {
+		if d < 1 || d > 7 {
+			return "days are ISO weekdays, 1 (Monday) to 7"
+		}
+	}
+	slices.Sort(*days)
+	*days = slices.Compact(*days)
+	start, err1 := time.Parse("15:04", startsAt)
+	end, err2 := time.Parse("15:04", endsAt)
+	if err1 != nil || err2 != nil || start.Equal(end) {
+		return "starts_at and ends_at must be different HH:MM times"
+	}
+	return ""
+}
+
+// applyPriceRules returns the lowest price the rules active at at give,
+// and the rule giving it.
+func applyPriceRules(base models.Money, rules []models.PriceRule, at time.Time) (models.Money, *models.PriceRule, error) {
+	price := base
+	var applied *models.PriceRule
+	for i, rule := range rules {
+		if !ruleActive(rule, at) {
+			continue
+		}
+		var discount models.Money
+		var err error
+		if rule.PercentOff != nil {
+			// the discount is rounded down to whole minor units
+			discount, err = base.MulDiv(int64(*rule.PercentOff), 100, models.RoundDown)
+		} else {
+			discount = *rule.AmountOff
+		}
+		if err != nil {
+			return base, nil, err
+		}
+		discounted, err := base.Sub(discount)
+		if err != nil {
+			return base, nil, err
+		}
+		if discounted.IsNegative() {
+			discounted.Amount = 0
+		}
+		if discounted.Amount < price.Amount {
+			price, applied = discounted, &rules[i]
+		}
+	}
+	return price, applied, nil
+}
+
+// ruleActive reports whether at falls in a rule's validity and window.
+func ruleActive(rule models.PriceRule, at time.Time) bool {
+	if (rule.ValidFrom != nil && at.Before(*rule.ValidFrom)) || (rule.ValidUntil != nil && !at.Before(*rule.ValidUntil)) {
+		return false
+	}
+	return inWindow(rule.Days, rule.StartsAt, rule.EndsAt, at)
+}
+
+// inWindow reports whether at, in local time, falls in a weekly window. A
+// window running past midnight belongs to the day it starts on.
+func inWindow(days []int, startsAt, endsAt string, at time.Time) bool {
+	start, err1 := time.Parse("15:04", startsAt)
+	end, err2 := time.Parse("15:04", endsAt)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+
+	local := at.In(config.Timezone)
+	now := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute + time.Duration(local.Second())*time.Second
+	from := time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute
+	to := time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute
+	today := isoWeekday(local.Weekday())
+	yesterday := today - 1
+	if yesterday == 0 {
+		yesterday = 7
+	}
+
+	if from < to {
+		return slices.Contains(days, today) && now >= from && now < to
+	}
+	return (slices.Contains(days, today) && now >= from) || (slices.Contains(days, yesterday) && now < to)
+}
+
+func isoWeekday(d time.Weekday) int {
+	if d == time.Sunday {
+		return 7
+	}
+	return int(d)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ray-remotestate/restro/config"
+	"github.com/ray-remotestate/restro/models"
+)
+
+func TestApplyPriceRules(t *testing.T) {
+	config.Timezone = time.UTC
+	everyDay := []int{1, 2, 3, 4, 5, 6, 7}
+	// 2026-10-19 is a Monday
+	at := time.Date(2026, 10, 19, 18, 0, 0, 0, time.UTC)
+	usd := func(minor int64) models.Money { return models.NewMoney(minor, "USD") }
+	percent := func(name string, off int) models.PriceRule {
+		return models.PriceRule{Name: name, PercentOff: &off, Days: everyDay, StartsAt: "17:00", EndsAt: "19:00"}
+	}
+	amount := func(name string, off models.Money) models.PriceRule {
+		return models.PriceRule{Name: name, AmountOff: &off, Days: everyDay, StartsAt: "17:00", EndsAt: "19:00"}
+	}
+	closed := percent("closed", 50)
+	closed.StartsAt, closed.EndsAt = "07:00", "11:00"
+
+	tests := []struct {
+		name     string
+		base     models.Money
+		rules    []models.PriceRule
+		want     int64
+		wantRule string
+	}{
+		{"no rules", usd(1000), nil, 1000, ""},
+		{"percent", usd(1000), []models.PriceRule{percent("happy hour", 20)}, 800, "happy hour"},
+		// 15% of 9.99 is 1.4985, and the discount is rounded down to 1.49
+		{"percent rounds the discount down", usd(999), []models.PriceRule{percent("happy hour", 15)}, 850, "happy hour"},
+		{"percent of one cent", usd(1), []models.PriceRule{percent("happy hour", 99)}, 1, ""},
+		{"amount", usd(1000), []models.PriceRule{amount("two off", usd(200))}, 800, "two off"},
+		{"amount over the price", usd(500), []models.PriceRule{amount("ten off", usd(1000))}, 0, "ten off"},
+		{"hundred percent", usd(500), []models.PriceRule{percent("free", 100)}, 0, "free"},
+		{"best of percent and amount", usd(1000), []models.PriceRule{percent("ten percent", 10), amount("two off", usd(200))}, 800, "two off"},
+		{"best of amount and percent", usd(1000), []models.PriceRule{amount("two off", usd(200)), percent("half", 50)}, 500, "half"},
+		{"first of equal rules", usd(1000), []models.PriceRule{percent("first", 20), amount("second", usd(200))}, 800, "first"},
+		{"inactive rule is skipped", usd(1000), []models.PriceRule{closed, percent("happy hour", 10)}, 900, "happy hour"},
+		{"only inactive rules", usd(1000), []models.PriceRule{closed}, 1000, ""},
+	}
+	for _, tt := range tests {
+		got, rule, err := applyPriceRules(tt.base, tt.rules, at)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != usd(tt.want) {
+			t.Errorf("%s: price = %v, want %d", tt.name, got, tt.want)
+		}
+		switch {
+		case tt.wantRule == "" && rule != nil:
+			t.Errorf("%s: applied %q, want no rule", tt.name, rule.Name)
+		case tt.wantRule != "" && (rule == nil || rule.Name != tt.wantRule):
+			t.Errorf("%s: applied %v, want %q", tt.name, rule, tt.wantRule)
+		}
+	}
+
+	if _, _, err := applyPriceRules(usd(1000), []models.PriceRule{amount("euro off", models.NewMoney(100, "EUR"))}, at); err == nil {
+		t.Error("an amount off in another currency was applied")
+	}
+}
+
+func TestRuleActive(t *testing.T) {
+	config.Timezone = time.UTC
+	// 2026-10-19 is a Monday
+	monday := func(clock string) time.Time {
+		at, err := time.Parse(time.RFC3339, "2026-10-19T"+clock+":00Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+	from, until := monday("12:00"), monday("12:00").AddDate(0, 0, 7)
+	rule := func(days []int, startsAt, endsAt string, validFrom, validUntil *time.Time) models.PriceRule {
+		return models.PriceRule{Days: days, StartsAt: startsAt, EndsAt: endsAt, ValidFrom: validFrom, ValidUntil: validUntil}
+	}
+	everyDay := []int{1, 2, 3, 4, 5, 6, 7}
+	valid := rule(everyDay, "00:00", "23:59", &from, &until)
+	lateNight := rule([]int{1}, "22:00", "02:00", nil, nil)
+
+	tests := []struct {
+		name string
+		rule models.PriceRule
+		at   time.Time
+		want bool
+	}{
+		{"in window", rule([]int{1}, "17:00", "19:00", nil, nil), monday("18:00"), true},
+		{"outside window", rule([]int{1}, "17:00", "19:00", nil, nil), monday("19:00"), false},
+		{"past midnight, before it", lateNight, monday("23:30"), true},
+		{"past midnight, after it", lateNight, monday("01:30").AddDate(0, 0, 1), true},
+		{"past midnight, on the wrong day", lateNight, monday("01:30"), false},
+		{"past midnight, once over", lateNight, monday("02:00").AddDate(0, 0, 1), false},
+		{"at valid_from", valid, from, true},
+		{"before valid_from", valid, from.Add(-time.Second), false},
+		{"before valid_until", valid, until.Add(-time.Second), true},
+		{"at valid_until", valid, until, false},
+		{"valid, outside window", valid, until.Add(-12*time.Hour - 30*time.Second), false},
+		{"no valid_from", rule(everyDay, "00:00", "23:59", nil, &until), from.AddDate(-1, 0, 0), true},
+		{"no valid_until", rule(everyDay, "00:00", "23:59", &from, nil), until.AddDate(1, 0, 0), true},
+		{"validity ends inside a window", rule([]int{1}, "11:00", "13:00", &from, &until), until.Add(30 * time.Minute), false},
+	}
+	for _, tt := range tests {
+		if got := ruleActive(tt.rule, tt.at); got != tt.want {
+			t.Errorf("%s: ruleActive at %s = %v, want %v", tt.name, tt.at, got, tt.want)
+		}
+	}
+}
@@ -1,16 +1,25 @@
 package handlers
 
 import(
+	"context"
 	"encoding/json"
 	"database/sql"
+	"fmt"
 	"net/http"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"github.com/gorilla/mux"
+	"github.com/ray-remotestate/restro/cache"
+	"github.com/ray-remotestate/restro/config"
 	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/metrics"
+	"github.com/ray-remotestate/restro/middlewares"
+	"github.com/ray-remotestate/restro/models"
 )
 
 func CreateResource(w http.ResponseWriter, r *http.Request) {
@@ -20,17 +29,12 @@ func CreateResource(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, ok := r.Context().Value("user_id").(uuid.UUID)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	roles, ok := r.Context().Value("roles").([]string)
-	if !ok || len(roles) == 0 {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil || len(claims.Roles) == 0 {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	userID, roles := claims.UserID, claims.Roles
 
 	isAdmin := slices.Contains(roles, "admin")
 	isSubAdmin := slices.Contains(roles, "subadmin")
@@ -53,45 +57,18 @@ func CreateResource(w http.ResponseWriter, r *http.Request) {
 }
 
 func ListRestaurants(w http.ResponseWriter, r *http.Request) {
-	type Restaurant struct {
-		ID          uuid.UUID `json:"id"`
-		Name        string    `json:"name"`
-		Description string    `json:"description"`
-		Latitude    float64   `json:"latitude"`
-		Longitude   float64   `json:"longitude"`
-		CreatedAt   time.Time `json:"created_at"`
-	}
-
-	query := `
-		SELECT id, name, description, latitude, longitude, created_at
-		FROM restaurants
-		ORDER BY created_at DESC
-	`
-
-	rows, err := database.Restro.Query(query)
+	entry, err := loadRestaurants(r.Context())
 	if err != nil {
 		http.Error(w, "failed to query restaurants", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var restaurants []Restaurant
-	for rows.Next() {
-		var r Restaurant
-		if err := rows.Scan(&r.ID, &r.Name, &r.Description, &r.Latitude, &r.Longitude, &r.CreatedAt); err != nil {
-			http.Error(w, "error reading data", http.StatusInternalServerError)
-			return
-		}
-		restaurants = append(restaurants, r)
-	}
 
-	if err := rows.Err(); err != nil {
-		http.Error(w, "error iterating result", http.StatusInternalServerError)
+	restaurants := filterRestaurants(entry.Restaurants, tagFilter(r.URL.Query()))
+	if !sortRestaurants(restaurants, r.URL.Query().Get("sort")) {
+		http.Error(w, "invalid sort, expected newest or rating", http.StatusBadRequest)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(restaurants)
+	writeCachedJSON(w, r, restaurants, entry.LastModified)
 }
 
 func GetDishesByRestaurant(w http.ResponseWriter, r *http.Request) {
@@ -101,47 +78,44 @@ func GetDishesByRestaurant(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid restaurant ID", http.StatusBadRequest)
 		return
 	}
-
-	type Dish struct {
-		ID          uuid.UUID `json:"id"`
-		Name        string    `json:"name"`
-		Description string    `json:"description"`
-		Price       float64   `json:"price"`
-		IsAvailable bool      `json:"is_available"`
-		CreatedAt   time.Time `json:"created_at"`
-	}
-
-	query := `
-		SELECT id, name, description, price, is_available, created_at
-		FROM menu_items
-		WHERE restaurant_id = $1
-		ORDER BY created_at DESC
-	`
-
-	rows, err := database.Restro.Query(query, restaurantID)
+	excluded, err := allergenFilter(r.URL.Query())
 	if err != nil {
-		http.Error(w, "Failed to fetch dishes", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
-
-	var dishes []Dish
-	for rows.Next() {
-		var d Dish
-		if err := rows.Scan(&d.ID, &d.Name, &d.Description, &d.Price, &d.IsAvailable, &d.CreatedAt); err != nil {
-			http.Error(w, "Failed to read dish data", http.StatusInternalServerError)
+	at := time.Now()
+	if v := r.URL.Query().Get("at"); v != "" {
+		if at, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "invalid at, expected RFC3339", http.StatusBadRequest)
 			return
 		}
-		dishes = append(dishes, d)
 	}
 
-	if err := rows.Err(); err != nil {
-		http.Error(w, "Failed to iterate dishes", http.StatusInternalServerError)
+	menu, err := loadMenu(r.Context(), restaurantID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Restaurant not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch dishes", http.StatusInternalServerError)
+		return
+	}
+
+	// with named menus what is served changes with the time of day, not
+	// just the menu version, so the ETag names the menus being served and
+	// If-Modified-Since cannot be trusted
+	dishes, active := activeDishes(menu, at)
+	etag := fmt.Sprintf(`"%s.%d.v%d"`, restaurantID, menu.Version, middlewares.Version(r.Context()))
+	lastModified := menu.UpdatedAt
+	if len(menu.Schedules) > 0 {
+		etag = fmt.Sprintf(`"%s.%d.v%d.%s"`, restaurantID, menu.Version, middlewares.Version(r.Context()), scheduleTag(active))
+		lastModified = time.Time{}
+	}
+	if notModified(w, r, etag, lastModified) {
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(dishes)
+	json.NewEncoder(w).Encode(versionedPrices(r.Context(), filterDishes(dishes, tagFilter(r.URL.Query()), excluded)))
 }
 
 func GetDistance(w http.ResponseWriter, r *http.Request) {
@@ -178,11 +152,11 @@ func ListResources(w http.ResponseWriter,r *http.Request) {
 
 	switch resourceType {
 	case "user":
-		listUsers(w, userID, isAdmin)
+		listUsers(w, r, userID, isAdmin)
 	case "restaurant":
-		listRestaurantsByCreator(w, userID, isAdmin)
+		listRestaurantsByCreator(w, r, userID, isAdmin)
 	case "menu":
-		listMenuItemsByCreator(w, userID, isAdmin)
+		listMenuItemsByCreator(w, r, userID, isAdmin)
 	default:
 		http.Error(w, "Invalid resource type", http.StatusBadRequest)
 	}
@@ -202,23 +176,37 @@ func createUser(w http.ResponseWriter, r *http.Request, creatorID uuid.UUID) {
 		return
 	}
 
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
-
-	var userID uuid.UUID
-	err := database.Restro.QueryRow(`
-		INSERT INTO users (name, email, password, created_by)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id
-	`, input.Name, input.Email, string(hashedPassword), creatorID).Scan(&userID)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 	if err != nil {
-		http.Error(w, "User creation failed", http.StatusInternalServerError)
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
 	}
 
-	role := "user"
-	_, err = database.Restro.Exec(`INSERT INTO user_roles (user_id, role) VALUES ($1, $2)`, userID, role)
+	var userID uuid.UUID
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO users (name, email, password, created_by)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id
+		`, input.Name, input.Email, string(hashedPassword), creatorID).Scan(&userID)
+		if err != nil {
+			return err
+		}
+
+		role := "user"
+		_, err = tx.ExecContext(ctx, `INSERT INTO user_roles (user_id, role) VALUES ($1, $2)`, userID, role)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, r, creatorID, "user.create", "user", userID.String(), nil, map[string]string{
+			"name":  input.Name,
+			"email": input.Email,
+			"role":  role,
+		})
+	})
 	if err != nil {
-		http.Error(w, "Failed to assign role", http.StatusInternalServerError)
+		http.Error(w, "User creation failed", http.StatusInternalServerError)
 		return
 	}
 
@@ -234,6 +222,7 @@ func createRestaurant(w http.ResponseWriter, r *http.Request, creatorID uuid.UUI
 		Description string  `json:"description"`
 		Latitude    float64 `json:"latitude"`
 		Longitude   float64 `json:"longitude"`
+		Currency    string  `json:"currency"`
 	}
 
 	var input Input
@@ -241,17 +230,37 @@ func createRestaurant(w http.ResponseWriter, r *http.Request, creatorID uuid.UUI
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
+	currency := config.DefaultCurrency
+	if input.Currency != "" {
+		var err error
+		if currency, err = models.ParseCurrency(input.Currency); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	var restID uuid.UUID
-	err := database.Restro.QueryRow(`
-		INSERT INTO restaurants (name, owner_id, description, latitude, longitude, created_by)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id
-	`, input.Name, creatorID, input.Description, input.Latitude, input.Longitude, creatorID).Scan(&restID)
+	err := database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO restaurants (name, owner_id, description, latitude, longitude, currency, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+		`, input.Name, creatorID, input.Description, input.Latitude, input.Longitude, currency, creatorID).Scan(&restID)
+		if err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, restaurantsCacheKey); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, creatorID, "restaurant.create", "restaurant", restID.String(), nil, input)
+	})
 	if err != nil {
 		http.Error(w, "Failed to create restaurant", http.StatusInternalServerError)
 		return
 	}
+	// the notification reaches this instance too, but not before the response
+	cache.Catalog.Forget(r.Context(), restaurantsCacheKey)
+	metrics.RestaurantsCreated.Inc()
 
 	json.NewEncoder(w).Encode(map[string]string{
 		"message":      "Restaurant created",
@@ -261,28 +270,67 @@ func createRestaurant(w http.ResponseWriter, r *http.Request, creatorID uuid.UUI
 
 func createMenuItem(w http.ResponseWriter, r *http.Request, creatorID uuid.UUID) {
 	type Input struct {
-		RestaurantID uuid.UUID `json:"restaurant_id"`
-		Name         string    `json:"name"`
-		Description  string    `json:"description"`
-		Price        float64   `json:"price"`
+		RestaurantID uuid.UUID    `json:"restaurant_id"`
+		SKU          string       `json:"sku"`
+		Name         string       `json:"name"`
+		Description  string          `json:"description"`
+		Price        json.RawMessage `json:"price"`
 	}
 
 	var input Input
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || len(input.Price) == 0 {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
+	input.SKU = strings.TrimSpace(input.SKU)
+	if len(input.SKU) > maxSKULength {
+		http.Error(w, fmt.Sprintf("SKU must be at most %d characters", maxSKULength), http.StatusBadRequest)
+		return
+	}
+	// a restaurant's currency never changes, so it can be read up front
+	currency, err := dbhelper.RestaurantCurrency(r.Context(), database.Restro, input.RestaurantID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Restaurant not found", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to create menu item", http.StatusInternalServerError)
+		return
+	}
+	price, err := parseJSONPrice(input.Price, currency)
+	if err != nil {
+		http.Error(w, "Invalid price: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	var id uuid.UUID
-	err := database.Restro.QueryRow(`
-		INSERT INTO menu (restaurant_id, name, description, price, created_by)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id
-	`, input.RestaurantID, input.Name, input.Description, input.Price, creatorID).Scan(&id)
-	if err != nil {
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		id, err = dbhelper.InsertMenuRow(ctx, tx, input.RestaurantID, models.MenuRow{
+			SKU:         input.SKU,
+			Name:        input.Name,
+			Description: &input.Description,
+			Price:       price,
+		}, creatorID)
+		if err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, menuCacheKey(input.RestaurantID)); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, creatorID, "menu.create", "menu", id.String(), nil, input)
+	})
+	if err == sql.ErrNoRows {
+		http.Error(w, "Restaurant not found", http.StatusBadRequest)
+		return
+	} else if err == dbhelper.ErrSKUExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
 		http.Error(w, "Failed to create menu item", http.StatusInternalServerError)
 		return
 	}
+	cache.Catalog.Forget(r.Context(), menuCacheKey(input.RestaurantID))
+	metrics.MenuItemsCreated.Inc()
 
 	json.NewEncoder(w).Encode(map[string]string{
 		"message":     "Menu item created",
@@ -290,7 +338,7 @@ func createMenuItem(w http.ResponseWriter, r *http.Request, creatorID uuid.UUID)
 	})
 }
 
-func listUsers(w http.ResponseWriter, userID uuid.UUID, isAdmin bool) {
+func listUsers(w http.ResponseWriter, r *http.Request, userID uuid.UUID, isAdmin bool) {
 	type User struct {
 		ID    uuid.UUID `json:"id"`
 		Name  string    `json:"name"`
@@ -301,9 +349,9 @@ func listUsers(w http.ResponseWriter, userID uuid.UUID, isAdmin bool) {
 	var err error
 
 	if isAdmin {
-		rows, err = database.Restro.Query(`SELECT id, name, email FROM users WHERE archived_at IS NULL`)
+		rows, err = database.Restro.QueryContext(r.Context(), `SELECT id, name, email FROM users WHERE archived_at IS NULL`)
 	} else {
-		rows, err = database.Restro.Query(`SELECT id, name, email FROM users WHERE created_by = $1 AND archived_at IS NULL`, userID)
+		rows, err = database.Restro.QueryContext(r.Context(), `SELECT id, name, email FROM users WHERE created_by = $1 AND archived_at IS NULL`, userID)
 	}
 
 	if err != nil {
@@ -325,7 +373,7 @@ func listUsers(w http.ResponseWriter, userID uuid.UUID, isAdmin bool) {
 	json.NewEncoder(w).Encode(users)
 }
 
-func listRestaurantsByCreator(w http.ResponseWriter, userID uuid.UUID, isAdmin bool) {
+func listRestaurantsByCreator(w http.ResponseWriter, r *http.Request, userID uuid.UUID, isAdmin bool) {
 	type Restaurant struct {
 		ID          uuid.UUID `json:"id"`
 		Name        string    `json:"name"`
@@ -336,9 +384,9 @@ func listRestaurantsByCreator(w http.ResponseWriter, userID uuid.UUID, isAdmin b
 	var err error
 
 	if isAdmin {
-		rows, err = database.Restro.Query(`SELECT id, name, description FROM restaurants`)
+		rows, err = database.Restro.QueryContext(r.Context(), `SELECT id, name, description FROM restaurants`)
 	} else {
-		rows, err = database.Restro.Query(`SELECT id, name, description FROM restaurants WHERE created_by = $1`, userID)
+		rows, err = database.Restro.QueryContext(r.Context(), `SELECT id, name, description FROM restaurants WHERE created_by = $1`, userID)
 	}
 
 	if err != nil {
@@ -360,28 +408,31 @@ func listRestaurantsByCreator(w http.ResponseWriter, userID uuid.UUID, isAdmin b
 	json.NewEncoder(w).Encode(restaurants)
 }
 
-func listMenuItemsByCreator(w http.ResponseWriter, userID uuid.UUID, isAdmin bool) {
+func listMenuItemsByCreator(w http.ResponseWriter, r *http.Request, userID uuid.UUID, isAdmin bool) {
 	type MenuItem struct {
-		ID           uuid.UUID `json:"id"`
-		RestaurantID uuid.UUID `json:"restaurant_id"`
-		Name         string    `json:"name"`
-		Description  string    `json:"description"`
-		Price        float64   `json:"price"`
+		ID           uuid.UUID    `json:"id"`
+		RestaurantID uuid.UUID    `json:"restaurant_id"`
+		SKU          string       `json:"sku"`
+		Name         string       `json:"name"`
+		Description  string       `json:"description"`
+		Price        models.Money `json:"price"`
 	}
 
 	var rows *sql.Rows
 	var err error
 
 	if isAdmin {
-		rows, err = database.Restro.Query(`
-			SELECT id, restaurant_id, name, description, price
-			FROM menu_items
+		rows, err = database.Restro.QueryContext(r.Context(), `
+			SELECT m.id, m.restaurant_id, m.sku, m.name, coalesce(m.description, ''), (m.price_minor, r.currency)
+			FROM menu m
+			JOIN restaurants r ON r.id = m.restaurant_id
 		`)
 	} else {
-		rows, err = database.Restro.Query(`
-			SELECT id, restaurant_id, name, description, price
-			FROM menu_items
-			WHERE created_by = $1
+		rows, err = database.Restro.QueryContext(r.Context(), `
+			SELECT m.id, m.restaurant_id, m.sku, m.name, coalesce(m.description, ''), (m.price_minor, r.currency)
+			FROM menu m
+			JOIN restaurants r ON r.id = m.restaurant_id
+			WHERE m.created_by = $1
 		`, userID)
 	}
 
@@ -394,12 +445,12 @@ func listMenuItemsByCreator(w http.ResponseWriter, userID uuid.UUID, isAdmin boo
 	var items []MenuItem
 	for rows.Next() {
 		var m MenuItem
-		if err := rows.Scan(&m.ID, &m.RestaurantID, &m.Name, &m.Description, &m.Price); err != nil {
+		if err := rows.Scan(&m.ID, &m.RestaurantID, &m.SKU, &m.Name, &m.Description, &m.Price); err != nil {
 			http.Error(w, "Read error", http.StatusInternalServerError)
 			return
 		}
 		items = append(items, m)
 	}
 
-	json.NewEncoder(w).Encode(items)
+	json.NewEncoder(w).Encode(versionedPrices(r.Context(), items))
 }
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ray-remotestate/restro/cache"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/middlewares"
+	"github.com/ray-remotestate/restro/models"
+)
+
+const (
+	defaultReviewLimit = 20
+	maxReviewBody      = 4000
+	maxModerationNote  = 500
+)
+
+// rating is the average and count of the ratings a restaurant or dish got in
+// reviews that are not hidden.
+type rating struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+var (
+	errOwnRestaurant = errors.New("owners cannot review their own restaurant")
+	errNotOrdered    = errors.New("only customers who ordered can review")
+)
+
+// mayReview decides whether a user can review a restaurant. There are no
+// orders yet, so everyone can; once orders are recorded this is the place to
+// require one.
+var mayReview = func(ctx context.Context, exec dbhelper.SQLExecutor, userID, restaurantID uuid.UUID) (bool, error) {
+	return true, nil
+}
+
+// ListReviews lists the reviews of a restaurant that are not hidden, newest
+// first.
+func ListReviews(w http.ResponseWriter, r *http.Request) {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid restaurant ID", http.StatusBadRequest)
+		return
+	}
+	filter := models.ReviewFilter{RestaurantID: id}
+	if !parsePage(w, r, &filter) {
+		return
+	}
+
+	if _, err := dbhelper.RestaurantOwner(r.Context(), database.Restro, id); err == sql.ErrNoRows {
+		http.Error(w, "restaurant not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to query reviews", http.StatusInternalServerError)
+		return
+	}
+	reviews, err := dbhelper.ListReviews(r.Context(), database.Restro, filter)
+	if err != nil {
+		http.Error(w, "failed to query reviews", http.StatusInternalServerError)
+		return
+	}
+	if !isModerator(claims) {
+		for i := range reviews {
+			reviews[i].ModerationReason, reviews[i].ModeratedBy, reviews[i].ModeratedAt = nil, nil, nil
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reviews)
+}
+
+// SaveReview creates or replaces the caller's review of a restaurant.
+func SaveReview(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Rating int                 `json:"rating"`
+		Body   string              `json:"body"`
+		Dishes []models.DishRating `json:"dishes"`
+	}
+
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid restaurant ID", http.StatusBadRequest)
+		return
+	}
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Body = strings.TrimSpace(req.Body)
+	if req.Rating < 1 || req.Rating > 5 {
+		http.Error(w, "rating must be 1 to 5", http.StatusBadRequest)
+		return
+	}
+	if len(req.Body) > maxReviewBody {
+		http.Error(w, "body is at most 4000 characters", http.StatusBadRequest)
+		return
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, d := range req.Dishes {
+		if d.Rating < 1 || d.Rating > 5 {
+			http.Error(w, "dish ratings must be 1 to 5", http.StatusBadRequest)
+			return
+		}
+		if seen[d.MenuItemID] {
+			http.Error(w, "a dish is rated more than once", http.StatusBadRequest)
+			return
+		}
+		seen[d.MenuItemID] = true
+	}
+
+	var review models.Review
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		owner, err := dbhelper.RestaurantOwner(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if owner == claims.UserID {
+			return errOwnRestaurant
+		}
+		if ok, err := mayReview(ctx, tx, claims.UserID, id); err != nil {
+			return err
+		} else if !ok {
+			return errNotOrdered
+		}
+
+		var before *models.Review
+		if existing, err := dbhelper.GetUserReview(ctx, tx, id, claims.UserID, true); err == nil {
+			before = &existing
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+		review, err = dbhelper.SaveReview(ctx, tx, models.Review{
+			RestaurantID: id,
+			UserID:       claims.UserID,
+			Rating:       req.Rating,
+			Body:         req.Body,
+			Dishes:       req.Dishes,
+		})
+		if err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, restaurantsCacheKey, menuCacheKey(id)); err != nil {
+			return err
+		}
+		action := "review.create"
+		if before != nil {
+			action = "review.update"
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, action, "review", review.ID.String(), before, review)
+	})
+	if !reviewDone(w, err, "restaurant not found", "failed to save review") {
+		return
+	}
+	cache.Catalog.Forget(r.Context(), restaurantsCacheKey, menuCacheKey(id))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// DeleteReview deletes the caller's review of a restaurant.
+func DeleteReview(w http.ResponseWriter, r *http.Request) {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid restaurant ID", http.StatusBadRequest)
+		return
+	}
+
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		before, err := dbhelper.GetUserReview(ctx, tx, id, claims.UserID, true)
+		if err != nil {
+			return err
+		}
+		if err := dbhelper.DeleteReview(ctx, tx, before.ID); err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, restaurantsCacheKey, menuCacheKey(id)); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "review.delete", "review", before.ID.String(), before, nil)
+	})
+	if !reviewDone(w, err, "review not found", "failed to delete review") {
+		return
+	}
+	cache.Catalog.Forget(r.Context(), restaurantsCacheKey, menuCacheKey(id))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Review deleted",
+	})
+}
+
+// ReplyToReview sets the restaurant's public reply to a review. Only the
+// restaurant's owner, or an admin, can reply.
+func ReplyToReview(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Reply string `json:"reply"`
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Reply = strings.TrimSpace(req.Reply)
+	if req.Reply == "" || len(req.Reply) > maxReviewBody {
+		http.Error(w, "reply is required and at most 4000 characters", http.StatusBadRequest)
+		return
+	}
+	setReply(w, r, &req.Reply)
+}
+
+// DeleteReviewReply removes the restaurant's reply to a review.
+func DeleteReviewReply(w http.ResponseWriter, r *http.Request) {
+	setReply(w, r, nil)
+}
+
+func setReply(w http.ResponseWriter, r *http.Request, reply *string) {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid review ID", http.StatusBadRequest)
+		return
+	}
+
+	var review models.Review
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		before, err := dbhelper.GetReview(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+		owner, err := dbhelper.RestaurantOwner(ctx, tx, before.RestaurantID)
+		if err != nil {
+			return err
+		}
+		if !canManage(claims, owner) {
+			return errNotCreator
+		}
+		if review, err = dbhelper.SetReviewReply(ctx, tx, id, reply, claims.UserID); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "review.reply", "review", id.String(),
+			map[string]*string{"reply": before.Reply}, map[string]*string{"reply": reply})
+	})
+	if !reviewDone(w, err, "review not found", "failed to update reply") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// ListReviewsForModeration lists reviews by status, flagged ones by default,
+// optionally of one restaurant.
+func ListReviewsForModeration(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.ReviewFilter{Statuses: []models.ReviewStatus{models.ReviewFlagged}}
+	if values := queryList(query, "status"); len(values) > 0 {
+		filter.Statuses = nil
+		for _, v := range values {
+			status := models.ReviewStatus(v)
+			if !status.IsValid() {
+				http.Error(w, "invalid status, expected visible, flagged or hidden", http.StatusBadRequest)
+				return
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+	if v := query.Get("restaurant_id"); v != "" {
+		var err error
+		if filter.RestaurantID, err = uuid.Parse(v); err != nil {
+			http.Error(w, "invalid restaurant_id", http.StatusBadRequest)
+			return
+		}
+	}
+	if !parsePage(w, r, &filter) {
+		return
+	}
+
+	reviews, err := dbhelper.ListReviews(r.Context(), database.Restro, filter)
+	if err != nil {
+		http.Error(w, "failed to query reviews", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reviews)
+}
+
+// ModerateReview flags or hides a review, with a reason, or makes it
+// visible again.
+func ModerateReview(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Status models.ReviewStatus `json:"status"`
+		Reason string              `json:"reason"`
+	}
+
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid review ID", http.StatusBadRequest)
+		return
+	}
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !req.Status.IsValid() {
+		http.Error(w, "status must be visible, flagged or hidden", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	var reason *string
+	if req.Status != models.ReviewVisible {
+		if req.Reason == "" || len(req.Reason) > maxModerationNote {
+			http.Error(w, "reason is required and at most 500 characters", http.StatusBadRequest)
+			return
+		}
+		reason = &req.Reason
+	}
+
+	var review models.Review
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		before, err := dbhelper.GetReview(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+		if review, err = dbhelper.ModerateReview(ctx, tx, id, req.Status, reason, claims.UserID); err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx,
+			restaurantsCacheKey, menuCacheKey(review.RestaurantID)); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "review.moderate", "review", id.String(),
+			map[string]any{"status": before.Status, "reason": before.ModerationReason},
+			map[string]any{"status": review.Status, "reason": review.ModerationReason})
+	})
+	if !reviewDone(w, err, "review not found", "failed to moderate review") {
+		return
+	}
+	cache.Catalog.Forget(r.Context(), restaurantsCacheKey, menuCacheKey(review.RestaurantID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// parsePage reads the limit and offset query parameters into filter.
+func parsePage(w http.ResponseWriter, r *http.Request, filter *models.ReviewFilter) bool {
+	query := r.URL.Query()
+	filter.Limit = defaultReviewLimit
+	var err error
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 || filter.Limit > 100 {
+			http.Error(w, "invalid limit, expected 1 to 100", http.StatusBadRequest)
+			return false
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil || filter.Offset < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// reviewDone writes the error response for err, if any.
+func reviewDone(w http.ResponseWriter, err error, notFound, failed string) bool {
+	var unknown *dbhelper.UnknownDishesError
+	switch {
+	case err == nil:
+		return true
+	case err == sql.ErrNoRows:
+		http.Error(w, notFound, http.StatusNotFound)
+	case err == errNotCreator:
+		http.Error(w, "forbidden", http.StatusForbidden)
+	case err == errOwnRestaurant, err == errNotOrdered:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.As(err, &unknown):
+		http.Error(w, unknown.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, failed, http.StatusInternalServerError)
+	}
+	return false
+}
+
+func isModerator(claims *middlewares.Claims) bool {
+	return slices.Contains(claims.Roles, string(models.RoleAdmin)) || slices.Contains(claims.Roles, string(models.RoleSubAdmin))
+}
+
+// sortRestaurants orders a listing by the sort query parameter: "rating"
+// puts the best rated first, ties going to the more reviewed; "newest", the
+// default, keeps the listing's order.
+func sortRestaurants(restaurants []restaurantListing, sort string) bool {
+	switch sort {
+	case "", "newest":
+	case "rating":
+		slices.SortStableFunc(restaurants, func(a, b restaurantListing) int {
+			if a.Rating.Average != b.Rating.Average {
+				if a.Rating.Average > b.Rating.Average {
+					return -1
+				}
+				return 1
+			}
+			return b.Rating.Count - a.Rating.Count
+		})
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ray-remotestate/restro/cache"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/middlewares"
+	"github.com/ray-remotestate/restro/models"
+)
+
+const maxMenuWindows = 20
+
+// ListNamedMenus lists a restaurant's named menus, such as breakfast and
+// dinner, with their published and draft versions.
+func ListNamedMenus(w http.ResponseWriter, r *http.Request) {
+	claims, id, ok := parseRestaurantRequest(w, r)
+	if !ok {
+		return
+	}
+
+	creator, err := dbhelper.RestaurantCreator(r.Context(), database.Restro, id)
+	if err == nil && !canManage(claims, creator) {
+		err = errNotCreator
+	}
+	if !scheduleDone(w, err, "failed to query menus") {
+		return
+	}
+	menus, err := dbhelper.ListNamedMenus(r.Context(), database.Restro, id)
+	if err != nil {
+		http.Error(w, "failed to query menus", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(menus)
+}
+
+// CreateNamedMenu adds a named menu to a restaurant with an empty draft.
+// Nothing changes for diners until a version is published.
+func CreateNamedMenu(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Name string `json:"name"`
+	}
+
+	claims, id, ok := parseRestaurantRequest(w, r)
+	if !ok {
+		return
+	}
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "name is required and at most 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	var menu models.NamedMenu
+	err := database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		creator, err := dbhelper.RestaurantCreator(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !canManage(claims, creator) {
+			return errNotCreator
+		}
+		if menu, err = dbhelper.CreateNamedMenu(ctx, tx, id, req.Name, claims.UserID); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "named_menu.create", "named_menu", menu.ID.String(), nil, menu)
+	})
+	if !scheduleDone(w, err, "failed to create menu") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(menu)
+}
+
+// ArchiveNamedMenu takes a named menu, and its versions, off the catalog.
+func ArchiveNamedMenu(w http.ResponseWriter, r *http.Request) {
+	claims, id, ok := parseRestaurantRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var menu models.NamedMenu
+	err := database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if menu, err = authorizeNamedMenu(ctx, tx, claims, id, true); err != nil {
+			return err
+		}
+		if err := dbhelper.ArchiveNamedMenu(ctx, tx, id); err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, menuCacheKey(menu.RestaurantID)); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "named_menu.archive", "named_menu", id.String(), menu, nil)
+	})
+	if !scheduleDone(w, err, "failed to archive menu") {
+		return
+	}
+	cache.Catalog.Forget(r.Context(), menuCacheKey(menu.RestaurantID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Menu archived",
+	})
+}
+
+// ListMenuVersions lists the versions of a named menu, latest first.
+func ListMenuVersions(w http.ResponseWriter, r *http.Request) {
+	claims, id, ok := parseRestaurantRequest(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := authorizeNamedMenu(r.Context(), database.Restro, claims, id, false)
+	if !scheduleDone(w, err, "failed to query menu versions") {
+		return
+	}
+	versions, err := dbhelper.ListMenuVersions(r.Context(), database.Restro, id)
+	if err != nil {
+		http.Error(w, "failed to query menu versions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+func GetMenuVersion(w http.ResponseWriter, r *http.Request) {
+	claims, id, ok := parseRestaurantRequest(w, r)
+	if !ok {
+		return
+	}
+	number, err := strconv.Atoi(mux.Vars(r)["number"])
+	if err != nil || number < 1 {
+		http.Error(w, "invalid version number", http.StatusBadRequest)
+		return
+	}
+
+	_, err = authorizeNamedMenu(r.Context(), database.Restro, claims, id, false)
+	if !scheduleDone(w, err, "failed to query menu version") {
+		return
+	}
+	version, err := dbhelper.GetMenuVersion(r.Context(), database.Restro, id, number)
+	if !scheduleDone(w, err, "failed to query menu version") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
+
+// SaveMenuDraft sets which dishes a named menu holds, in order, and when it
+// is served. It writes the draft, starting one after the latest version if
+// the last was published; diners see it once it is published.
+func SaveMenuDraft(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Items   []uuid.UUID         `json:"menu_item_ids"`
+		Windows []models.MenuWindow `json:"windows"`
+	}
+
+	claims, id, ok := parseRestaurantRequest(w, r)
+	if !ok {
+		return
+	}
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if len(req.Windows) > maxMenuWindows {
+		http.Error(w, "at most "+strconv.Itoa(maxMenuWindows)+" windows", http.StatusBadRequest)
+		return
+	}
+	for i := range req.Windows {
+		if msg := validateWindow(&req.Windows[i].Days, req.Windows[i].StartsAt, req.Windows[i].EndsAt); msg != "" {
+			http.Error(w, "windows["+strconv.Itoa(i)+"]: "+msg, http.StatusBadRequest)
+			return
+		}
+	}
+	items := []uuid.UUID{}
+	for _, item := range req.Items {
+		if !slices.Contains(items, item) {
+			items = append(items, item)
+		}
+	}
+
+	var draft models.MenuVersion
+	err := database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		menu, err := authorizeNamedMenu(ctx, tx, claims, id, true)
+		if err != nil {
+			return err
+		}
+		var before *models.MenuVersion
+		if menu.DraftVersion != nil {
+			v, err := dbhelper.GetMenuVersion(ctx, tx, id, *menu.DraftVersion)
+			if err != nil {
+				return err
+			}
+			before = &v
+		}
+		if draft, err = dbhelper.SaveMenuDraft(ctx, tx, menu, items, req.Windows, claims.UserID); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "named_menu.draft", "named_menu", id.String(), before, draft)
+	})
+	if !scheduleDone(w, err, "failed to save menu draft") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(draft)
+}
+
+// PublishMenu serves a named menu's draft from now on.
+func PublishMenu(w http.ResponseWriter, r *http.Request) {
+	claims, id, ok := parseRestaurantRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var version models.MenuVersion
+	var menu models.NamedMenu
+	err := database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if menu, err = authorizeNamedMenu(ctx, tx, claims, id, true); err != nil {
+			return err
+		}
+		if version, err = dbhelper.PublishMenuDraft(ctx, tx, id, claims.UserID); err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, menuCacheKey(menu.RestaurantID)); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "named_menu.publish", "named_menu", id.String(), menu, version)
+	})
+	if !scheduleDone(w, err, "failed to publish menu") {
+		return
+	}
+	cache.Catalog.Forget(r.Context(), menuCacheKey(menu.RestaurantID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
+
+// RollbackMenu serves an earlier published version of a named menu again.
+// The draft, if any, is left alone.
+func RollbackMenu(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Version int `json:"version"`
+	}
+
+	claims, id, ok := parseRestaurantRequest(w, r)
+	if !ok {
+		return
+	}
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Version < 1 {
+		http.Error(w, "invalid request, expected version", http.StatusBadRequest)
+		return
+	}
+
+	var version models.MenuVersion
+	var menu models.NamedMenu
+	err := database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if menu, err = authorizeNamedMenu(ctx, tx, claims, id, true); err != nil {
+			return err
+		}
+		if version, err = dbhelper.RollbackMenu(ctx, tx, id, req.Version); err == sql.ErrNoRows {
+			return errNotPublished
+		} else if err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, menuCacheKey(menu.RestaurantID)); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "named_menu.rollback", "named_menu", id.String(), menu, version)
+	})
+	if !scheduleDone(w, err, "failed to roll back menu") {
+		return
+	}
+	cache.Catalog.Forget(r.Context(), menuCacheKey(menu.RestaurantID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
+
+var errNotPublished = errors.New("no published version with this number")
+
+// authorizeNamedMenu returns a named menu the caller may manage. With
+// forUpdate the menu stays locked for the rest of the transaction.
+func authorizeNamedMenu(ctx context.Context, exec dbhelper.SQLExecutor, claims *middlewares.Claims, id uuid.UUID, forUpdate bool) (models.NamedMenu, error) {
+	menu, err := dbhelper.GetNamedMenu(ctx, exec, id, forUpdate)
+	if err != nil {
+		return menu, err
+	}
+	creator, err := dbhelper.RestaurantCreator(ctx, exec, menu.RestaurantID)
+	if err != nil {
+		return menu, err
+	}
+	if !canManage(claims, creator) {
+		return menu, errNotCreator
+	}
+	return menu, nil
+}
+
+// scheduleDone writes the error response for err, if any.
+func scheduleDone(w http.ResponseWriter, err error, failed string) bool {
+	switch {
+	case err == nil:
+		return true
+	case err == sql.ErrNoRows:
+		http.Error(w, "not found", http.StatusNotFound)
+	case err == errNotCreator:
+		http.Error(w, "forbidden", http.StatusForbidden)
+	case err == errNotPublished:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err == dbhelper.ErrMenuExists, err == dbhelper.ErrNoDraft, err == dbhelper.ErrEmptyDraft:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.As(err, new(*dbhelper.UnknownDishesError)):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, failed, http.StatusInternalServerError)
+	}
+	return false
+}
+
+// activeDishes returns the dishes a restaurant serves at at: those of the
+// published named menus whose windows include at, in menu order, or every
+// dish when the restaurant has no published named menu. active lists the
+// named menus being served.
+func activeDishes(menu menuEntry, at time.Time) (dishes []dish, active []uuid.UUID) {
+	if len(menu.Schedules) == 0 {
+		return menu.Dishes, nil
+	}
+	byID := make(map[uuid.UUID]dish, len(menu.Dishes))
+	for _, d := range menu.Dishes {
+		byID[d.ID] = d
+	}
+	dishes = []dish{}
+	seen := map[uuid.UUID]bool{}
+	for _, s := range menu.Schedules {
+		if !scheduleActive(s, at) {
+			continue
+		}
+		active = append(active, s.ID)
+		for _, id := range s.Items {
+			if d, ok := byID[id]; ok && !seen[id] {
+				seen[id] = true
+				dishes = append(dishes, d)
+			}
+		}
+	}
+	return dishes, active
+}
+
+// scheduleActive reports whether a named menu is served at at. One without
+// windows is served all day.
+func scheduleActive(s scheduledMenu, at time.Time) bool {
+	if len(s.Windows) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.Windows, func(win models.MenuWindow) bool {
+		return inWindow(win.Days, win.StartsAt, win.EndsAt, at)
+	})
+}
+
+// scheduleTag names a set of named menus for the ETag of the dishes they
+// serve.
+func scheduleTag(active []uuid.UUID) string {
+	h := sha256.New()
+	for _, id := range active {
+		h.Write(id[:])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ray-remotestate/restro/config"
+	"github.com/ray-remotestate/restro/models"
+)
+
+func TestInWindow(t *testing.T) {
+	config.Timezone = time.UTC
+	weekdays := []int{1, 2, 3, 4, 5}
+	// 2026-10-19 is a Monday
+	monday := func(clock string) time.Time {
+		at, err := time.Parse(time.RFC3339, "2026-10-19T"+clock+":00Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+
+	tests := []struct {
+		name             string
+		days             []int
+		startsAt, endsAt string
+		at               time.Time
+		want             bool
+	}{
+		{"inside", weekdays, "07:00", "11:00", monday("08:30"), true},
+		{"at start", weekdays, "07:00", "11:00", monday("07:00"), true},
+		{"at end", weekdays, "07:00", "11:00", monday("11:00"), false},
+		{"before", weekdays, "07:00", "11:00", monday("06:59"), false},
+		{"other day", []int{6, 7}, "07:00", "11:00", monday("08:30"), false},
+		{"sunday", []int{7}, "07:00", "11:00", monday("08:30").AddDate(0, 0, -1), true},
+		{"past midnight, evening", []int{1}, "22:00", "02:00", monday("23:00"), true},
+		{"past midnight, next morning", []int{1}, "22:00", "02:00", monday("01:00").AddDate(0, 0, 1), true},
+		{"past midnight, started the day before", []int{7}, "22:00", "02:00", monday("01:00"), true},
+		{"past midnight, not the day before", []int{1}, "22:00", "02:00", monday("01:00"), false},
+		{"past midnight, after it ends", []int{1}, "22:00", "02:00", monday("02:00").AddDate(0, 0, 1), false},
+		{"past midnight, before it starts", []int{1}, "22:00", "02:00", monday("21:59"), false},
+		{"bad clock", weekdays, "7am", "11:00", monday("08:30"), false},
+	}
+	for _, tt := range tests {
+		if got := inWindow(tt.days, tt.startsAt, tt.endsAt, tt.at); got != tt.want {
+			t.Errorf("%s: inWindow(%v, %s, %s, %s) = %v, want %v", tt.name, tt.days, tt.startsAt, tt.endsAt, tt.at, got, tt.want)
+		}
+	}
+
+	// windows are local times
+	config.Timezone = time.FixedZone("UTC+5", 5*60*60)
+	defer func() { config.Timezone = time.UTC }()
+	if !inWindow(weekdays, "07:00", "11:00", monday("03:00")) {
+		t.Error("03:00 UTC is 08:00 at UTC+5, want it inside 07:00-11:00")
+	}
+}
+
+func TestActiveDishes(t *testing.T) {
+	config.Timezone = time.UTC
+	eggs, toast, soup := dish{ID: uuid.New(), Name: "eggs"}, dish{ID: uuid.New(), Name: "toast"}, dish{ID: uuid.New(), Name: "soup"}
+	breakfast := scheduledMenu{
+		ID:      uuid.New(),
+		Name:    "breakfast",
+		Items:   []uuid.UUID{toast.ID, eggs.ID},
+		Windows: []models.MenuWindow{{Days: []int{1, 2, 3, 4, 5, 6, 7}, StartsAt: "07:00", EndsAt: "11:00"}},
+	}
+	lunch := scheduledMenu{
+		ID:      uuid.New(),
+		Name:    "lunch",
+		Items:   []uuid.UUID{soup.ID, toast.ID, uuid.New()}, // the last one is off the menu
+		Windows: []models.MenuWindow{{Days: []int{1, 2, 3, 4, 5, 6, 7}, StartsAt: "10:00", EndsAt: "15:00"}},
+	}
+	allDay := scheduledMenu{ID: uuid.New(), Name: "drinks", Items: []uuid.UUID{soup.ID}}
+	all := []dish{eggs, toast, soup}
+	at := func(clock string) time.Time {
+		at, _ := time.Parse(time.RFC3339, "2026-10-19T"+clock+":00Z")
+		return at
+	}
+	names := func(dishes []dish) []string {
+		var names []string
+		for _, d := range dishes {
+			names = append(names, d.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name       string
+		schedules  []scheduledMenu
+		at         time.Time
+		wantDishes []string
+		wantActive []uuid.UUID
+	}{
+		{"no named menus", nil, at("08:00"), []string{"eggs", "toast", "soup"}, nil},
+		{"one served", []scheduledMenu{breakfast, lunch}, at("08:00"), []string{"toast", "eggs"}, []uuid.UUID{breakfast.ID}},
+		{"overlap in menu order", []scheduledMenu{breakfast, lunch}, at("10:30"), []string{"toast", "eggs", "soup"}, []uuid.UUID{breakfast.ID, lunch.ID}},
+		{"none served", []scheduledMenu{breakfast, lunch}, at("20:00"), nil, nil},
+		{"no windows is all day", []scheduledMenu{breakfast, allDay}, at("20:00"), []string{"soup"}, []uuid.UUID{allDay.ID}},
+	}
+	for _, tt := range tests {
+		dishes, active := activeDishes(menuEntry{Dishes: all, Schedules: tt.schedules}, tt.at)
+		if got := names(dishes); !slices.Equal(got, tt.wantDishes) {
+			t.Errorf("%s: dishes = %v, want %v", tt.name, got, tt.wantDishes)
+		}
+		if !slices.Equal(active, tt.wantActive) {
+			t.Errorf("%s: active = %v, want %v", tt.name, active, tt.wantActive)
+		}
+		if tt.schedules != nil && dishes == nil {
+			t.Errorf("%s: dishes is nil, want an empty list", tt.name)
+		}
+	}
+}
+
+func TestScheduleTag(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	tag := scheduleTag([]uuid.UUID{a, b})
+	if len(tag) != 16 {
+		t.Errorf("scheduleTag = %q, want 16 hex digits", tag)
+	}
+	if scheduleTag([]uuid.UUID{a, b}) != tag {
+		t.Error("scheduleTag is not stable")
+	}
+	for _, other := range [][]uuid.UUID{{b, a}, {a}, nil} {
+		if scheduleTag(other) == tag {
+			t.Errorf("scheduleTag(%v) = scheduleTag(%v)", other, []uuid.UUID{a, b})
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/models"
+	"github.com/ray-remotestate/restro/utils"
+)
+
+const (
+	maxSearchQuery      = 200
+	defaultSearchLimit  = 20
+	maxSearchLimit      = 50
+	dishesPerRestaurant = 5
+	// a restaurant nearbyKm away gets half the boost of one next door
+	nearbyKm = 5.0
+)
+
+type searchDish struct {
+	ID          uuid.UUID    `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Price       models.Money `json:"price"`
+	IsAvailable bool         `json:"is_available"`
+	Score       float64      `json:"score"`
+	Snippet     string       `json:"snippet"`
+}
+
+type searchResult struct {
+	Restaurant models.RestaurantMatch `json:"restaurant"`
+	Score      float64                `json:"score"`
+	DistanceKm *float64               `json:"distance_km,omitempty"`
+	// Snippet is empty when only the restaurant's dishes matched.
+	Snippet string       `json:"snippet,omitempty"`
+	Dishes  []searchDish `json:"dishes"`
+}
+
+type searchResponse struct {
+	Query   string         `json:"query"`
+	Results []searchResult `json:"results"`
+}
+
+// Search finds restaurants and dishes by name and description. Dishes are
+// grouped under their restaurant, and results near lat/lon rank higher.
+func Search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		http.Error(w, "missing q", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(q) > maxSearchQuery {
+		http.Error(w, "q is too long", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if v := query.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxSearchLimit {
+			http.Error(w, "invalid limit, expected 1 to 50", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var lat, lon float64
+	near := query.Has("lat") || query.Has("lon")
+	if near {
+		var errLat, errLon error
+		lat, errLat = strconv.ParseFloat(query.Get("lat"), 64)
+		lon, errLon = strconv.ParseFloat(query.Get("lon"), 64)
+		if errLat != nil || errLon != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			http.Error(w, "invalid lat/lon", http.StatusBadRequest)
+			return
+		}
+	}
+
+	restaurants, dishes, err := dbhelper.SearchCatalog(r.Context(), q, limit, limit*dishesPerRestaurant)
+	if err != nil {
+		http.Error(w, "failed to search", http.StatusInternalServerError)
+		return
+	}
+
+	byRestaurant := make(map[uuid.UUID]*searchResult)
+	result := func(m models.RestaurantMatch) *searchResult {
+		res, ok := byRestaurant[m.ID]
+		if !ok {
+			res = &searchResult{Restaurant: m, Dishes: []searchDish{}}
+			byRestaurant[m.ID] = res
+		}
+		return res
+	}
+	for _, m := range restaurants {
+		res := result(m)
+		res.Score = m.Rank
+		res.Snippet = m.Snippet
+	}
+	// dishes arrive best first, so the first ones kept are the best
+	for _, d := range dishes {
+		res := result(d.Restaurant)
+		if len(res.Dishes) == dishesPerRestaurant {
+			continue
+		}
+		res.Dishes = append(res.Dishes, searchDish{
+			ID:          d.ID,
+			Name:        d.Name,
+			Description: d.Description,
+			Price:       d.Price,
+			IsAvailable: d.IsAvailable,
+			Score:       d.Rank,
+			Snippet:     d.Snippet,
+		})
+		res.Score = max(res.Score, d.Rank)
+	}
+
+	results := make([]searchResult, 0, len(byRestaurant))
+	for _, res := range byRestaurant {
+		if near && res.Restaurant.Latitude != nil && res.Restaurant.Longitude != nil {
+			km := utils.HaversineKm(lat, lon, *res.Restaurant.Latitude, *res.Restaurant.Longitude)
+			res.DistanceKm = &km
+			res.Score *= 1 + 1/(1+km/nearbyKm)
+		}
+		results = append(results, *res)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Restaurant.Name < results[j].Restaurant.Name
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versionedPrices(r.Context(), searchResponse{Query: q, Results: results}))
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/middlewares"
+)
+
+func ListSessions(w http.ResponseWriter, r *http.Request) {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := dbhelper.ListActiveSessions(r.Context(), claims.UserID)
+	if err != nil {
+		http.Error(w, "failed to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessionID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		revoked, err := dbhelper.RevokeSession(ctx, tx, claims.UserID, sessionID)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return sql.ErrNoRows
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "session.revoke", "session", sessionID.String(), nil, nil)
+	})
+	if err == sql.ErrNoRows {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	middlewares.ForgetSessions(sessionID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Session revoked",
+	})
+}
+
+func RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var revoked []uuid.UUID
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		if revoked, err = dbhelper.RevokeSessionsExcept(ctx, tx, claims.UserID, claims.SessionID); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "session.revoke_others", "user", claims.UserID.String(), nil, map[string]int{
+			"revoked": len(revoked),
+		})
+	})
+	if err != nil {
+		http.Error(w, "failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	middlewares.ForgetSessions(revoked...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Other sessions revoked",
+		"revoked": len(revoked),
+	})
+}
+
+// ForceLogoutUser lets an admin end every session of a user.
+func ForceLogoutUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var revoked []uuid.UUID
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		if revoked, err = dbhelper.RevokeSessionsExcept(ctx, tx, userID, uuid.Nil); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "session.force_logout", "user", userID.String(), nil, map[string]int{
+			"revoked": len(revoked),
+		})
+	})
+	if err != nil {
+		http.Error(w, "failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	middlewares.ForgetSessions(revoked...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "User logged out from all sessions",
+		"revoked": len(revoked),
+	})
+}
@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ray-remotestate/restro/cache"
+	"github.com/ray-remotestate/restro/database"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/middlewares"
+	"github.com/ray-remotestate/restro/models"
+)
+
+var tagSlug = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// errNotCreator is returned inside transactions when a subadmin changes a
+// restaurant or menu item that someone else created.
+var errNotCreator = errors.New("not the creator")
+
+func ListTags(w http.ResponseWriter, r *http.Request) {
+	kind := models.TagKind(r.URL.Query().Get("kind"))
+	if kind != "" && !kind.IsValid() {
+		http.Error(w, "invalid kind", http.StatusBadRequest)
+		return
+	}
+
+	tags, err := loadTags(r.Context())
+	if err != nil {
+		http.Error(w, "failed to query tags", http.StatusInternalServerError)
+		return
+	}
+	if kind != "" {
+		tags = slices.DeleteFunc(tags, func(t models.Tag) bool { return t.Kind != kind })
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+func CreateTag(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Slug string         `json:"slug"`
+		Name string         `json:"name"`
+		Kind models.TagKind `json:"kind"`
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if !validTag(w, req.Name, req.Kind) {
+		return
+	}
+	if len(req.Slug) > 50 || !tagSlug.MatchString(req.Slug) {
+		http.Error(w, "slug must be lowercase letters, digits and single hyphens, up to 50 characters", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var tag models.Tag
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if tag, err = dbhelper.CreateTag(ctx, tx, req.Slug, req.Name, req.Kind, claims.UserID); err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, tagsCacheKey); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "tag.create", "tag", tag.ID.String(), nil, tag)
+	})
+	if err == dbhelper.ErrTagExists {
+		http.Error(w, "a tag with this slug already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to create tag", http.StatusInternalServerError)
+		return
+	}
+	cache.Catalog.Forget(r.Context(), tagsCacheKey)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tag)
+}
+
+// UpdateTag renames or reclassifies a tag. The slug stays, since clients
+// filter by it.
+func UpdateTag(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Name string         `json:"name"`
+		Kind models.TagKind `json:"kind"`
+	}
+
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid tag ID", http.StatusBadRequest)
+		return
+	}
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if !validTag(w, req.Name, req.Kind) {
+		return
+	}
+
+	var tag models.Tag
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		before, err := dbhelper.GetTagForUpdate(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if tag, err = dbhelper.UpdateTag(ctx, tx, id, req.Name, req.Kind); err != nil {
+			return err
+		}
+		// every listing carrying the tag changes
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, cache.PurgeKey); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "tag.update", "tag", id.String(), before, tag)
+	})
+	if err == sql.ErrNoRows {
+		http.Error(w, "tag not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to update tag", http.StatusInternalServerError)
+		return
+	}
+	cache.Catalog.Purge(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tag)
+}
+
+// ArchiveTag removes a tag from the taxonomy and from every listing.
+func ArchiveTag(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid tag ID", http.StatusBadRequest)
+		return
+	}
+
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		before, err := dbhelper.GetTagForUpdate(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := dbhelper.ArchiveTag(ctx, tx, id); err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, cache.PurgeKey); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "tag.archive", "tag", id.String(), before, nil)
+	})
+	if err == sql.ErrNoRows {
+		http.Error(w, "tag not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to archive tag", http.StatusInternalServerError)
+		return
+	}
+	cache.Catalog.Purge(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Tag archived",
+	})
+}
+
+// SetRestaurantTags replaces the tags of a restaurant. Subadmins can only tag
+// restaurants they created.
+func SetRestaurantTags(w http.ResponseWriter, r *http.Request) {
+	claims, id, slugs, ok := parseTagging(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		creator, err := dbhelper.RestaurantCreator(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !canManage(claims, creator) {
+			return errNotCreator
+		}
+		before, err := dbhelper.SetRestaurantTags(ctx, tx, id, slugs)
+		if err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, restaurantsCacheKey); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "restaurant.tag", "restaurant", id.String(),
+			map[string][]string{"tags": before}, map[string][]string{"tags": slugs})
+	})
+	if !taggingDone(w, err, "restaurant not found") {
+		return
+	}
+	cache.Catalog.Forget(r.Context(), restaurantsCacheKey)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"restaurant_id": id,
+		"tags":          slugs,
+	})
+}
+
+// SetMenuItemTags replaces the tags of a menu item. Subadmins can only tag
+// items they created or that belong to a restaurant they created.
+func SetMenuItemTags(w http.ResponseWriter, r *http.Request) {
+	claims, id, slugs, ok := parseTagging(w, r)
+	if !ok {
+		return
+	}
+
+	var restaurantID uuid.UUID
+	err := database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if restaurantID, err = authorizeMenuItem(ctx, tx, claims, id); err != nil {
+			return err
+		}
+		before, err := dbhelper.SetMenuItemTags(ctx, tx, id, slugs)
+		if err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, menuCacheKey(restaurantID)); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "menu.tag", "menu", id.String(),
+			map[string][]string{"tags": before}, map[string][]string{"tags": slugs})
+	})
+	if !taggingDone(w, err, "menu item not found") {
+		return
+	}
+	cache.Catalog.Forget(r.Context(), menuCacheKey(restaurantID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"menu_item_id": id,
+		"tags":         slugs,
+	})
+}
+
+// SetMenuItemAllergens replaces the allergens declared on a menu item.
+func SetMenuItemAllergens(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Allergens []models.Allergen `json:"allergens"`
+	}
+
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid menu item ID", http.StatusBadRequest)
+		return
+	}
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Allergens == nil {
+		http.Error(w, "invalid request, expected an allergens list", http.StatusBadRequest)
+		return
+	}
+	allergens, err := parseAllergens(req.Allergens)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var restaurantID uuid.UUID
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if restaurantID, err = authorizeMenuItem(ctx, tx, claims, id); err != nil {
+			return err
+		}
+		before, err := dbhelper.SetMenuItemAllergens(ctx, tx, id, allergens)
+		if err != nil {
+			return err
+		}
+		if err := dbhelper.NotifyCacheInvalidation(ctx, tx, menuCacheKey(restaurantID)); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "menu.allergens", "menu", id.String(),
+			map[string][]models.Allergen{"allergens": before}, map[string][]models.Allergen{"allergens": allergens})
+	})
+	if !taggingDone(w, err, "menu item not found") {
+		return
+	}
+	cache.Catalog.Forget(r.Context(), menuCacheKey(restaurantID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"menu_item_id": id,
+		"allergens":    allergens,
+	})
+}
+
+func validTag(w http.ResponseWriter, name string, kind models.TagKind) bool {
+	if name == "" || len(name) > 100 {
+		http.Error(w, "name is required and at most 100 characters", http.StatusBadRequest)
+		return false
+	}
+	if !kind.IsValid() {
+		http.Error(w, "kind must be cuisine, dietary or label", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// parseTagging reads the caller, the {id} path variable and a {"tags": [...]}
+// body, writing the error response when any is invalid.
+func parseTagging(w http.ResponseWriter, r *http.Request) (*middlewares.Claims, uuid.UUID, []string, bool) {
+	type request struct {
+		Tags []string `json:"tags"`
+	}
+
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, uuid.Nil, nil, false
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid ID", http.StatusBadRequest)
+		return nil, uuid.Nil, nil, false
+	}
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Tags == nil {
+		http.Error(w, "invalid request, expected a tags list", http.StatusBadRequest)
+		return nil, uuid.Nil, nil, false
+	}
+	return claims, id, normalizeSlugs(req.Tags), true
+}
+
+// taggingDone writes the error response for err, if any.
+func taggingDone(w http.ResponseWriter, err error, notFound string) bool {
+	var unknown *dbhelper.UnknownTagsError
+	switch {
+	case err == nil:
+		return true
+	case err == sql.ErrNoRows:
+		http.Error(w, notFound, http.StatusNotFound)
+	case err == errNotCreator:
+		http.Error(w, "forbidden", http.StatusForbidden)
+	case errors.As(err, &unknown):
+		http.Error(w, unknown.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "failed to update tags", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// authorizeMenuItem returns the restaurant of a menu item the caller may
+// change, or errNotCreator.
+func authorizeMenuItem(ctx context.Context, exec dbhelper.SQLExecutor, claims *middlewares.Claims, menuID uuid.UUID) (uuid.UUID, error) {
+	restaurantID, creator, err := dbhelper.MenuItemCreator(ctx, exec, menuID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if canManage(claims, creator) {
+		return restaurantID, nil
+	}
+	restaurantCreator, err := dbhelper.RestaurantCreator(ctx, exec, restaurantID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !canManage(claims, restaurantCreator) {
+		return uuid.Nil, errNotCreator
+	}
+	return restaurantID, nil
+}
+
+func canManage(claims *middlewares.Claims, creatorID uuid.UUID) bool {
+	return slices.Contains(claims.Roles, string(models.RoleAdmin)) || (creatorID != uuid.Nil && creatorID == claims.UserID)
+}
+
+// normalizeSlugs lowercases, sorts and deduplicates slugs.
+func normalizeSlugs(slugs []string) []string {
+	result := make([]string, 0, len(slugs))
+	for _, slug := range slugs {
+		if slug = strings.ToLower(strings.TrimSpace(slug)); slug != "" {
+			result = append(result, slug)
+		}
+	}
+	slices.Sort(result)
+	return slices.Compact(result)
+}
+
+// parseAllergens validates allergens and puts them in canonical order.
+func parseAllergens(allergens []models.Allergen) ([]models.Allergen, error) {
+	result := make([]models.Allergen, 0, len(allergens))
+	for _, a := range allergens {
+		a = models.Allergen(strings.ToLower(strings.TrimSpace(string(a))))
+		if !a.IsValid() {
+			return nil, errors.New("unknown allergen " + string(a))
+		}
+		result = append(result, a)
+	}
+	slices.Sort(result)
+	return slices.Compact(result), nil
+}
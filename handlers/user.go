@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"time"
 	"database/sql"
 	"encoding/json"
@@ -8,10 +9,10 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/sirupsen/logrus"
 	"github.com/ray-remotestate/restro/config"
 	"github.com/ray-remotestate/restro/database"
 	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/metrics"
 	"github.com/ray-remotestate/restro/middlewares"
 	"github.com/ray-remotestate/restro/models"
 	"github.com/ray-remotestate/restro/utils"
@@ -37,9 +38,10 @@ func Register(w http.ResponseWriter, r *http.Request) {
 
 	if len(req.Password) < 6 {
 		http.Error(w, "password must be at least 6 characters", http.StatusBadRequest)
+		return
 	}
 
-	exists, err := dbhelper.IsUserExists(req.Email)
+	exists, err := dbhelper.IsUserExists(r.Context(), req.Email)
 	if err != nil {
 		http.Error(w, "failed to check user existence", http.StatusInternalServerError)
 		return
@@ -57,31 +59,43 @@ func Register(w http.ResponseWriter, r *http.Request) {
 
 	var userID uuid.UUID
 	var accToken, refToken string
-	txErr := database.Tx(func(tx *sql.Tx) error { // using *sql.Tx instead *sql.DB as we want both the operation to either commit together or fail together.
-		userID, err = dbhelper.CreateUser(tx, req.Name, req.Email, hashedPassword)
+	txErr := database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error { // using *sql.Tx instead *sql.DB as we want both the operation to either commit together or fail together.
+		userID, err = dbhelper.CreateUser(ctx, tx, req.Name, req.Email, hashedPassword)
+		if err != nil {
+			middlewares.Logger(ctx).WithError(err).Error("failed to create user")
+			return err
+		}
+
+		err = dbhelper.AssignRole(ctx, tx, userID, models.RoleUser)
 		if err != nil {
-			logrus.Printf("failed to create user, error: %v", err)
+			middlewares.Logger(ctx).WithError(err).Error("failed to assign role to the user")
 			return err
 		}
 
-		err = dbhelper.AssignRole(tx, userID, models.RoleUser)
+		sessionID := uuid.New()
+		accToken, refToken, err = utils.GenerateTokens(userID, sessionID, []string{string(models.RoleUser)})
 		if err != nil {
-			logrus.Printf("failed to assign role to the user, error: %v", err)
+			middlewares.Logger(ctx).WithError(err).Error("failed to generate token")
 			return err
 		}
 
-		accToken, refToken, err = utils.GenerateTokens(userID, []string{string(models.RoleUser)})
+		err = dbhelper.CreateSession(ctx, tx, sessionID, userID, utils.HashToken(refToken), r.UserAgent(), middlewares.ClientIP(r), time.Now().Add(utils.RefreshTokenTTL))
 		if err != nil {
-			logrus.Printf("failed to generate token, error: %v", err)
+			middlewares.Logger(ctx).WithError(err).Error("failed to create session")
 			return err
 		}
 
-		return nil
+		return recordAudit(ctx, tx, r, userID, "user.register", "user", userID.String(), nil, map[string]interface{}{
+			"name":  req.Name,
+			"email": req.Email,
+			"roles": []string{string(models.RoleUser)},
+		})
 	})
 	if txErr != nil {
 		http.Error(w, "failed to register user", http.StatusInternalServerError)
 		return
 	}
+	metrics.Registrations.Inc()
 
 	resp := map[string]interface{}{
 		"user_id": userID,
@@ -94,15 +108,77 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// ReissueRegistration answers a retried registration. Its stored response
+// has no tokens, so it signs the new user in again, provided the password
+// in the retried request still works, and answers as Register did.
+func ReissueRegistration(w http.ResponseWriter, r *http.Request, stored []byte) {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	var resp map[string]interface{}
+	var registered struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+	if err := json.Unmarshal(stored, &resp); err != nil || json.Unmarshal(stored, &registered) != nil {
+		http.Error(w, "failed to replay registration", http.StatusInternalServerError)
+		return
+	}
+
+	userID, _, err := dbhelper.GetUserByPassword(r.Context(), req.Email, req.Password)
+	if err == sql.ErrNoRows || err == dbhelper.ErrIncorrectPassword || (err == nil && userID != registered.UserID) {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	roles, err := fetchRoles(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "could not fetch roles", http.StatusInternalServerError)
+		return
+	}
+	if len(roles) == 0 {
+		http.Error(w, "no roles assigned", http.StatusForbidden)
+		return
+	}
+
+	sessionID := uuid.New()
+	accToken, refToken, err := utils.GenerateTokens(userID, sessionID, roles)
+	if err != nil {
+		http.Error(w, "failed to generate tokens", http.StatusInternalServerError)
+		return
+	}
+	err = dbhelper.CreateSession(r.Context(), database.Restro, sessionID, userID, utils.HashToken(refToken), r.UserAgent(), middlewares.ClientIP(r), time.Now().Add(utils.RefreshTokenTTL))
+	if err != nil {
+		middlewares.Logger(r.Context()).WithError(err).Error("failed to create session")
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	resp["access_token"] = accToken
+	resp["refersh_token"] = refToken
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
 func RefershToken(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("refresh_token")
+	outcome := metrics.OutcomeFailure
+	defer func() { metrics.TokenRefreshes.WithLabelValues(outcome).Inc() }()
+
+	cookie, err := r.Cookie(middlewares.RefreshCookie)
 	if err != nil {
 		http.Error(w, "Refresh token missing", http.StatusUnauthorized)
 		return
 	}
 	refreshToken := cookie.Value
 
-	claims := &middlewares.Claims{}
+	claims := &jwt.RegisteredClaims{}
 	token, err := jwt.ParseWithClaims(refreshToken, claims, func(t *jwt.Token) (interface{}, error) {
 		return []byte(config.SecretKey), nil
 	})
@@ -111,25 +187,65 @@ func RefershToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newAccessToken, newRefreshToken, err := utils.GenerateTokens(claims.UserID, claims.Roles)
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+	sessionID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := dbhelper.GetActiveSession(r.Context(), sessionID)
+	if err == sql.ErrNoRows || (err == nil && session.UserID != userID) {
+		http.Error(w, "Session expired or revoked", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	roles, err := fetchRoles(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "could not fetch roles", http.StatusInternalServerError)
+		return
+	}
+
+	newAccessToken, newRefreshToken, err := utils.GenerateTokens(userID, sessionID, roles)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    newRefreshToken,
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-		Expires:  time.Now().Add(7 * 24 * time.Hour),
-	})
+	rotated, err := dbhelper.RotateSession(r.Context(), sessionID, utils.HashToken(refreshToken), utils.HashToken(newRefreshToken),
+		r.UserAgent(), middlewares.ClientIP(r), time.Now().Add(utils.RefreshTokenTTL))
+	if err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+	if !rotated {
+		// the refresh token was already used once; treat the session as stolen
+		if _, err := dbhelper.RevokeSession(r.Context(), database.Restro, userID, sessionID); err != nil {
+			middlewares.Logger(r.Context()).WithError(err).Error("failed to revoke reused session")
+		}
+		middlewares.ForgetSessions(sessionID)
+		http.Error(w, "Refresh token already used", http.StatusUnauthorized)
+		return
+	}
+
+	csrfToken, err := setAuthCookies(w, newRefreshToken)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
 
 	resp := map[string]string{
 		"access_token": newAccessToken,
+		"csrf_token":   csrfToken,
 	}
+	outcome = metrics.OutcomeSuccess
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
@@ -140,6 +256,9 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 
+	outcome := metrics.OutcomeFailure
+	defer func() { metrics.Logins.WithLabelValues(outcome).Inc() }()
+
 	var req request
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid request", http.StatusBadRequest)
@@ -151,8 +270,8 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, name, err := dbhelper.GetUserByPassword(req.Email, req.Password)
-	if err == sql.ErrNoRows {
+	userID, name, err := dbhelper.GetUserByPassword(r.Context(), req.Email, req.Password)
+	if err == sql.ErrNoRows || err == dbhelper.ErrIncorrectPassword {
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	} else if err != nil {
@@ -160,64 +279,63 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := dbhelper.GetUserRoleByUserID(userID)
+	roles, err := fetchRoles(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "could not fetch roles", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var roles []string
-	for rows.Next() {
-		var role string
-		if err := rows.Scan(&role); err == nil {
-			roles = append(roles, role)
-		}
-	}
 	if len(roles) == 0 {
 		http.Error(w, "no roles assigned", http.StatusForbidden)
 		return
 	}
 
-	accessToken, refreshToken, err := utils.GenerateTokens(userID, roles)
+	sessionID := uuid.New()
+	accessToken, refreshToken, err := utils.GenerateTokens(userID, sessionID, roles)
 	if err != nil {
 		http.Error(w, "failed to generate tokens", http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshToken,
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-		Expires:  time.Now().Add(7 * 24 * time.Hour),
-	})
+	err = dbhelper.CreateSession(r.Context(), database.Restro, sessionID, userID, utils.HashToken(refreshToken), r.UserAgent(), middlewares.ClientIP(r), time.Now().Add(utils.RefreshTokenTTL))
+	if err != nil {
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	csrfToken, err := setAuthCookies(w, refreshToken)
+	if err != nil {
+		http.Error(w, "failed to generate tokens", http.StatusInternalServerError)
+		return
+	}
 
 	resp := map[string]interface{}{
 		"user_id":      userID,
 		"name":         name,
 		"email":        req.Email,
 		"access_token": accessToken,
+		"csrf_token":   csrfToken,
 		"roles":        roles,
 		"message":		"Successfully logged in",
 	}
+	outcome = metrics.OutcomeSuccess
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
 func Logout(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    "",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-		Expires:  time.Unix(0, 0), // Expire immediately
-		MaxAge:   -1,
-	})
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := dbhelper.RevokeSession(r.Context(), database.Restro, claims.UserID, claims.SessionID); err != nil {
+		http.Error(w, "failed to end session", http.StatusInternalServerError)
+		return
+	}
+	middlewares.ForgetSessions(claims.SessionID)
+
+	clearAuthCookies(w)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -242,7 +360,7 @@ func CreateSubAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := dbhelper.GetUserByEmail(req.Email)
+	userID, err := dbhelper.GetUserByEmail(r.Context(), req.Email)
 	if err != nil && err != sql.ErrNoRows {
 		http.Error(w, "server error", http.StatusInternalServerError)
 		return
@@ -267,7 +385,7 @@ func CreateSubAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	isSubAdmin, err := dbhelper.IsSubAdmin(userID)
+	isSubAdmin, err := dbhelper.IsSubAdmin(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "role check failed", http.StatusInternalServerError)
 		return
@@ -277,7 +395,14 @@ func CreateSubAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = dbhelper.MakeSubAdmin(userID)
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		if err := dbhelper.MakeSubAdmin(ctx, tx, userID); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "role.grant", "user", userID.String(), nil, map[string]string{
+			"role": string(models.RoleSubAdmin),
+		})
+	})
 	if err != nil {
 		http.Error(w, "failed to assign subadmin role", http.StatusInternalServerError)
 		return
@@ -297,7 +422,7 @@ func ListSubAdmins(w http.ResponseWriter, r *http.Request) {
 		Email string    `json:"email"`
 	}
 
-	rows, err := dbhelper.ListAllSubadmins()
+	rows, err := dbhelper.ListAllSubadmins(r.Context())
 	if err != nil {
 		http.Error(w, "Failed to query subadmins", http.StatusInternalServerError)
 		return
@@ -354,13 +479,13 @@ func ListAllUsersBySubAdmin(w http.ResponseWriter, r *http.Request) {
 	var err error
 
 	if isAdmin {
-		rows, err = database.Restro.Query(`
+		rows, err = database.Restro.QueryContext(r.Context(), `
 			SELECT id, name, email
 			FROM users
 			WHERE archived_at IS NULL
 		`)
 	} else {
-		rows, err = database.Restro.Query(`
+		rows, err = database.Restro.QueryContext(r.Context(), `
 			SELECT id, name, email
 			FROM users
 			WHERE created_by = $1 AND archived_at IS NULL
@@ -393,11 +518,12 @@ func ListAllUsersBySubAdmin(w http.ResponseWriter, r *http.Request) {
 }
 
 func AddAddress(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value("user_id").(uuid.UUID)
-	if !ok {
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
+	userID := claims.UserID
 
 	type Input struct {
 		Address   string  `json:"address"`
@@ -417,11 +543,17 @@ func AddAddress(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var addressID uuid.UUID
-	err := database.Restro.QueryRow(`
-		INSERT INTO addresses (user_id, address, latitude, longitude)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id
-	`, userID, input.Address, input.Latitude, input.Longitude).Scan(&addressID)
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO addresses (user_id, address, latitude, longitude)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id
+		`, userID, input.Address, input.Latitude, input.Longitude).Scan(&addressID)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "address.create", "address", addressID.String(), nil, input)
+	})
 	if err != nil {
 		http.Error(w, "failed to add address", http.StatusInternalServerError)
 		return
@@ -432,4 +564,82 @@ func AddAddress(w http.ResponseWriter, r *http.Request) {
 		"message":    "Address added successfully",
 		"address_id": addressID.String(),
 	})
-}
\ No newline at end of file
+}
+
+func fetchRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	rows, err := dbhelper.GetUserRoleByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	claims, err := middlewares.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if len(req.NewPassword) < 6 {
+		http.Error(w, "password must be at least 6 characters", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := dbhelper.CheckPassword(r.Context(), claims.UserID, req.CurrentPassword)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "incorrect password", http.StatusUnauthorized)
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		http.Error(w, "failed to hash password", http.StatusInternalServerError)
+		return
+	}
+	// other devices have to log in again with the new password
+	var revoked []uuid.UUID
+	err = database.Tx(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		if err := dbhelper.UpdatePassword(ctx, tx, claims.UserID, hashedPassword); err != nil {
+			return err
+		}
+		if revoked, err = dbhelper.RevokeSessionsExcept(ctx, tx, claims.UserID, claims.SessionID); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, r, uuid.Nil, "user.password_change", "user", claims.UserID.String(), nil, nil)
+	})
+	if err != nil {
+		http.Error(w, "failed to update password", http.StatusInternalServerError)
+		return
+	}
+	middlewares.ForgetSessions(revoked...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Password updated",
+	})
+}
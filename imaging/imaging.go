@@ -0,0 +1,127 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // registers the WebP decoder
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported image type, expected JPEG, PNG, GIF or WebP")
+	ErrTooLarge    = errors.New("image dimensions too large")
+)
+
+const (
+	maxSide   = 8000
+	maxPixels = 40_000_000
+)
+
+// Variant is a size images are rendered in, fitting within MaxSide pixels on
+// both sides. Smaller images are not enlarged.
+type Variant struct {
+	Name    string
+	MaxSide int
+}
+
+var Variants = []Variant{
+	{Name: "thumb", MaxSide: 160},
+	{Name: "medium", MaxSide: 640},
+	{Name: "large", MaxSide: 1600},
+}
+
+type Rendition struct {
+	Variant string
+	Data    []byte
+	Width   int
+	Height  int
+}
+
+// Result holds the renditions of an upload, all encoded as Format.
+type Result struct {
+	// Format is "jpeg" for opaque images and "png" for ones with
+	// transparency.
+	Format      string
+	ContentType string
+	Width       int
+	Height      int
+	Renditions  []Rendition
+}
+
+// Process checks that data is an image of an accepted type and size and
+// renders it in every Variant. Re-encoding also drops metadata such as the
+// location a photo was taken at.
+func Process(data []byte) (Result, error) {
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+	default:
+		return Result{}, ErrUnsupported
+	}
+
+	// the header is enough to refuse decompression bombs before decoding
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Result{}, ErrUnsupported
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxSide || cfg.Height > maxSide || cfg.Width*cfg.Height > maxPixels {
+		return Result{}, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Result{}, ErrUnsupported
+	}
+
+	result := Result{Format: "jpeg", ContentType: "image/jpeg", Width: cfg.Width, Height: cfg.Height}
+	if !opaque(src) {
+		result.Format, result.ContentType = "png", "image/png"
+	}
+
+	for _, v := range Variants {
+		dst := resize(src, v.MaxSide)
+		var buf bytes.Buffer
+		if result.Format == "png" {
+			err = png.Encode(&buf, dst)
+		} else {
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		result.Renditions = append(result.Renditions, Rendition{
+			Variant: v.Name,
+			Data:    buf.Bytes(),
+			Width:   dst.Bounds().Dx(),
+			Height:  dst.Bounds().Dy(),
+		})
+	}
+	return result, nil
+}
+
+func resize(src image.Image, maxSide int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > maxSide || h > maxSide {
+		if w >= h {
+			w, h = maxSide, max(1, h*maxSide/w)
+		} else {
+			w, h = max(1, w*maxSide/h), maxSide
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
+
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
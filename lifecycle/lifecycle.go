@@ -0,0 +1,143 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Component is a part of the process with a start and stop step. Either
+// function may be nil.
+type Component struct {
+	Name  string
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+}
+
+// StopError reports a component that failed to stop or missed the deadline.
+type StopError struct {
+	Component string
+	TimedOut  bool
+	Err       error
+}
+
+func (e *StopError) Error() string {
+	if e.TimedOut {
+		return fmt.Sprintf("%s: did not stop in time", e.Component)
+	}
+	return fmt.Sprintf("%s: %v", e.Component, e.Err)
+}
+
+func (e *StopError) Unwrap() error {
+	return e.Err
+}
+
+// Manager starts components in the order they were added and stops the
+// started ones in reverse order. The root context it hands to components
+// stays alive until every component has stopped, so in-flight work is not
+// cancelled while the components it depends on are still shutting down.
+type Manager struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	components []Component
+	started    []Component
+}
+
+func New(parent context.Context) *Manager {
+	ctx, cancel := context.WithCancel(parent)
+	return &Manager{ctx: ctx, cancel: cancel}
+}
+
+func (m *Manager) Add(c Component) {
+	m.components = append(m.components, c)
+}
+
+// Start starts every component in order and returns at the first failure.
+// Call Stop afterwards either way to stop the components already started.
+func (m *Manager) Start() error {
+	for _, c := range m.components {
+		logrus.WithField("component", c.Name).Info("starting")
+		if c.Start != nil {
+			if err := c.Start(m.ctx); err != nil {
+				return fmt.Errorf("%s: failed to start: %w", c.Name, err)
+			}
+		}
+		m.started = append(m.started, c)
+	}
+	return nil
+}
+
+// Stop stops the started components in reverse order under one shared
+// deadline and then cancels the root context. A component that misses the
+// deadline is reported and not waited for.
+func (m *Manager) Stop(timeout time.Duration) error {
+	defer m.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+	for i := len(m.started) - 1; i >= 0; i-- {
+		c := m.started[i]
+		if c.Stop == nil {
+			continue
+		}
+		logrus.WithField("component", c.Name).Info("stopping")
+
+		done := make(chan error, 1)
+		go func() { done <- c.Stop(ctx) }()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				errs = append(errs, &StopError{Component: c.Name, Err: err, TimedOut: errors.Is(err, context.DeadlineExceeded)})
+			}
+		case <-ctx.Done():
+			errs = append(errs, &StopError{Component: c.Name, Err: ctx.Err(), TimedOut: true})
+		}
+	}
+	m.started = nil
+
+	return errors.Join(errs...)
+}
+
+// Worker runs fn every interval until the worker is stopped.
+func Worker(name string, interval time.Duration, fn func(ctx context.Context) error) Component {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+
+	return Component{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			ctx, cancel = context.WithCancel(ctx)
+			go func() {
+				defer close(done)
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						if err := fn(ctx); err != nil && ctx.Err() == nil {
+							logrus.WithError(err).WithField("worker", name).Error("worker run failed")
+						}
+					}
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "restro"
+
+// HTTP metrics are labelled with the mux route template, never the raw path,
+// to keep label cardinality bounded.
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, route template and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+var (
+	Logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "logins_total",
+		Help:      "Login attempts by outcome.",
+	}, []string{"outcome"})
+
+	TokenRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "token_refreshes_total",
+		Help:      "Refresh token exchanges by outcome.",
+	}, []string{"outcome"})
+
+	AuthRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "rejections_total",
+		Help:      "Requests rejected with 401 or 403 by the auth middlewares, by reason.",
+	}, []string{"status", "reason"})
+)
+
+var CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "cache",
+	Name:      "requests_total",
+	Help:      "Cache lookups by cache and result (hit or miss).",
+}, []string{"cache", "result"})
+
+var DeprecatedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "deprecated_requests_total",
+	Help:      "Requests to deprecated legacy routes by method and route template.",
+}, []string{"method", "route"})
+
+var RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "rate_limited_total",
+	Help:      "Requests rejected with 429 by rate limit policy.",
+}, []string{"policy"})
+
+var (
+	Registrations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "Users registered through /register.",
+	})
+
+	RestaurantsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "restaurants_created_total",
+		Help:      "Restaurants created.",
+	})
+
+	MenuItemsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "menu_items_created_total",
+		Help:      "Menu items created.",
+	})
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// RegisterDBStats exports the connection pool stats of db. Call it once the
+// database is connected.
+func RegisterDBStats(db *sql.DB) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(db, namespace))
+}
@@ -3,6 +3,7 @@ package middlewares
 import (
 	"context"
 	"errors"
+	"net"
 	"net/http"
 	"strings"
 
@@ -13,11 +14,18 @@ import (
 )
 
 type Claims struct {
-	UserID uuid.UUID
+	UserID    uuid.UUID
+	SessionID uuid.UUID
 	Roles	[]string
+	// ActorID is set only on impersonation tokens and holds the admin acting as UserID.
+	ActorID   uuid.UUID
 	jwt.RegisteredClaims
 }
 
+func (c *Claims) IsImpersonated() bool {
+	return c.ActorID != uuid.Nil
+}
+
 type ContextKey string
 
 const (
@@ -28,7 +36,7 @@ func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenStr, err := extractBearerToken(r)
 		if err != nil {
-			http.Error(w, "unauthorized: missing token", http.StatusUnauthorized)
+			rejectAuth(w, http.StatusUnauthorized, "missing_token", "unauthorized: missing token")
 			return
 		}
 
@@ -37,11 +45,22 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return []byte(config.SecretKey), nil
 		})
 		if err != nil || !token.Valid{
-			http.Error(w, "unauthorized: invalid token", http.StatusUnauthorized)
+			rejectAuth(w, http.StatusUnauthorized, "invalid_token", "unauthorized: invalid token")
+			return
+		}
+
+		active, err := isSessionActive(r.Context(), claims.SessionID)
+		if err != nil {
+			http.Error(w, "failed to verify session", http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			rejectAuth(w, http.StatusUnauthorized, "session_revoked", "unauthorized: session revoked")
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userContextKey, &claims)
+		setRequestUser(r.Context(), claims.UserID)
+		ctx := context.WithValue(r.Context(), userContextKey, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -66,6 +85,46 @@ func extractBearerToken(r *http.Request) (string, error) {
 	return parts[1], nil
 }
 
+// ClientIP returns the address of the caller. X-Forwarded-For is believed
+// only as far back as config.TrustedProxies added to it: the client is the
+// right-most hop that is not a trusted proxy, as anything before it could
+// have been sent by the client itself.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// not written by a proxy of ours; the last one we trust is all we know
+			return host
+		}
+		if !trustedProxy(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+func trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range config.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func RoleBasedMiddleware(allowedRoles ...models.Role) func(http.Handler) http.Handler {
 	allowed := make(map[models.Role]bool)
 	for _, role := range allowedRoles {
@@ -76,7 +135,7 @@ func RoleBasedMiddleware(allowedRoles ...models.Role) func(http.Handler) http.Ha
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			claims, err := GetAuthenticatedUser(r)
 			if err != nil {
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				rejectAuth(w, http.StatusUnauthorized, "no_claims", "unauthorized")
 				return
 			}
 
@@ -88,7 +147,7 @@ func RoleBasedMiddleware(allowedRoles ...models.Role) func(http.Handler) http.Ha
 				}
 			}
 
-			http.Error(w, "forbidden: insufficient role", http.StatusForbidden)
+			rejectAuth(w, http.StatusForbidden, "insufficient_role", "forbidden: insufficient role")
 		})
 	}
 }
@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ray-remotestate/restro/config"
+)
+
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	config.TrustedProxies = []*net.IPNet{proxies}
+	defer func() { config.TrustedProxies = nil }()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"no header", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:4000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted peer", "10.0.0.2:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed first hop", "10.0.0.2:4000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", "10.0.0.2:4000", []string{"198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"split headers", "10.0.0.2:4000", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"garbage hop", "10.0.0.2:4000", []string{"198.51.100.1, junk"}, "10.0.0.2"},
+		{"only proxies", "10.0.0.2:4000", []string{"10.0.0.3"}, "10.0.0.3"},
+		{"ipv6 peer", "[2001:db8::1]:4000", []string{"198.51.100.1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, v := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := ClientIP(r); got != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package middlewares
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ray-remotestate/restro/config"
+)
+
+var (
+	corsAllowedMethods = strings.Join([]string{
+		http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+	}, ", ")
+	corsAllowedHeaders = strings.Join([]string{
+		"Authorization", "Content-Type", CSRFHeader, requestIDHeader, "X-API-Key", IdempotencyHeader,
+		"If-Match", "If-None-Match",
+	}, ", ")
+	corsExposedHeaders = strings.Join([]string{
+		requestIDHeader, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After",
+		"Deprecation", "Sunset", "Link", "Idempotent-Replayed", "ETag",
+	}, ", ")
+)
+
+// CORS answers preflight requests and adds the CORS response headers for the
+// origins in config.CORS. It has to wrap the router rather than be installed
+// with Router.Use, since preflight requests match no route.
+func CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !originAllowed(origin) {
+			// the browser blocks the response without the headers below
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if slices.Contains(config.CORS.AllowedOrigins, "*") {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if config.CORS.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			if config.CORS.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.CORS.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func originAllowed(origin string) bool {
+	for _, allowed := range config.CORS.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/ray-remotestate/restro/config"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+)
+
+const (
+	IdempotencyHeader = "Idempotency-Key"
+
+	maxIdempotentBody = 1 << 20
+	// idempotencyLockTimeout outlasts the server's write timeout, so a key
+	// still held after it belongs to a request that died.
+	idempotencyLockTimeout = 6 * time.Minute
+)
+
+// Idempotent runs a request carrying an Idempotency-Key at most once per
+// caller and key, replaying the stored response on retries. Callers are
+// users when authenticated and client IPs otherwise. A retry with a
+// different request, or while the first one is still running, gets 409.
+// Requests that fail with a 5xx release the key so they can be retried.
+// For authenticated routes it must run after AuthMiddleware.
+func Idempotent(next http.Handler) http.Handler {
+	return idempotent(next, nil, nil)
+}
+
+// IdempotentCredentials is Idempotent for routes whose responses carry
+// credentials, which are never stored: the named top-level JSON fields are
+// left out of every stored response, and a retry of a successful request is
+// answered by reissue, given what was stored, so it can mint fresh ones.
+func IdempotentCredentials(reissue func(w http.ResponseWriter, r *http.Request, stored []byte), fields ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return idempotent(next, reissue, fields)
+	}
+}
+
+func idempotent(next http.Handler, reissue func(http.ResponseWriter, *http.Request, []byte), secret []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) < 16 || len(key) > 255 {
+			http.Error(w, "Idempotency-Key must be 16 to 255 characters", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		caller := "ip:" + ClientIP(r)
+		if claims, err := GetAuthenticatedUser(r); err == nil {
+			caller = "user:" + claims.UserID.String()
+		}
+		sum := sha256.Sum256([]byte(r.Method + " " + unversionedURI(r) + "\n" + string(body)))
+		hash := hex.EncodeToString(sum[:])
+
+		stored, reserved, err := dbhelper.ReserveIdempotencyKey(r.Context(), caller, key, hash,
+			time.Now().Add(config.IdempotencyTTL), idempotencyLockTimeout)
+		if err != nil {
+			Logger(r.Context()).WithError(err).Error("failed to reserve idempotency key")
+			http.Error(w, "failed to check Idempotency-Key", http.StatusInternalServerError)
+			return
+		}
+		if !reserved {
+			switch {
+			case stored.RequestHash != hash:
+				http.Error(w, "Idempotency-Key was already used for a different request", http.StatusConflict)
+			case stored.Status == nil:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "a request with this Idempotency-Key is still in progress", http.StatusConflict)
+			case reissue != nil && *stored.Status < 300:
+				w.Header().Set("Idempotent-Replayed", "true")
+				reissue(w, r, stored.Body)
+			default:
+				if stored.ContentType != "" {
+					w.Header().Set("Content-Type", stored.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(*stored.Status)
+				w.Write(stored.Body)
+			}
+			return
+		}
+
+		rec := &bodyRecorder{statusRecorder: newStatusRecorder(w)}
+		next.ServeHTTP(rec, r)
+
+		// the outcome must be recorded even if the client has gone away
+		ctx := context.WithoutCancel(r.Context())
+		if rec.status >= 500 {
+			err = dbhelper.ReleaseIdempotencyKey(ctx, caller, key)
+		} else {
+			body := rec.body.Bytes()
+			// whatever the status, as a handler may have failed after writing them
+			if len(secret) > 0 {
+				body = withoutFields(body, secret)
+			}
+			err = dbhelper.CompleteIdempotencyKey(ctx, caller, key, rec.status, rec.Header().Get("Content-Type"), body)
+		}
+		if err != nil {
+			Logger(r.Context()).WithError(err).Error("failed to store idempotent response")
+		}
+	})
+}
+
+var versionPrefix = regexp.MustCompile(`^/v[0-9]+/`)
+
+// unversionedURI is the request URI without its API version, so a retry may
+// go to another version, or the legacy path, of the same route.
+func unversionedURI(r *http.Request) string {
+	uri := versionPrefix.ReplaceAllString(r.URL.EscapedPath(), "/")
+	if r.URL.RawQuery != "" {
+		uri += "?" + r.URL.RawQuery
+	}
+	return uri
+}
+
+// bodyRecorder keeps a copy of the response body.
+type bodyRecorder struct {
+	*statusRecorder
+	body bytes.Buffer
+}
+
+func (rec *bodyRecorder) Write(b []byte) (int, error) {
+	n, err := rec.statusRecorder.Write(b)
+	rec.body.Write(b[:n])
+	return n, err
+}
+
+// withoutFields drops top-level fields from a JSON object. Any other body,
+// such as a plain error, is kept only if it does not mention the fields, so
+// nothing secret can slip through.
+func withoutFields(body []byte, fields []string) []byte {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil {
+		for _, field := range fields {
+			if bytes.Contains(body, []byte(field)) {
+				return nil
+			}
+		}
+		return body
+	}
+	for _, field := range fields {
+		delete(object, field)
+	}
+	kept, err := json.Marshal(object)
+	if err != nil {
+		return nil
+	}
+	return kept
+}
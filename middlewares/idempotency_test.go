@@ -0,0 +1,43 @@
+package middlewares
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithoutFields(t *testing.T) {
+	secret := []string{"access_token", "refersh_token"}
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"object", `{"user_id":"u1","access_token":"a","refersh_token":"r"}`, `{"user_id":"u1"}`},
+		{"object without secrets", `{"user_id":"u1"}`, `{"user_id":"u1"}`},
+		{"plain error", "user already exists\n", "user already exists\n"},
+		{"error followed by tokens", "password must be at least 6 characters\n{\"access_token\":\"a\"}\n", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		if got := string(withoutFields([]byte(tt.body), secret)); got != tt.want {
+			t.Errorf("%s: withoutFields = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnversionedURI(t *testing.T) {
+	tests := map[string]string{
+		"/register":           "/register",
+		"/v1/register":        "/register",
+		"/v2/register":        "/register",
+		"/v1/api/address?x=1": "/api/address?x=1",
+		"/api/v1/something":   "/api/v1/something",
+		"/v10/register":       "/register",
+		"/version/register":   "/version/register",
+	}
+	for uri, want := range tests {
+		if got := unversionedURI(httptest.NewRequest("POST", uri, nil)); got != want {
+			t.Errorf("unversionedURI(%s) = %s, want %s", uri, got, want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/ray-remotestate/restro/database/dbhelper"
+	"github.com/ray-remotestate/restro/models"
+)
+
+// ImpersonationAudit records every request made with an impersonation token.
+// It must run after AuthMiddleware.
+func ImpersonationAudit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := GetAuthenticatedUser(r)
+		if err != nil || !claims.IsImpersonated() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		err = dbhelper.LogImpersonation(r.Context(), models.ImpersonationAudit{
+			ActorID:   claims.ActorID,
+			SubjectID: claims.UserID,
+			SessionID: claims.SessionID,
+			Method:    r.Method,
+			Path:      r.URL.RequestURI(),
+			Status:    rec.status,
+			IPAddress: ClientIP(r),
+		})
+		if err != nil {
+			Logger(r.Context()).WithError(err).WithField("actor_id", claims.ActorID).Error("failed to write impersonation audit")
+		}
+	})
+}
+
+// BlockImpersonation rejects sensitive actions such as password changes and
+// role grants when the caller is impersonating another user.
+func BlockImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := GetAuthenticatedUser(r)
+		if err != nil {
+			rejectAuth(w, http.StatusUnauthorized, "no_claims", "unauthorized")
+			return
+		}
+		if claims.IsImpersonated() {
+			rejectAuth(w, http.StatusForbidden, "impersonation", "forbidden: not allowed while impersonating")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
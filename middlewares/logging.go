@@ -0,0 +1,159 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+const requestContextKey ContextKey = "request"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestMeta is shared by the middlewares of one request. The outer
+// middlewares create it; the inner ones fill in what only they know, such as
+// the matched route template and the authenticated user.
+type requestMeta struct {
+	ID     string
+	Route  string
+	UserID uuid.UUID
+	Logger *logrus.Entry
+}
+
+func getRequestMeta(ctx context.Context) *requestMeta {
+	meta, _ := ctx.Value(requestContextKey).(*requestMeta)
+	return meta
+}
+
+// RequestID propagates the caller's X-Request-ID or assigns a new one, and
+// attaches a request-scoped logger to the context.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		meta := &requestMeta{
+			ID:     id,
+			Logger: logrus.WithField("request_id", id),
+		}
+		ctx := context.WithValue(r.Context(), requestContextKey, meta)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RouteTemplate records the mux route template of the matched route. It has
+// to be installed with Router.Use so that the route is known.
+func RouteTemplate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if meta := getRequestMeta(r.Context()); meta != nil {
+			if route := mux.CurrentRoute(r); route != nil {
+				if tmpl, err := route.GetPathTemplate(); err == nil {
+					meta.Route = tmpl
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AccessLog emits one structured line per request once it has been served.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		fields := logrus.Fields{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"route":      RouteLabel(r.Context()),
+			"status":     rec.status,
+			"bytes":      rec.bytes,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"remote_ip":  ClientIP(r),
+		}
+		if meta := getRequestMeta(r.Context()); meta != nil && meta.UserID != uuid.Nil {
+			fields["user_id"] = meta.UserID
+		}
+		Logger(r.Context()).WithFields(fields).Info("request")
+	})
+}
+
+// Recover turns a panicking handler into a 500 response and logs the stack.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := newStatusRecorder(w)
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			Logger(r.Context()).WithFields(logrus.Fields{
+				"panic": fmt.Sprint(p),
+				"stack": string(debug.Stack()),
+			}).Error("handler panicked")
+
+			if !rec.wroteHeader {
+				WriteError(rec, r, http.StatusInternalServerError, "internal server error")
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// WriteError writes a JSON error envelope carrying the request ID.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":      message,
+		"request_id": GetRequestID(r.Context()),
+	})
+}
+
+// Logger returns the request-scoped logger, or the standard logger outside a request.
+func Logger(ctx context.Context) *logrus.Entry {
+	if meta := getRequestMeta(ctx); meta != nil {
+		return meta.Logger
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+func GetRequestID(ctx context.Context) string {
+	if meta := getRequestMeta(ctx); meta != nil {
+		return meta.ID
+	}
+	return ""
+}
+
+// RouteLabel is the matched route template, or "unmatched" for 404/405s.
+func RouteLabel(ctx context.Context) string {
+	if meta := getRequestMeta(ctx); meta != nil && meta.Route != "" {
+		return meta.Route
+	}
+	return "unmatched"
+}
+
+func setRequestUser(ctx context.Context, userID uuid.UUID) {
+	if meta := getRequestMeta(ctx); meta != nil {
+		meta.UserID = userID
+		meta.Logger = meta.Logger.WithField("user_id", userID)
+	}
+}
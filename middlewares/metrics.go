@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ray-remotestate/restro/metrics"
+)
+
+// Metrics counts requests and observes their latency. It must be wrapped by
+// RequestID so the route template recorded by RouteTemplate is visible.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		route := RouteLabel(r.Context())
+		status := strconv.Itoa(rec.status)
+		metrics.HTTPRequests.WithLabelValues(r.Method, route, status).Inc()
+		metrics.HTTPDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+func rejectAuth(w http.ResponseWriter, status int, reason, message string) {
+	metrics.AuthRejections.WithLabelValues(strconv.Itoa(status), reason).Inc()
+	http.Error(w, message, status)
+}
@@ -0,0 +1,64 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ray-remotestate/restro/metrics"
+	"github.com/ray-remotestate/restro/ratelimit"
+)
+
+// RateLimit enforces policy per client using store. When the store fails the
+// request is let through rather than taking the API down with it.
+func RateLimit(store ratelimit.Store, policy ratelimit.Policy) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := store.Take(r.Context(), rateLimitKey(r, policy), policy)
+			if err != nil {
+				Logger(r.Context()).WithError(err).WithField("policy", policy.Name).Warn("rate limiter unavailable")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+			if !res.Allowed {
+				metrics.RateLimited.WithLabelValues(policy.Name).Inc()
+				w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(res.RetryAfter))))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey identifies the client for policy, falling back from API key
+// to user to IP when the preferred identity is not available.
+func rateLimitKey(r *http.Request, policy ratelimit.Policy) string {
+	prefix := "rl:" + policy.Name + ":"
+
+	if policy.Key == ratelimit.KeyAPIKey {
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			sum := sha256.Sum256([]byte(apiKey))
+			return prefix + "key:" + hex.EncodeToString(sum[:])
+		}
+	}
+	if policy.Key == ratelimit.KeyUser || policy.Key == ratelimit.KeyAPIKey {
+		if claims, err := GetAuthenticatedUser(r); err == nil {
+			return prefix + "user:" + claims.UserID.String()
+		}
+	}
+	return prefix + "ip:" + ClientIP(r)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
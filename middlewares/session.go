@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ray-remotestate/restro/database/dbhelper"
+)
+
+// sessionRecheckInterval bounds how long another instance may keep accepting
+// access tokens of a session revoked elsewhere.
+const sessionRecheckInterval = 30 * time.Second
+
+// verifiedSessions maps a session ID to the time until which it is trusted
+// without going back to the database.
+var verifiedSessions sync.Map
+
+func isSessionActive(ctx context.Context, sessionID uuid.UUID) (bool, error) {
+	if sessionID == uuid.Nil {
+		return false, nil
+	}
+
+	if until, ok := verifiedSessions.Load(sessionID); ok && time.Now().Before(until.(time.Time)) {
+		return true, nil
+	}
+
+	active, err := dbhelper.TouchSession(ctx, sessionID)
+	if err != nil {
+		return false, err
+	}
+	if !active {
+		verifiedSessions.Delete(sessionID)
+		return false, nil
+	}
+
+	verifiedSessions.Store(sessionID, time.Now().Add(sessionRecheckInterval))
+	return true, nil
+}
+
+// ForgetSessions drops revoked sessions from the cache so their access tokens
+// are rejected on the next request.
+func ForgetSessions(sessionIDs ...uuid.UUID) {
+	for _, id := range sessionIDs {
+		verifiedSessions.Delete(id)
+	}
+}
@@ -0,0 +1,26 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a server span per request, named after the mux route and
+// continuing any trace from an incoming traceparent header. The trace ID is
+// added to the request-scoped logger. Install it with Router.Use.
+func Tracing(service string) mux.MiddlewareFunc {
+	otelMiddleware := otelmux.Middleware(service)
+	return func(next http.Handler) http.Handler {
+		return otelMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if meta := getRequestMeta(r.Context()); meta != nil {
+				if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+					meta.Logger = meta.Logger.WithField("trace_id", sc.TraceID().String())
+				}
+			}
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
@@ -0,0 +1,74 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ray-remotestate/restro/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const versionContextKey ContextKey = "api_version"
+
+// APIVersion tags requests with the API version of the subrouter they were
+// routed through.
+func APIVersion(version int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), versionContextKey, version)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Version is the API version of the request. Requests that did not go
+// through APIVersion are version 1.
+func Version(ctx context.Context) int {
+	if version, ok := ctx.Value(versionContextKey).(int); ok {
+		return version
+	}
+	return 1
+}
+
+// Versioned picks the response for the request's API version out of shapes,
+// which maps a version to the shape introduced in it. Versions without an
+// entry get the newest shape from before them.
+func Versioned(ctx context.Context, shapes map[int]func() any) any {
+	best := 0
+	for version := range shapes {
+		if version <= Version(ctx) && version > best {
+			best = version
+		}
+	}
+	if best == 0 {
+		return nil
+	}
+	return shapes[best]()
+}
+
+// Deprecated marks legacy routes with the Deprecation (RFC 9745) and Sunset
+// (RFC 8594) headers, links to the route's replacement under successorPrefix
+// and records who still calls them.
+func Deprecated(deprecatedAt, sunset time.Time, successorPrefix string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(deprecatedAt.Unix(), 10))
+			w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			w.Header().Set("Link", "<"+successorPrefix+r.URL.Path+`>; rel="successor-version"`)
+
+			next.ServeHTTP(w, r)
+
+			route := RouteLabel(r.Context())
+			metrics.DeprecatedRequests.WithLabelValues(r.Method, route).Inc()
+			Logger(r.Context()).WithFields(logrus.Fields{
+				"method":     r.Method,
+				"route":      route,
+				"user_agent": r.UserAgent(),
+				"successor":  successorPrefix + route,
+			}).Info("deprecated route called")
+		})
+	}
+}
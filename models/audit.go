@@ -0,0 +1,32 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditLog struct {
+	ID             uuid.UUID       `db:"id" json:"id"`
+	ActorID        uuid.UUID       `db:"actor_id" json:"actor_id"`
+	ImpersonatorID *uuid.UUID      `db:"impersonator_id" json:"impersonator_id,omitempty"`
+	Action         string          `db:"action" json:"action"`
+	ResourceType   string          `db:"resource_type" json:"resource_type"`
+	ResourceID     string          `db:"resource_id" json:"resource_id"`
+	Before         json.RawMessage `db:"before" json:"before,omitempty"`
+	After          json.RawMessage `db:"after" json:"after,omitempty"`
+	Diff           json.RawMessage `db:"diff" json:"diff,omitempty"`
+	IPAddress      string          `db:"ip_address" json:"ip_address"`
+	RequestID      string          `db:"request_id" json:"request_id"`
+	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+}
+
+type AuditFilter struct {
+	ActorID      uuid.UUID
+	ResourceType string
+	ResourceID   string
+	From         time.Time
+	To           time.Time
+	Limit        int
+}
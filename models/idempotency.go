@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// IdempotencyKey is a request a caller asked to run at most once. Status is
+// nil while the first request is still being handled.
+type IdempotencyKey struct {
+	Caller      string    `db:"caller" json:"caller"`
+	Key         string    `db:"key" json:"key"`
+	RequestHash string    `db:"request_hash" json:"request_hash"`
+	Status      *int      `db:"status" json:"status,omitempty"`
+	ContentType string    `db:"content_type" json:"content_type"`
+	Body        []byte    `db:"body" json:"-"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	ExpiresAt   time.Time `db:"expires_at" json:"expires_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImageSlot is where an image is shown: a restaurant's logo or banner, or
+// the photo of a menu item.
+type ImageSlot string
+
+const (
+	ImageSlotLogo   ImageSlot = "logo"
+	ImageSlotBanner ImageSlot = "banner"
+	ImageSlotPhoto  ImageSlot = "photo"
+)
+
+type Image struct {
+	ID           uuid.UUID  `db:"id" json:"id"`
+	RestaurantID *uuid.UUID `db:"restaurant_id" json:"restaurant_id,omitempty"`
+	MenuID       *uuid.UUID `db:"menu_id" json:"menu_id,omitempty"`
+	Slot         ImageSlot  `db:"slot" json:"slot"`
+	Format       string     `db:"format" json:"format"`
+	Width        int        `db:"width" json:"width"`
+	Height       int        `db:"height" json:"height"`
+	CreatedBy    uuid.UUID  `db:"created_by" json:"created_by"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+}
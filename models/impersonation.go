@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ImpersonationAudit struct {
+	ID        uuid.UUID `db:"id" json:"id"`
+	ActorID   uuid.UUID `db:"actor_id" json:"actor_id"`
+	SubjectID uuid.UUID `db:"subject_id" json:"subject_id"`
+	SessionID uuid.UUID `db:"session_id" json:"session_id"`
+	Method    string    `db:"method" json:"method"`
+	Path      string    `db:"path" json:"path"`
+	Status    int       `db:"status" json:"status"`
+	IPAddress string    `db:"ip_address" json:"ip_address"`
+	Reason    string    `db:"reason" json:"reason,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
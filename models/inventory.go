@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Inventory is the stock and availability of a menu item. Stock is nil when
+// it is not tracked; DailyStock, when set, is what Stock goes back to every
+// day. Orderable combines the manual IsAvailable switch, the 86 and the
+// stock.
+type Inventory struct {
+	MenuItemID    uuid.UUID  `db:"id" json:"menu_item_id"`
+	RestaurantID  uuid.UUID  `db:"restaurant_id" json:"restaurant_id"`
+	Name          string     `db:"name" json:"name"`
+	IsAvailable   bool       `db:"is_available" json:"is_available"`
+	Stock         *int       `db:"stock" json:"stock"`
+	DailyStock    *int       `db:"daily_stock" json:"daily_stock"`
+	EightySixedAt *time.Time `db:"eighty_sixed_at" json:"eighty_sixed_at"`
+	Orderable     bool       `db:"orderable" json:"orderable"`
+}
+
+// StockLine is a quantity of a menu item taken by, or given back from, an
+// order.
+type StockLine struct {
+	MenuItemID uuid.UUID `json:"menu_item_id"`
+	Quantity   int       `json:"quantity"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "github.com/google/uuid"
+
+// MenuRow is a menu item as bulk import and export see it, keyed by its SKU
+// within the restaurant. On import, a nil Description, IsAvailable, Tags or
+// Allergens leaves what an existing item has alone.
+type MenuRow struct {
+	ID          uuid.UUID  `json:"-"`
+	SKU         string     `json:"sku"`
+	Name        string     `json:"name"`
+	Description *string    `json:"description"`
+	Price       Money      `json:"price"`
+	IsAvailable *bool      `json:"is_available"`
+	Tags        []string   `json:"tags"`
+	Allergens   []Allergen `json:"allergens"`
+}
+
+// ImportError is what is wrong with a row of an import. Row is the CSV line,
+// the header being line 1, or the position in a JSON array, from 1; Column
+// is empty when the whole row is at fault.
+type ImportError struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportReport says what an import did or, on a dry run or with errors,
+// would have done. Nothing is applied unless Errors is empty.
+type ImportReport struct {
+	DryRun    bool          `json:"dry_run"`
+	Rows      int           `json:"rows"`
+	Created   int           `json:"created"`
+	Updated   int           `json:"updated"`
+	Unchanged int           `json:"unchanged"`
+	Errors    []ImportError `json:"errors"`
+}
@@ -0,0 +1,324 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAmount   = errors.New("invalid amount, expected a non-negative decimal within the currency's precision")
+	ErrInvalidCurrency = errors.New("invalid currency, expected a supported ISO 4217 code")
+	ErrMoneyOverflow   = errors.New("amount out of range")
+)
+
+// Currency is an ISO 4217 currency code, such as "USD".
+type Currency string
+
+// currencyExponents holds the supported currencies and how many decimals
+// their minor unit has.
+var currencyExponents = map[Currency]int{
+	"AED": 2, "AUD": 2, "BDT": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0,
+	"CNY": 2, "CZK": 2, "DKK": 2, "EGP": 2, "EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2,
+	"IDR": 2, "ILS": 2, "INR": 2, "ISK": 0, "JOD": 3, "JPY": 0, "KES": 2, "KRW": 0,
+	"KWD": 3, "LKR": 2, "MXN": 2, "MYR": 2, "NGN": 2, "NOK": 2, "NPR": 2, "NZD": 2,
+	"OMR": 3, "PHP": 2, "PKR": 2, "PLN": 2, "QAR": 2, "SAR": 2, "SEK": 2, "SGD": 2,
+	"THB": 2, "TND": 3, "TRY": 2, "TWD": 2, "USD": 2, "VND": 0, "ZAR": 2,
+}
+
+// ParseCurrency reads a supported currency code, in any case.
+func ParseCurrency(s string) (Currency, error) {
+	c := Currency(strings.ToUpper(strings.TrimSpace(s)))
+	if !c.IsValid() {
+		return "", ErrInvalidCurrency
+	}
+	return c, nil
+}
+
+func (c Currency) IsValid() bool {
+	_, ok := currencyExponents[c]
+	return ok
+}
+
+// Exponent is the number of decimals of the currency's minor unit: 2 for
+// cents, 0 for currencies without one.
+func (c Currency) Exponent() int {
+	return currencyExponents[c]
+}
+
+// CurrencyMismatchError is returned by arithmetic on amounts in different
+// currencies.
+type CurrencyMismatchError struct {
+	Left, Right Currency
+}
+
+func (e *CurrencyMismatchError) Error() string {
+	return fmt.Sprintf("currency mismatch: %s and %s", e.Left, e.Right)
+}
+
+// RoundingMode says which way an amount that falls between two minor units
+// goes.
+type RoundingMode int
+
+const (
+	RoundDown     RoundingMode = iota // towards zero
+	RoundUp                           // away from zero
+	RoundHalfUp                       // to the nearest, halves away from zero
+	RoundHalfEven                     // to the nearest, halves to the even neighbour
+)
+
+// Money is an amount in the minor units of a currency, such as cents.
+//
+// In JSON it is an object holding the amount both as a decimal string and
+// as an integer of minor units:
+//
+//	{"amount": "12.50", "amount_minor": 1250, "currency": "USD"}
+//
+// Either encoding of the amount is accepted on input; the currency is
+// required. Amounts never go through float64.
+//
+// Money scans from a Postgres row of an integer and a currency code, such as
+// (m.price_minor, r.currency).
+type Money struct {
+	Amount   int64
+	Currency Currency
+}
+
+func NewMoney(amount int64, currency Currency) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// ParseMoney reads a non-negative decimal amount with at most as many
+// decimals as the currency has, such as "12", "12.5" or "12.50".
+func ParseMoney(s string, currency Currency) (Money, error) {
+	if !currency.IsValid() {
+		return Money{}, ErrInvalidCurrency
+	}
+	exp := currency.Exponent()
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if whole == "" || len(frac) > exp || (hasFrac && frac == "") || !digits(whole) || !digits(frac) {
+		return Money{}, ErrInvalidAmount
+	}
+	units, err := strconv.ParseInt(whole+frac+strings.Repeat("0", exp-len(frac)), 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+	return Money{Amount: units, Currency: currency}, nil
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) check(o Money) error {
+	if m.Currency != o.Currency {
+		return &CurrencyMismatchError{Left: m.Currency, Right: o.Currency}
+	}
+	return nil
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	if err := m.check(o); err != nil {
+		return Money{}, err
+	}
+	if (o.Amount > 0 && m.Amount > math.MaxInt64-o.Amount) || (o.Amount < 0 && m.Amount < math.MinInt64-o.Amount) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Amount == math.MinInt64 {
+		return Money{}, ErrMoneyOverflow
+	}
+	return m.Add(Money{Amount: -o.Amount, Currency: o.Currency})
+}
+
+// Cmp returns -1, 0 or 1 as m is less than, equal to or more than o.
+func (m Money) Cmp(o Money) (int, error) {
+	if err := m.check(o); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Mul multiplies the amount by a quantity.
+func (m Money) Mul(n int64) (Money, error) {
+	if n != 0 && (m.Amount*n/n != m.Amount || (m.Amount == -1 && n == math.MinInt64) || (m.Amount == math.MinInt64 && n == -1)) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Amount: m.Amount * n, Currency: m.Currency}, nil
+}
+
+// MulDiv scales the amount by num/den, rounding the result to a whole
+// minor unit, such as MulDiv(15, 100, RoundDown) for 15%.
+func (m Money) MulDiv(num, den int64, mode RoundingMode) (Money, error) {
+	if den == 0 {
+		return Money{}, errors.New("division by zero")
+	}
+	p, err := m.Mul(num)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: divRound(p.Amount, den, mode), Currency: m.Currency}, nil
+}
+
+// RoundTo rounds the amount to a multiple of step minor units, such as 5
+// for cash payments in Swiss francs.
+func (m Money) RoundTo(step int64, mode RoundingMode) Money {
+	if step <= 1 {
+		return m
+	}
+	return Money{Amount: divRound(m.Amount, step, mode) * step, Currency: m.Currency}
+}
+
+func divRound(a, b int64, mode RoundingMode) int64 {
+	q, r := a/b, a%b
+	if r == 0 {
+		return q
+	}
+	// away is the direction of the exact quotient from zero
+	away := int64(1)
+	if (a < 0) != (b < 0) {
+		away = -1
+	}
+	absR, absB := r, b
+	if absR < 0 {
+		absR = -absR
+	}
+	if absB < 0 {
+		absB = -absB
+	}
+	switch mode {
+	case RoundUp:
+		return q + away
+	case RoundHalfUp:
+		if absR >= absB-absR {
+			return q + away
+		}
+	case RoundHalfEven:
+		if absR > absB-absR || (absR == absB-absR && q%2 != 0) {
+			return q + away
+		}
+	}
+	return q
+}
+
+// Decimal formats the amount with the currency's decimals, such as "12.50".
+func (m Money) Decimal() string {
+	exp := m.Currency.Exponent()
+	s := strconv.FormatInt(m.Amount, 10)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	if exp == 0 {
+		return sign + s
+	}
+	if len(s) <= exp {
+		s = strings.Repeat("0", exp-len(s)+1) + s
+	}
+	return sign + s[:len(s)-exp] + "." + s[len(s)-exp:]
+}
+
+// String formats the amount with its currency, such as "12.50 USD".
+func (m Money) String() string {
+	return m.Decimal() + " " + string(m.Currency)
+}
+
+type moneyJSON struct {
+	Amount      *json.RawMessage `json:"amount,omitempty"`
+	AmountMinor *int64           `json:"amount_minor,omitempty"`
+	Currency    string           `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount      string   `json:"amount"`
+		AmountMinor int64    `json:"amount_minor"`
+		Currency    Currency `json:"currency"`
+	}{m.Decimal(), m.Amount, m.Currency})
+}
+
+// UnmarshalJSON accepts the amount as a decimal, in a string or a number,
+// as an integer of minor units, or as both when they agree.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var in moneyJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	currency, err := ParseCurrency(in.Currency)
+	if err != nil {
+		return err
+	}
+	if in.Amount == nil && in.AmountMinor == nil {
+		return ErrInvalidAmount
+	}
+	if in.AmountMinor != nil && *in.AmountMinor < 0 {
+		return ErrInvalidAmount
+	}
+	if in.Amount == nil {
+		*m = Money{Amount: *in.AmountMinor, Currency: currency}
+		return nil
+	}
+	parsed, err := ParseMoney(strings.Trim(string(*in.Amount), `"`), currency)
+	if err != nil {
+		return err
+	}
+	// both come back from MarshalJSON, and must say the same
+	if in.AmountMinor != nil && *in.AmountMinor != parsed.Amount {
+		return ErrInvalidAmount
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan reads a row of an amount in minor units and a currency code, which
+// Postgres sends as text such as "(1250,USD)".
+func (m *Money) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	amount, currency, ok := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(s, "("), ")"), ",")
+	if !ok {
+		return fmt.Errorf("cannot scan %q into Money", s)
+	}
+	a, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return fmt.Errorf("cannot scan %q into Money: %w", s, err)
+	}
+	c := Currency(strings.TrimSpace(currency))
+	if !c.IsValid() {
+		return fmt.Errorf("cannot scan %q into Money: %w", s, ErrInvalidCurrency)
+	}
+	*m = Money{Amount: a, Currency: c}
+	return nil
+}
@@ -0,0 +1,230 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency Currency
+		want     int64
+		err      error
+	}{
+		{"12", "USD", 1200, nil},
+		{"12.5", "USD", 1250, nil},
+		{"12.50", "USD", 1250, nil},
+		{"0.01", "USD", 1, nil},
+		{"1200", "JPY", 1200, nil},
+		{"1.234", "KWD", 1234, nil},
+		{"12.505", "USD", 0, ErrInvalidAmount},
+		{"12.", "USD", 0, ErrInvalidAmount},
+		{".5", "USD", 0, ErrInvalidAmount},
+		{"-1", "USD", 0, ErrInvalidAmount},
+		{"+1", "USD", 0, ErrInvalidAmount},
+		{"1e3", "USD", 0, ErrInvalidAmount},
+		{"1.5", "JPY", 0, ErrInvalidAmount},
+		{"92233720368547758.08", "USD", 0, ErrInvalidAmount},
+		{"1", "XXX", 0, ErrInvalidCurrency},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in, tt.currency)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseMoney(%q, %s) error = %v, want %v", tt.in, tt.currency, err, tt.err)
+			continue
+		}
+		if err == nil && (got.Amount != tt.want || got.Currency != tt.currency) {
+			t.Errorf("ParseMoney(%q, %s) = %v, want %d", tt.in, tt.currency, got, tt.want)
+		}
+	}
+}
+
+// errAny stands for any error in tests that only check that one occurs.
+var errAny = errors.New("any error")
+
+func TestMoneyArithmetic(t *testing.T) {
+	usd := func(a int64) Money { return NewMoney(a, "USD") }
+	tests := []struct {
+		name string
+		op   func() (Money, error)
+		want Money
+		err  error
+	}{
+		{"add", func() (Money, error) { return usd(150).Add(usd(250)) }, usd(400), nil},
+		{"add overflow", func() (Money, error) { return usd(math.MaxInt64).Add(usd(1)) }, Money{}, ErrMoneyOverflow},
+		{"add underflow", func() (Money, error) { return usd(math.MinInt64).Add(usd(-1)) }, Money{}, ErrMoneyOverflow},
+		{"sub", func() (Money, error) { return usd(150).Sub(usd(250)) }, usd(-100), nil},
+		{"sub min", func() (Money, error) { return usd(0).Sub(usd(math.MinInt64)) }, Money{}, ErrMoneyOverflow},
+		{"mul", func() (Money, error) { return usd(125).Mul(3) }, usd(375), nil},
+		{"mul overflow", func() (Money, error) { return usd(math.MaxInt64/2 + 1).Mul(2) }, Money{}, ErrMoneyOverflow},
+		{"mul min by -1", func() (Money, error) { return usd(math.MinInt64).Mul(-1) }, Money{}, ErrMoneyOverflow},
+		{"muldiv down", func() (Money, error) { return usd(999).MulDiv(15, 100, RoundDown) }, usd(149), nil},
+		{"muldiv up", func() (Money, error) { return usd(999).MulDiv(15, 100, RoundUp) }, usd(150), nil},
+		{"muldiv half up", func() (Money, error) { return usd(10).MulDiv(1, 4, RoundHalfUp) }, usd(3), nil},
+		{"muldiv half even", func() (Money, error) { return usd(10).MulDiv(1, 4, RoundHalfEven) }, usd(2), nil},
+		{"muldiv negative half up", func() (Money, error) { return usd(-10).MulDiv(1, 4, RoundHalfUp) }, usd(-3), nil},
+		{"muldiv by zero", func() (Money, error) { return usd(10).MulDiv(1, 0, RoundDown) }, Money{}, errAny},
+		{"muldiv overflow", func() (Money, error) { return usd(math.MaxInt64).MulDiv(2, 2, RoundDown) }, Money{}, ErrMoneyOverflow},
+	}
+	for _, tt := range tests {
+		got, err := tt.op()
+		switch {
+		case tt.err == nil && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.err != nil && err == nil:
+			t.Errorf("%s: got %v, want an error", tt.name, got)
+		case tt.err != nil && tt.err != errAny && !errors.Is(err, tt.err):
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.err)
+		case tt.err == nil && got != tt.want:
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyCurrencyMismatch(t *testing.T) {
+	usd, eur := NewMoney(100, "USD"), NewMoney(100, "EUR")
+	ops := map[string]func() error{
+		"add": func() error { _, err := usd.Add(eur); return err },
+		"sub": func() error { _, err := usd.Sub(eur); return err },
+		"cmp": func() error { _, err := usd.Cmp(eur); return err },
+	}
+	for name, op := range ops {
+		var mismatch *CurrencyMismatchError
+		if err := op(); !errors.As(err, &mismatch) || mismatch.Left != "USD" || mismatch.Right != "EUR" {
+			t.Errorf("%s: error = %v, want a USD/EUR mismatch", name, err)
+		}
+	}
+}
+
+func TestDivRound(t *testing.T) {
+	tests := []struct {
+		a, b int64
+		mode RoundingMode
+		want int64
+	}{
+		{7, 2, RoundDown, 3},
+		{-7, 2, RoundDown, -3},
+		{7, 2, RoundUp, 4},
+		{-7, 2, RoundUp, -4},
+		{7, -2, RoundUp, -4},
+		{5, 2, RoundHalfUp, 3},
+		{-5, 2, RoundHalfUp, -3},
+		{5, 2, RoundHalfEven, 2},
+		{7, 2, RoundHalfEven, 4},
+		{-5, 2, RoundHalfEven, -2},
+		{4, 3, RoundHalfUp, 1},
+		{5, 3, RoundHalfUp, 2},
+		{6, 3, RoundUp, 2},
+	}
+	for _, tt := range tests {
+		if got := divRound(tt.a, tt.b, tt.mode); got != tt.want {
+			t.Errorf("divRound(%d, %d, %d) = %d, want %d", tt.a, tt.b, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyRoundTo(t *testing.T) {
+	chf := NewMoney(1233, "CHF")
+	if got := chf.RoundTo(5, RoundHalfUp); got.Amount != 1235 {
+		t.Errorf("RoundTo(5) = %v, want 12.35 CHF", got)
+	}
+	if got := chf.RoundTo(1, RoundUp); got != chf {
+		t.Errorf("RoundTo(1) = %v, want it unchanged", got)
+	}
+}
+
+func TestMoneyDecimal(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{NewMoney(1250, "USD"), "12.50"},
+		{NewMoney(5, "USD"), "0.05"},
+		{NewMoney(0, "USD"), "0.00"},
+		{NewMoney(-5, "USD"), "-0.05"},
+		{NewMoney(-1250, "USD"), "-12.50"},
+		{NewMoney(1200, "JPY"), "1200"},
+		{NewMoney(1234, "KWD"), "1.234"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Decimal(); got != tt.want {
+			t.Errorf("%d %s: Decimal() = %q, want %q", tt.m.Amount, tt.m.Currency, got, tt.want)
+		}
+	}
+	if got := NewMoney(1250, "USD").String(); got != "12.50 USD" {
+		t.Errorf("String() = %q, want 12.50 USD", got)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		src     any
+		want    Money
+		wantErr bool
+	}{
+		{"(1250,USD)", NewMoney(1250, "USD"), false},
+		{[]byte("(0,JPY)"), NewMoney(0, "JPY"), false},
+		{"(1250,XXX)", Money{}, true},
+		{"(abc,USD)", Money{}, true},
+		{"1250", Money{}, true},
+		{int64(1250), Money{}, true},
+	}
+	for _, tt := range tests {
+		var got Money
+		err := got.Scan(tt.src)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Scan(%v) error = %v, want error %v", tt.src, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("Scan(%v) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		err  error
+	}{
+		{`{"amount":"12.50","currency":"USD"}`, NewMoney(1250, "USD"), nil},
+		{`{"amount":12.5,"currency":"usd"}`, NewMoney(1250, "USD"), nil},
+		{`{"amount_minor":1250,"currency":"USD"}`, NewMoney(1250, "USD"), nil},
+		{`{"amount":"12.50","amount_minor":1250,"currency":"USD"}`, NewMoney(1250, "USD"), nil},
+		{`{"amount":"1.00","amount_minor":-500,"currency":"USD"}`, Money{}, ErrInvalidAmount},
+		{`{"amount":"1.00","amount_minor":99,"currency":"USD"}`, Money{}, ErrInvalidAmount},
+		{`{"amount_minor":-1,"currency":"USD"}`, Money{}, ErrInvalidAmount},
+		{`{"amount":"-1","currency":"USD"}`, Money{}, ErrInvalidAmount},
+		{`{"amount":"1.005","currency":"USD"}`, Money{}, ErrInvalidAmount},
+		{`{"currency":"USD"}`, Money{}, ErrInvalidAmount},
+		{`{"amount":"1.00"}`, Money{}, ErrInvalidCurrency},
+		{`{"amount":"1.00","currency":"XXX"}`, Money{}, ErrInvalidCurrency},
+	}
+	for _, tt := range tests {
+		var got Money
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Unmarshal(%s) error = %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	out, err := json.Marshal(NewMoney(1250, "USD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"amount":"12.50","amount_minor":1250,"currency":"USD"}` {
+		t.Errorf("Marshal = %s", out)
+	}
+	var back Money
+	if err := json.Unmarshal(out, &back); err != nil || back != NewMoney(1250, "USD") {
+		t.Errorf("round trip = %v, %v", back, err)
+	}
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PriceChange is an entry of a menu item's price history. Changes with an
+// EffectiveAt in the future are scheduled; AppliedAt is set once the price
+// is on the menu. CancelledAt is set on changes that were cancelled, or
+// overtaken by a later change before they could be applied.
+type PriceChange struct {
+	ID          uuid.UUID  `db:"id" json:"id"`
+	MenuItemID  uuid.UUID  `db:"menu_id" json:"menu_item_id"`
+	Price       Money      `db:"price_minor" json:"price"`
+	EffectiveAt time.Time  `db:"effective_at" json:"effective_at"`
+	AppliedAt   *time.Time `db:"applied_at" json:"applied_at"`
+	CancelledAt *time.Time `db:"cancelled_at" json:"cancelled_at,omitempty"`
+	CreatedBy   *uuid.UUID `db:"created_by" json:"created_by"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+}
+
+// PriceRule discounts a restaurant's menu, or one item of it, during a
+// daily window, such as a happy hour. Days are ISO weekdays (1 is Monday)
+// and StartsAt and EndsAt are "HH:MM" local times; a window that ends
+// before it starts runs past midnight. Exactly one of PercentOff and
+// AmountOff is set; AmountOff is in the restaurant's currency.
+type PriceRule struct {
+	ID           uuid.UUID  `db:"id" json:"id"`
+	RestaurantID uuid.UUID  `db:"restaurant_id" json:"restaurant_id"`
+	MenuItemID   *uuid.UUID `db:"menu_id" json:"menu_item_id"`
+	Name         string     `db:"name" json:"name"`
+	PercentOff   *int       `db:"percent_off" json:"percent_off,omitempty"`
+	AmountOff    *Money     `db:"amount_off_minor" json:"amount_off,omitempty"`
+	Days         []int      `db:"days" json:"days"`
+	StartsAt     string     `db:"starts_at" json:"starts_at"`
+	EndsAt       string     `db:"ends_at" json:"ends_at"`
+	ValidFrom    *time.Time `db:"valid_from" json:"valid_from"`
+	ValidUntil   *time.Time `db:"valid_until" json:"valid_until"`
+	CreatedBy    *uuid.UUID `db:"created_by" json:"created_by"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+}
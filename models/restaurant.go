@@ -13,6 +13,7 @@ type Restaurant struct {
 	Description string    `db:"description" json:"description"`
 	Latitude	float64	  `db:"latitude" json:"latitude"`
 	Longitude	float64	  `db:"longitude" json:"longitude"`
+	Currency    Currency  `db:"currency" json:"currency"`
 	CreatedAt   time.Time `db:"created_at" json:"created_at"`
 }
 
@@ -21,7 +22,7 @@ type Menu struct {
 	RestaurantID uuid.UUID `db:"restaurant_id" json:"restaurant_id"`
 	Name         string    `db:"name" json:"name"`
 	Description  string    `db:"description" json:"description"`
-	Price        float64   `db:"price" json:"price"`
+	Price        Money     `db:"price_minor" json:"price"`
 	IsAvailable  bool      `db:"is_available" json:"is_available"`
 	CreatedAt    time.Time `db:"created_at" json:"created_at"`
 	CreatedBy	 uuid.UUID `db:"created_by" json:"created_by"`
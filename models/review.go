@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReviewStatus is where a review stands in moderation. Flagged reviews stay
+// public until a moderator decides; hidden ones are left out of listings and
+// ratings.
+type ReviewStatus string
+
+const (
+	ReviewVisible ReviewStatus = "visible"
+	ReviewFlagged ReviewStatus = "flagged"
+	ReviewHidden  ReviewStatus = "hidden"
+)
+
+func (s ReviewStatus) IsValid() bool {
+	return s == ReviewVisible || s == ReviewFlagged || s == ReviewHidden
+}
+
+// DishRating is the optional rating of one menu item inside a review.
+type DishRating struct {
+	MenuItemID uuid.UUID `db:"menu_id" json:"menu_item_id"`
+	Rating     int       `db:"rating" json:"rating"`
+}
+
+type Review struct {
+	ID               uuid.UUID    `db:"id" json:"id"`
+	RestaurantID     uuid.UUID    `db:"restaurant_id" json:"restaurant_id"`
+	UserID           uuid.UUID    `db:"user_id" json:"user_id"`
+	Author           string       `db:"author" json:"author"`
+	Rating           int          `db:"rating" json:"rating"`
+	Body             string       `db:"body" json:"body"`
+	Dishes           []DishRating `db:"-" json:"dishes"`
+	Status           ReviewStatus `db:"status" json:"status"`
+	ModerationReason *string      `db:"moderation_reason" json:"moderation_reason,omitempty"`
+	ModeratedBy      *uuid.UUID   `db:"moderated_by" json:"moderated_by,omitempty"`
+	ModeratedAt      *time.Time   `db:"moderated_at" json:"moderated_at,omitempty"`
+	Reply            *string      `db:"reply" json:"reply"`
+	RepliedBy        *uuid.UUID   `db:"replied_by" json:"replied_by,omitempty"`
+	RepliedAt        *time.Time   `db:"replied_at" json:"replied_at,omitempty"`
+	CreatedAt        time.Time    `db:"created_at" json:"created_at"`
+	UpdatedAt        time.Time    `db:"updated_at" json:"updated_at"`
+}
+
+// ReviewFilter selects reviews. Statuses defaults to everything but hidden.
+type ReviewFilter struct {
+	RestaurantID uuid.UUID
+	Statuses     []ReviewStatus
+	Limit        int
+	Offset       int
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NamedMenu is a part of a restaurant's menu served at set times, such as
+// breakfast. What it holds and when it is served are versioned: edits go to
+// a draft, which is served once published, and any earlier published
+// version can be put back. A nil PublishedVersion or DraftVersion means the
+// menu has none.
+type NamedMenu struct {
+	ID               uuid.UUID  `db:"id" json:"id"`
+	RestaurantID     uuid.UUID  `db:"restaurant_id" json:"restaurant_id"`
+	Name             string     `db:"name" json:"name"`
+	PublishedVersion *int       `json:"published_version"`
+	DraftVersion     *int       `json:"draft_version"`
+	CreatedBy        *uuid.UUID `db:"created_by" json:"created_by"`
+	CreatedAt        time.Time  `db:"created_at" json:"created_at"`
+}
+
+// MenuWindow is when a menu is served. Days are ISO weekdays (1 is Monday)
+// and StartsAt and EndsAt are "HH:MM" local times; a window that ends
+// before it starts runs past midnight.
+type MenuWindow struct {
+	Days     []int  `db:"days" json:"days"`
+	StartsAt string `db:"starts_at" json:"starts_at"`
+	EndsAt   string `db:"ends_at" json:"ends_at"`
+}
+
+// MenuVersion is what a menu held, in order, and when it was served. A
+// version without windows is served all day; a nil PublishedAt marks the
+// draft.
+type MenuVersion struct {
+	ID          uuid.UUID    `db:"id" json:"id"`
+	MenuID      uuid.UUID    `db:"menu_id" json:"menu_id"`
+	Number      int          `db:"number" json:"number"`
+	Items       []uuid.UUID  `json:"menu_item_ids"`
+	Windows     []MenuWindow `json:"windows"`
+	Current     bool         `json:"current"` // the version being served
+	CreatedBy   *uuid.UUID   `db:"created_by" json:"created_by"`
+	CreatedAt   time.Time    `db:"created_at" json:"created_at"`
+	PublishedBy *uuid.UUID   `db:"published_by" json:"published_by"`
+	PublishedAt *time.Time   `db:"published_at" json:"published_at"`
+}
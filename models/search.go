@@ -0,0 +1,27 @@
+package models
+
+import "github.com/google/uuid"
+
+// RestaurantMatch is a restaurant found by search. Rank is in [0, 1) and
+// Snippet is HTML with the matched terms wrapped in <mark>.
+type RestaurantMatch struct {
+	ID          uuid.UUID `db:"id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	Latitude    *float64  `db:"latitude" json:"latitude"`
+	Longitude   *float64  `db:"longitude" json:"longitude"`
+	Rank        float64   `db:"rank" json:"-"`
+	Snippet     string    `db:"snippet" json:"-"`
+}
+
+// DishMatch is a menu item found by search, with the restaurant serving it.
+type DishMatch struct {
+	ID          uuid.UUID       `db:"id" json:"id"`
+	Name        string          `db:"name" json:"name"`
+	Description string          `db:"description" json:"description"`
+	Price       Money           `db:"price_minor" json:"price"`
+	IsAvailable bool            `db:"is_available" json:"is_available"`
+	Rank        float64         `db:"rank" json:"-"`
+	Snippet     string          `db:"snippet" json:"-"`
+	Restaurant  RestaurantMatch `db:"-" json:"-"`
+}
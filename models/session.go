@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Session struct {
+	ID               uuid.UUID  `db:"id" json:"id"`
+	UserID           uuid.UUID  `db:"user_id" json:"user_id"`
+	RefreshTokenHash string     `db:"refresh_token_hash" json:"-"`
+	UserAgent        string     `db:"user_agent" json:"user_agent"`
+	IPAddress        string     `db:"ip_address" json:"ip_address"`
+	CreatedAt        time.Time  `db:"created_at" json:"created_at"`
+	LastSeenAt       time.Time  `db:"last_seen_at" json:"last_seen_at"`
+	ExpiresAt        time.Time  `db:"expires_at" json:"expires_at"`
+	RevokedAt        *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	Current          bool       `db:"-" json:"current"`
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type TagKind string
+
+const (
+	TagKindCuisine TagKind = "cuisine"
+	TagKindDietary TagKind = "dietary"
+	TagKindLabel   TagKind = "label"
+)
+
+func (k TagKind) IsValid() bool {
+	return k == TagKindCuisine || k == TagKindDietary || k == TagKindLabel
+}
+
+// Tag is an entry of the admin-managed taxonomy that restaurants and menu
+// items are tagged with, such as "thai", "vegan" or "spicy".
+type Tag struct {
+	ID         uuid.UUID  `db:"id" json:"id"`
+	Slug       string     `db:"slug" json:"slug"`
+	Name       string     `db:"name" json:"name"`
+	Kind       TagKind    `db:"kind" json:"kind"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	ArchivedAt *time.Time `db:"archived_at" json:"archived_at,omitempty"`
+}
+
+// Allergen is one of the 14 allergens that have to be declared on dishes.
+type Allergen string
+
+const (
+	AllergenCelery      Allergen = "celery"
+	AllergenCrustaceans Allergen = "crustaceans"
+	AllergenEggs        Allergen = "eggs"
+	AllergenFish        Allergen = "fish"
+	AllergenGluten      Allergen = "gluten"
+	AllergenLupin       Allergen = "lupin"
+	AllergenMilk        Allergen = "milk"
+	AllergenMolluscs    Allergen = "molluscs"
+	AllergenMustard     Allergen = "mustard"
+	AllergenNuts        Allergen = "nuts"
+	AllergenPeanuts     Allergen = "peanuts"
+	AllergenSesame      Allergen = "sesame"
+	AllergenSoya        Allergen = "soya"
+	AllergenSulphites   Allergen = "sulphites"
+)
+
+var Allergens = []Allergen{
+	AllergenCelery, AllergenCrustaceans, AllergenEggs, AllergenFish, AllergenGluten, AllergenLupin, AllergenMilk,
+	AllergenMolluscs, AllergenMustard, AllergenNuts, AllergenPeanuts, AllergenSesame, AllergenSoya, AllergenSulphites,
+}
+
+func (a Allergen) IsValid() bool {
+	for _, allergen := range Allergens {
+		if a == allergen {
+			return true
+		}
+	}
+	return false
+}
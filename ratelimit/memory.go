@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// idleBucketTTL is how long an untouched bucket is kept before being swept.
+const idleBucketTTL = time.Hour
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryStore keeps buckets in process memory. Limits are per instance.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, p Policy) (Result, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > idleBucketTTL {
+		for k, b := range s.buckets {
+			if now.Sub(b.updated) > idleBucketTTL {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(p.Burst), updated: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(p.Burst), b.tokens+now.Sub(b.updated).Seconds()*p.Rate)
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return result(p, b.tokens, allowed), nil
+}
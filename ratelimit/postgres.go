@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore keeps buckets in the rate_limit_buckets table so that all
+// instances share the same limits. Each Take is a single atomic upsert.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, p Policy) (Result, error) {
+	var tokens float64
+	var allowed bool
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+		VALUES ($1, $2 - 1, TRUE, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = CASE
+				WHEN LEAST($2, b.tokens + GREATEST(0, EXTRACT(EPOCH FROM NOW() - b.updated_at)) * $3) >= 1
+				THEN LEAST($2, b.tokens + GREATEST(0, EXTRACT(EPOCH FROM NOW() - b.updated_at)) * $3) - 1
+				ELSE LEAST($2, b.tokens + GREATEST(0, EXTRACT(EPOCH FROM NOW() - b.updated_at)) * $3)
+			END,
+			allowed = LEAST($2, b.tokens + GREATEST(0, EXTRACT(EPOCH FROM NOW() - b.updated_at)) * $3) >= 1,
+			updated_at = NOW()
+		RETURNING tokens, allowed`, key, float64(p.Burst), p.Rate).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+	return result(p, tokens, allowed), nil
+}
+
+// DeleteIdle removes buckets untouched since before cutoff; such buckets are
+// full anyway.
+func (s *PostgresStore) DeleteIdle(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM rate_limit_buckets WHERE updated_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
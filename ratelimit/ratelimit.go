@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyKind selects what a policy counts requests against.
+type KeyKind string
+
+const (
+	KeyIP     KeyKind = "ip"
+	KeyUser   KeyKind = "user"
+	KeyAPIKey KeyKind = "apikey"
+)
+
+// Policy is a token bucket refilled at Rate tokens per second holding at
+// most Burst tokens.
+type Policy struct {
+	Name  string
+	Rate  float64
+	Burst int
+	Key   KeyKind
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is the wait until the next token when the request was denied.
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+}
+
+type Store interface {
+	// Take removes one token from the bucket identified by key.
+	Take(ctx context.Context, key string, p Policy) (Result, error)
+}
+
+// ParsePolicy parses "<count>/<s|m|h>[,burst=<n>][,key=ip|user|apikey]",
+// for example "300/m,burst=60,key=user". Burst defaults to count and key to ip.
+func ParsePolicy(name, spec string) (Policy, error) {
+	p := Policy{Name: name, Key: KeyIP}
+	parts := strings.Split(spec, ",")
+
+	count, unit, ok := strings.Cut(strings.TrimSpace(parts[0]), "/")
+	if !ok {
+		return p, fmt.Errorf("rate limit %s: expected <count>/<unit>, got %q", name, parts[0])
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return p, fmt.Errorf("rate limit %s: invalid count %q", name, count)
+	}
+	var per time.Duration
+	switch unit {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return p, fmt.Errorf("rate limit %s: invalid unit %q", name, unit)
+	}
+	p.Rate = float64(n) / per.Seconds()
+	p.Burst = n
+
+	for _, opt := range parts[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		switch key {
+		case "burst":
+			if p.Burst, err = strconv.Atoi(val); err != nil || p.Burst <= 0 {
+				return p, fmt.Errorf("rate limit %s: invalid burst %q", name, val)
+			}
+		case "key":
+			switch k := KeyKind(val); k {
+			case KeyIP, KeyUser, KeyAPIKey:
+				p.Key = k
+			default:
+				return p, fmt.Errorf("rate limit %s: invalid key %q", name, val)
+			}
+		default:
+			return p, fmt.Errorf("rate limit %s: unknown option %q", name, key)
+		}
+	}
+	return p, nil
+}
+
+// result converts the bucket state after a Take into a Result.
+func result(p Policy, tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     p.Burst,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     secondsToDuration((float64(p.Burst) - tokens) / p.Rate),
+	}
+	if !allowed {
+		res.RetryAfter = secondsToDuration((1 - tokens) / p.Rate)
+	}
+	return res
+}
+
+func secondsToDuration(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
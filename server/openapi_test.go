@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSpecCoversRoutes(t *testing.T) {
+	svr := SetupRoutes()
+
+	registered := map[string]bool{}
+	err := walkRoutes(svr.Router, func(method, path string) {
+		key, _ := operationKey(method, path)
+		registered[key] = true
+		if _, ok := operations[key]; !ok {
+			t.Errorf("route %s %s is not documented in operations", method, path)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key := range operations {
+		if !registered[key] {
+			t.Errorf("operation %s documents a route that is not registered", key)
+		}
+	}
+}
+
+func TestSpecIsValidJSON(t *testing.T) {
+	body, err := buildSpec(SetupRoutes().Router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var spec struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(body, &spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.OpenAPI == "" || len(spec.Paths) == 0 {
+		t.Fatalf("spec is missing its version or paths")
+	}
+}
+
+func TestDocsServeFromSelf(t *testing.T) {
+	svr := SetupRoutes()
+
+	rec := httptest.NewRecorder()
+	svr.handler.ServeHTTP(rec, httptest.NewRequest("GET", "/docs", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /docs = %d", rec.Code)
+	}
+	csp := rec.Header().Get("Content-Security-Policy")
+	if strings.Contains(csp, "http") || !strings.Contains(csp, "script-src 'self'") {
+		t.Errorf("Content-Security-Policy = %q, want scripts from 'self' only", csp)
+	}
+	if strings.Contains(rec.Body.String(), "//") {
+		t.Error("the docs page references another origin")
+	}
+
+	for name, contentType := range map[string]string{
+		"swagger-ui-bundle.js": "text/javascript",
+		"swagger-ui.css":       "text/css",
+		"docs.js":              "text/javascript",
+	} {
+		rec := httptest.NewRecorder()
+		svr.handler.ServeHTTP(rec, httptest.NewRequest("GET", "/docs/"+name, nil))
+		if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+			t.Errorf("GET /docs/%s = %d with %d bytes", name, rec.Code, rec.Body.Len())
+		}
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, contentType) {
+			t.Errorf("GET /docs/%s Content-Type = %q, want %s", name, got, contentType)
+		}
+
+		req := httptest.NewRequest("GET", "/docs/"+name, nil)
+		req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+		rec = httptest.NewRecorder()
+		svr.handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("GET /docs/%s with its ETag = %d, want 304", name, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	svr.handler.ServeHTTP(rec, httptest.NewRequest("GET", "/docs/docs.html", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /docs/docs.html = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,955 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ray-remotestate/restro/models"
+)
+
+// The handlers decode into local anonymous structs; these mirror them for the
+// spec. TestOperationsMirrorHandlers fails when their fields drift apart.
+type (
+	registerRequest struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	registerResponse struct {
+		UserID       uuid.UUID `json:"user_id"`
+		Email        string    `json:"email"`
+		Name         string    `json:"name"`
+		AccessToken  string    `json:"access_token"`
+		RefreshToken string    `json:"refersh_token"`
+	}
+	loginRequest struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	loginResponse struct {
+		UserID      uuid.UUID `json:"user_id"`
+		Name        string    `json:"name"`
+		Email       string    `json:"email"`
+		AccessToken string    `json:"access_token"`
+		CSRFToken   string    `json:"csrf_token"`
+		Roles       []string  `json:"roles"`
+		Message     string    `json:"message"`
+	}
+	refreshResponse struct {
+		AccessToken string `json:"access_token"`
+		CSRFToken   string `json:"csrf_token"`
+	}
+	messageResponse struct {
+		Message string `json:"message"`
+	}
+	revokedResponse struct {
+		Message string `json:"message"`
+		Revoked int    `json:"revoked"`
+	}
+	addAddressRequest struct {
+		Address   string  `json:"address"`
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	}
+	addAddressResponse struct {
+		Message   string    `json:"message"`
+		AddressID uuid.UUID `json:"address_id"`
+	}
+	changePasswordRequest struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	restaurantSummary struct {
+		ID          uuid.UUID                      `json:"id"`
+		Name        string                         `json:"name"`
+		Description string                         `json:"description"`
+		Latitude    float64                        `json:"latitude"`
+		Longitude   float64                        `json:"longitude"`
+		Currency    models.Currency                `json:"currency"`
+		Tags        []string                       `json:"tags"`
+		Images      map[models.ImageSlot]imageURLs `json:"images"`
+		Rating      rating                         `json:"rating"`
+		CreatedAt   time.Time                      `json:"created_at"`
+	}
+	rating struct {
+		Average float64 `json:"average"`
+		Count   int     `json:"count"`
+	}
+	imageURLs struct {
+		Thumb  string `json:"thumb"`
+		Medium string `json:"medium"`
+		Large  string `json:"large"`
+	}
+	imageResponse struct {
+		ID     uuid.UUID        `json:"id"`
+		Slot   models.ImageSlot `json:"slot"`
+		Width  int              `json:"width"`
+		Height int              `json:"height"`
+		URLs   imageURLs        `json:"urls"`
+	}
+	searchDish struct {
+		ID          uuid.UUID    `json:"id"`
+		Name        string       `json:"name"`
+		Description string       `json:"description"`
+		Price       models.Money `json:"price"`
+		IsAvailable bool         `json:"is_available"`
+		Score       float64      `json:"score"`
+		Snippet     string       `json:"snippet"`
+	}
+	searchResult struct {
+		Restaurant models.RestaurantMatch `json:"restaurant"`
+		Score      float64                `json:"score"`
+		DistanceKm *float64               `json:"distance_km,omitempty"`
+		Snippet    string                 `json:"snippet,omitempty"`
+		Dishes     []searchDish           `json:"dishes"`
+	}
+	searchResponse struct {
+		Query   string         `json:"query"`
+		Results []searchResult `json:"results"`
+	}
+	dish struct {
+		ID          uuid.UUID         `json:"id"`
+		Name        string            `json:"name"`
+		Description string            `json:"description"`
+		Price       models.Money      `json:"price"`
+		IsAvailable bool              `json:"is_available"`
+		Tags        []string          `json:"tags"`
+		Allergens   []models.Allergen `json:"allergens"`
+		Image       *imageURLs        `json:"image"`
+		Rating      rating            `json:"rating"`
+		CreatedAt   time.Time         `json:"created_at"`
+	}
+	effectivePrice struct {
+		MenuItemID uuid.UUID         `json:"menu_item_id"`
+		At         time.Time         `json:"at"`
+		BasePrice  models.Money      `json:"base_price"`
+		Price      models.Money      `json:"price"`
+		Rule       *models.PriceRule `json:"rule"`
+	}
+	updatePriceRequest struct {
+		Price       models.Money `json:"price"`
+		EffectiveAt *time.Time   `json:"effective_at"`
+	}
+	createPriceRuleRequest struct {
+		MenuItemID *uuid.UUID    `json:"menu_item_id"`
+		Name       string        `json:"name"`
+		PercentOff *int          `json:"percent_off,omitempty"`
+		AmountOff  *models.Money `json:"amount_off,omitempty"`
+		Days       []int         `json:"days"`
+		StartsAt   string        `json:"starts_at"`
+		EndsAt     string        `json:"ends_at"`
+		ValidFrom  *time.Time    `json:"valid_from"`
+		ValidUntil *time.Time    `json:"valid_until"`
+	}
+	createNamedMenuRequest struct {
+		Name string `json:"name"`
+	}
+	saveMenuDraftRequest struct {
+		Items   []uuid.UUID         `json:"menu_item_ids"`
+		Windows []models.MenuWindow `json:"windows"`
+	}
+	rollbackMenuRequest struct {
+		Version int `json:"version"`
+	}
+	setStockRequest struct {
+		Stock      *int `json:"stock"`
+		DailyStock *int `json:"daily_stock"`
+	}
+	setAvailabilityRequest struct {
+		Available bool `json:"available"`
+	}
+	saveReviewRequest struct {
+		Rating int                 `json:"rating"`
+		Body   string              `json:"body"`
+		Dishes []models.DishRating `json:"dishes"`
+	}
+	replyRequest struct {
+		Reply string `json:"reply"`
+	}
+	moderateReviewRequest struct {
+		Status models.ReviewStatus `json:"status"`
+		Reason string              `json:"reason"`
+	}
+	tagFacet struct {
+		Slug  string         `json:"slug"`
+		Name  string         `json:"name"`
+		Kind  models.TagKind `json:"kind"`
+		Count int            `json:"count"`
+	}
+	allergenFacet struct {
+		Allergen models.Allergen `json:"allergen"`
+		Count    int             `json:"count"`
+	}
+	restaurantFacets struct {
+		Total int        `json:"total"`
+		Tags  []tagFacet `json:"tags"`
+	}
+	dishFacets struct {
+		Total     int             `json:"total"`
+		Tags      []tagFacet      `json:"tags"`
+		Allergens []allergenFacet `json:"allergens"`
+	}
+	createTagRequest struct {
+		Slug string         `json:"slug"`
+		Name string         `json:"name"`
+		Kind models.TagKind `json:"kind"`
+	}
+	updateTagRequest struct {
+		Name string         `json:"name"`
+		Kind models.TagKind `json:"kind"`
+	}
+	setTagsRequest struct {
+		Tags []string `json:"tags"`
+	}
+	restaurantTagsResponse struct {
+		RestaurantID uuid.UUID `json:"restaurant_id"`
+		Tags         []string  `json:"tags"`
+	}
+	menuItemTagsResponse struct {
+		MenuItemID uuid.UUID `json:"menu_item_id"`
+		Tags       []string  `json:"tags"`
+	}
+	setAllergensRequest struct {
+		Allergens []models.Allergen `json:"allergens"`
+	}
+	menuItemAllergensResponse struct {
+		MenuItemID uuid.UUID         `json:"menu_item_id"`
+		Allergens  []models.Allergen `json:"allergens"`
+	}
+	userSummary struct {
+		ID    uuid.UUID `json:"id"`
+		Name  string    `json:"name"`
+		Email string    `json:"email"`
+	}
+	createSubAdminRequest struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	userCreatedResponse struct {
+		Message string    `json:"message"`
+		UserID  uuid.UUID `json:"user_id"`
+	}
+	impersonateRequest struct {
+		Reason string `json:"reason"`
+	}
+	impersonateResponse struct {
+		AccessToken   string    `json:"access_token"`
+		ExpiresAt     time.Time `json:"expires_at"`
+		Impersonating uuid.UUID `json:"impersonating"`
+		ActorID       uuid.UUID `json:"actor_id"`
+		Roles         []string  `json:"roles"`
+	}
+	createUserInput struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	createRestaurantInput struct {
+		Name        string  `json:"name"`
+		Description string  `json:"description"`
+		Latitude    float64 `json:"latitude"`
+		Longitude   float64 `json:"longitude"`
+		Currency    string  `json:"currency,omitempty"`
+	}
+	createMenuItemInput struct {
+		RestaurantID uuid.UUID    `json:"restaurant_id"`
+		SKU          string       `json:"sku,omitempty"`
+		Name         string       `json:"name"`
+		Description  string       `json:"description"`
+		Price        models.Money `json:"price"`
+	}
+	restaurantCreatedResponse struct {
+		Message      string    `json:"message"`
+		RestaurantID uuid.UUID `json:"restaurant_id"`
+	}
+	menuItemCreatedResponse struct {
+		Message    string    `json:"message"`
+		MenuItemID uuid.UUID `json:"menu_item_id"`
+	}
+	ownedRestaurant struct {
+		ID          uuid.UUID `json:"id"`
+		Name        string    `json:"name"`
+		Description string    `json:"description"`
+	}
+	ownedMenuItem struct {
+		ID           uuid.UUID    `json:"id"`
+		RestaurantID uuid.UUID    `json:"restaurant_id"`
+		SKU          string       `json:"sku"`
+		Name         string       `json:"name"`
+		Description  string       `json:"description"`
+		Price        models.Money `json:"price"`
+	}
+	statusResponse struct {
+		Status string `json:"status"`
+	}
+	readinessCheck struct {
+		Status    string  `json:"status"`
+		LatencyMS float64 `json:"latency_ms"`
+		Error     string  `json:"error,omitempty"`
+	}
+	readinessResponse struct {
+		Status string                    `json:"status"`
+		Checks map[string]readinessCheck `json:"checks"`
+	}
+)
+
+var resourceTypeParam = param{
+	name:        "type",
+	description: "Kind of resource.",
+	enum:        []string{"user", "restaurant", "menu"},
+	required:    true,
+}
+
+var (
+	imageSlotParam = param{
+		name:        "slot",
+		description: "Where the image is shown.",
+		enum:        []string{string(models.ImageSlotLogo), string(models.ImageSlotBanner)},
+	}
+	imageVariantParam = param{
+		name:        "variant",
+		description: "Rendition, fitting within 160, 640 or 1600 pixels.",
+		enum:        []string{"thumb", "medium", "large"},
+	}
+	imageUploadErrors = []int{
+		http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusRequestEntityTooLarge,
+		http.StatusUnsupportedMediaType, http.StatusTooManyRequests, http.StatusInternalServerError,
+	}
+	reviewStatuses = []string{string(models.ReviewVisible), string(models.ReviewFlagged), string(models.ReviewHidden)}
+	tagKinds       = []string{string(models.TagKindCuisine), string(models.TagKindDietary), string(models.TagKindLabel)}
+	tagFilterParam = param{
+		name:        "tag",
+		description: "Only items carrying every given tag slug. Repeat the parameter or separate slugs with commas.",
+	}
+	pageParams = []param{
+		{name: "limit", description: "Maximum number of reviews, 1 to 100. Defaults to 20.", kind: "integer"},
+		{name: "offset", description: "Number of reviews to skip.", kind: "integer"},
+	}
+	menuAtParam = param{
+		name:        "at",
+		description: "RFC3339 time to show the dishes served at, for previewing named menus. Defaults to now.",
+		format:      "date-time",
+	}
+	allergenFilterParam = param{
+		name:        "exclude_allergen",
+		description: "Only dishes free of every given allergen. Repeat the parameter or separate allergens with commas.",
+	}
+)
+
+// operations documents every route in SetupRoutes, keyed by method and path
+// template without the version prefix. TestSpecCoversRoutes fails when a
+// route is missing here.
+var operations = map[string]operation{
+	"GET /health": {
+		summary:  "Legacy liveness probe",
+		tag:      "health",
+		response: map[string]bool{},
+	},
+	"GET /livez": {
+		summary:  "Liveness probe",
+		tag:      "health",
+		response: statusResponse{},
+	},
+	"GET /readyz": {
+		summary:  "Readiness probe covering the database, migrations and shutdown",
+		tag:      "health",
+		response: readinessResponse{},
+		errors:   []int{http.StatusServiceUnavailable},
+	},
+	"GET /metrics": {
+		summary:  "Prometheus metrics",
+		tag:      "health",
+		response: "",
+	},
+	"GET /openapi.json": {
+		summary:  "This document",
+		tag:      "docs",
+		response: map[string]any{},
+	},
+	"GET /media/images/{id}/{variant}": {
+		summary:  "Serve a rendition of an uploaded image",
+		tag:      "images",
+		path:     []param{imageVariantParam},
+		response: binaryBody{"image/jpeg", "image/png"},
+		errors:   []int{http.StatusNotFound, http.StatusInternalServerError},
+	},
+	"GET /docs": {
+		summary:  "Interactive API documentation",
+		tag:      "docs",
+		response: "",
+	},
+	"GET /docs/{file}": {
+		summary:  "A script or stylesheet of the interactive documentation",
+		tag:      "docs",
+		path:     []param{{name: "file", description: "Asset of the docs page.", enum: docsAssets}},
+		response: binaryBody{"text/javascript", "text/css"},
+		errors:   []int{http.StatusNotFound},
+	},
+
+	"POST /register": {
+		summary:    "Register a user and start a session",
+		tag:        "auth",
+		idempotent: true,
+		request:    registerRequest{},
+		response:   registerResponse{},
+		// a replay signs in again, so it fails like a login once the password changed
+		errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"POST /login": {
+		summary:  "Log in; sets the refresh_token and csrf_token cookies",
+		tag:      "auth",
+		request:  loginRequest{},
+		response: loginResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"POST /refresh": {
+		summary:  "Rotate the refresh token and issue a new access token",
+		tag:      "auth",
+		auth:     authCookie,
+		response: refreshResponse{},
+		errors:   []int{http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+
+	"POST /api/address": {
+		summary:    "Add an address for the caller",
+		tag:        "users",
+		auth:       authBearer,
+		idempotent: true,
+		request:    addAddressRequest{},
+		response:   addAddressResponse{},
+		errors:     []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/sessions": {
+		summary:  "List the caller's active sessions",
+		tag:      "sessions",
+		auth:     authBearer,
+		response: []models.Session{},
+		errors:   []int{http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"POST /api/logout": {
+		summary:  "End the current session",
+		tag:      "auth",
+		auth:     authBearer,
+		response: messageResponse{},
+		errors:   []int{http.StatusForbidden, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"PUT /api/password": {
+		summary:  "Change the caller's password and revoke their other sessions",
+		tag:      "users",
+		auth:     authBearer,
+		request:  changePasswordRequest{},
+		response: messageResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"DELETE /api/sessions": {
+		summary:  "Revoke every session of the caller except the current one",
+		tag:      "sessions",
+		auth:     authBearer,
+		response: revokedResponse{},
+		errors:   []int{http.StatusForbidden, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"DELETE /api/sessions/{id}": {
+		summary:  "Revoke one of the caller's sessions",
+		tag:      "sessions",
+		auth:     authBearer,
+		response: messageResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/restaurants": {
+		summary: "List restaurants",
+		tag:     "restaurants",
+		auth:    authBearer,
+		query: []param{tagFilterParam, {
+			name:        "sort",
+			description: "Order of the listing: newest first, or best rated first with ties going to the more reviewed.",
+			enum:        []string{"newest", "rating"},
+		}},
+		conditional: true,
+		response:    []restaurantSummary{},
+		errors:      []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/restaurants/facets": {
+		summary:     "Count the tags of the restaurants matching a filter",
+		tag:         "restaurants",
+		auth:        authBearer,
+		query:       []param{tagFilterParam},
+		conditional: true,
+		response:    restaurantFacets{},
+		errors:      []int{http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/restaurants/{id}/dishes": {
+		summary:     "List the dishes a restaurant serves, those of its named menus active at the time when it has any",
+		tag:         "restaurants",
+		auth:        authBearer,
+		query:       []param{tagFilterParam, allergenFilterParam, menuAtParam},
+		conditional: true,
+		response:    []dish{},
+		errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/restaurants/{id}/dishes/facets": {
+		summary:     "Count the tags and allergens of the dishes matching a filter",
+		tag:         "restaurants",
+		auth:        authBearer,
+		query:       []param{tagFilterParam, allergenFilterParam, menuAtParam},
+		conditional: true,
+		response:    dishFacets{},
+		errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/tags": {
+		summary:  "List the tag taxonomy",
+		tag:      "tags",
+		auth:     authBearer,
+		query:    []param{{name: "kind", description: "Only tags of this kind.", enum: tagKinds}},
+		response: []models.Tag{},
+		errors:   []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/restaurants/{id}/distance": {
+		summary: "Distance to a restaurant (not implemented yet)",
+		tag:     "restaurants",
+		auth:    authBearer,
+		errors:  []int{http.StatusTooManyRequests},
+	},
+	"GET /api/search": {
+		summary: "Search restaurants and dishes",
+		tag:     "restaurants",
+		auth:    authBearer,
+		query: []param{
+			{name: "q", description: "Search terms, up to 200 characters. Supports quoted phrases, or and -exclusions; misspelt names still match.", required: true},
+			{name: "lat", description: "Latitude to rank nearby restaurants higher. Requires lon.", kind: "number", format: "double"},
+			{name: "lon", description: "Longitude to rank nearby restaurants higher. Requires lat.", kind: "number", format: "double"},
+			{name: "limit", description: "Maximum number of restaurants, 1 to 50. Defaults to 20.", kind: "integer"},
+		},
+		response: searchResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+
+	"POST /api/admin/subadmins": {
+		summary:  "Grant the subadmin role to an existing user",
+		tag:      "admin",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin},
+		request:  createSubAdminRequest{},
+		response: userCreatedResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/admin/subadmins": {
+		summary:  "List subadmins",
+		tag:      "admin",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin},
+		response: []userSummary{},
+		errors:   []int{http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"DELETE /api/admin/users/{id}/sessions": {
+		summary:  "Log a user out of every session",
+		tag:      "admin",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin},
+		response: revokedResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"POST /api/admin/users/{id}/impersonate": {
+		summary:  "Issue a short-lived access token acting as a user",
+		tag:      "admin",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin},
+		request:  impersonateRequest{},
+		response: impersonateResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"POST /api/admin/tags": {
+		summary:  "Add a tag to the taxonomy",
+		tag:      "tags",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin},
+		request:  createTagRequest{},
+		response: models.Tag{},
+		errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"PUT /api/admin/tags/{id}": {
+		summary:  "Rename or reclassify a tag",
+		tag:      "tags",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin},
+		request:  updateTagRequest{},
+		response: models.Tag{},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"DELETE /api/admin/tags/{id}": {
+		summary:  "Archive a tag, removing it from every listing",
+		tag:      "tags",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin},
+		response: messageResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/admin/audit": {
+		summary: "Query the audit log",
+		tag:     "admin",
+		auth:    authBearer,
+		roles:   []models.Role{models.RoleAdmin},
+		query: []param{
+			{name: "actor_id", description: "User who performed the action.", format: "uuid"},
+			{name: "resource_type", description: "Type of the changed resource."},
+			{name: "resource_id", description: "ID of the changed resource."},
+			{name: "from", description: "Earliest entry, RFC 3339.", format: "date-time"},
+			{name: "to", description: "Latest entry, RFC 3339.", format: "date-time"},
+			{name: "limit", description: "Maximum number of entries.", kind: "integer"},
+		},
+		response: []models.AuditLog{},
+		errors:   []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+
+	"POST /api/subadmin/resources": {
+		summary:    "Create a user, restaurant or menu item",
+		tag:        "resources",
+		auth:       authBearer,
+		roles:      []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		query:      []param{resourceTypeParam},
+		idempotent: true,
+		request:    oneOf(createUserInput{}, createRestaurantInput{}, createMenuItemInput{}),
+		response:   oneOf(userCreatedResponse{}, restaurantCreatedResponse{}, menuItemCreatedResponse{}),
+		errors:     []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/subadmin/resources": {
+		summary:  "List the users, restaurants or menu items created by the caller; admins see all",
+		tag:      "resources",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		query:    []param{resourceTypeParam},
+		response: oneOf([]userSummary{}, []ownedRestaurant{}, []ownedMenuItem{}),
+		errors:   []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/subadmin/users": {
+		summary:  "List the users created by the caller; admins see all",
+		tag:      "resources",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: []userSummary{},
+		errors:   []int{http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"PUT /api/subadmin/restaurants/{id}/tags": {
+		summary:  "Replace the tags of a restaurant; subadmins only for restaurants they created",
+		tag:      "tags",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		request:  setTagsRequest{},
+		response: restaurantTagsResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"PUT /api/subadmin/menu/{id}/tags": {
+		summary:  "Replace the tags of a menu item; subadmins only for items of their own",
+		tag:      "tags",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		request:  setTagsRequest{},
+		response: menuItemTagsResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"PUT /api/subadmin/menu/{id}/allergens": {
+		summary:  "Replace the allergens declared on a menu item; subadmins only for items of their own",
+		tag:      "tags",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		request:  setAllergensRequest{},
+		response: menuItemAllergensResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"PUT /api/subadmin/restaurants/{id}/images/{slot}": {
+		summary:  "Upload the logo or banner of a restaurant (JPEG, PNG, GIF or WebP); subadmins only for restaurants they created",
+		tag:      "images",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		path:     []param{imageSlotParam},
+		request:  multipartFile("image"),
+		response: imageResponse{},
+		errors:   imageUploadErrors,
+	},
+	"DELETE /api/subadmin/restaurants/{id}/images/{slot}": {
+		summary:  "Remove the logo or banner of a restaurant",
+		tag:      "images",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		path:     []param{imageSlotParam},
+		response: messageResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"PUT /api/subadmin/menu/{id}/image": {
+		summary:  "Upload the photo of a menu item (JPEG, PNG, GIF or WebP); subadmins only for items of their own",
+		tag:      "images",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		request:  multipartFile("image"),
+		response: imageResponse{},
+		errors:   imageUploadErrors,
+	},
+	"DELETE /api/subadmin/menu/{id}/image": {
+		summary:  "Remove the photo of a menu item",
+		tag:      "images",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: messageResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/restaurants/{id}/reviews": {
+		summary:  "List the reviews of a restaurant, newest first; hidden ones are left out",
+		tag:      "reviews",
+		auth:     authBearer,
+		query:    pageParams,
+		response: []models.Review{},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"PUT /api/restaurants/{id}/review": {
+		summary:  "Create or replace the caller's review of a restaurant, with optional dish ratings",
+		tag:      "reviews",
+		auth:     authBearer,
+		request:  saveReviewRequest{},
+		response: models.Review{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"DELETE /api/restaurants/{id}/review": {
+		summary:  "Delete the caller's review of a restaurant",
+		tag:      "reviews",
+		auth:     authBearer,
+		response: messageResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/subadmin/reviews": {
+		summary: "List reviews for moderation",
+		tag:     "reviews",
+		auth:    authBearer,
+		roles:   []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		query: append([]param{
+			{
+				name:        "status",
+				description: "Only reviews in the given statuses, flagged by default. Repeat the parameter or separate statuses with commas.",
+				enum:        reviewStatuses,
+			},
+			{name: "restaurant_id", description: "Only reviews of this restaurant.", format: "uuid"},
+		}, pageParams...),
+		response: []models.Review{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"POST /api/subadmin/reviews/{id}/moderation": {
+		summary:  "Flag or hide a review with a reason, or make it visible again",
+		tag:      "reviews",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		request:  moderateReviewRequest{},
+		response: models.Review{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"PUT /api/subadmin/reviews/{id}/reply": {
+		summary:  "Reply to a review on behalf of the restaurant; only its owner or an admin",
+		tag:      "reviews",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		request:  replyRequest{},
+		response: models.Review{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"DELETE /api/subadmin/reviews/{id}/reply": {
+		summary:  "Remove the restaurant's reply to a review",
+		tag:      "reviews",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: models.Review{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/subadmin/restaurants/{id}/inventory": {
+		summary:  "List the stock and availability of a restaurant's menu; subadmins only for restaurants they created",
+		tag:      "inventory",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: []models.Inventory{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"PUT /api/subadmin/menu/{id}/stock": {
+		summary:  "Set the stock of a menu item and the stock it gets back every day; a null stock stops tracking",
+		tag:      "inventory",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		request:  setStockRequest{},
+		response: models.Inventory{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"PUT /api/subadmin/menu/{id}/availability": {
+		summary:  "Switch a menu item on or off",
+		tag:      "inventory",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		request:  setAvailabilityRequest{},
+		response: models.Inventory{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"POST /api/subadmin/menu/{id}/86": {
+		summary:  "Take a menu item off until the next daily reset",
+		tag:      "inventory",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: models.Inventory{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"DELETE /api/subadmin/menu/{id}/86": {
+		summary:  "Put an 86'd menu item back before the daily reset",
+		tag:      "inventory",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: models.Inventory{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/menu/{id}/price": {
+		summary: "Get the price of a menu item at a time, with scheduled changes and happy hours applied",
+		tag:     "prices",
+		auth:    authBearer,
+		query: []param{{
+			name:        "at",
+			description: "RFC3339 time to price at. Defaults to now.",
+			format:      "date-time",
+		}},
+		response: effectivePrice{},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/subadmin/menu/{id}/prices": {
+		summary:  "List the price history of a menu item, scheduled changes included; the ETag names the current price",
+		tag:      "prices",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: []models.PriceChange{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"PUT /api/subadmin/menu/{id}/price": {
+		summary:  "Reprice a menu item now, or schedule the change with a future effective_at",
+		tag:      "prices",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		guarded:  true,
+		request:  updatePriceRequest{},
+		response: models.PriceChange{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"DELETE /api/subadmin/menu/{id}/prices/{change_id}": {
+		summary:  "Cancel a scheduled price change",
+		tag:      "prices",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: models.PriceChange{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/subadmin/restaurants/{id}/price-rules": {
+		summary:  "List the active price rules of a restaurant",
+		tag:      "prices",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: []models.PriceRule{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"POST /api/subadmin/restaurants/{id}/price-rules": {
+		summary:  "Add a happy hour or other daily discount window to a restaurant's menu or one of its items",
+		tag:      "prices",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		request:  createPriceRuleRequest{},
+		response: models.PriceRule{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"DELETE /api/subadmin/price-rules/{id}": {
+		summary:  "End a price rule",
+		tag:      "prices",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: messageResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"POST /api/subadmin/restaurants/{id}/menu/import": {
+		summary:  "Create and update a restaurant's menu items by SKU from CSV or JSON, all or nothing; dry_run reports without applying",
+		tag:      "menu",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		query:    []param{{name: "dry_run", description: "Validate and report what would change without applying it.", kind: "boolean"}},
+		request:  csvOrJSON{[]models.MenuRow{}},
+		response: models.ImportReport{},
+		rejected: models.ImportReport{},
+		errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge,
+			http.StatusUnsupportedMediaType, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/subadmin/restaurants/{id}/menu/export": {
+		summary:  "Export a restaurant's menu in the shape the import takes",
+		tag:      "menu",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		query:    []param{{name: "format", description: "json, the default, or csv.", enum: []string{"json", "csv"}}},
+		response: csvOrJSON{[]models.MenuRow{}},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+
+	"GET /api/subadmin/restaurants/{id}/menus": {
+		summary:  "List the named menus of a restaurant, such as breakfast and dinner",
+		tag:      "menus",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: []models.NamedMenu{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"POST /api/subadmin/restaurants/{id}/menus": {
+		summary:  "Add a named menu to a restaurant, with an empty draft",
+		tag:      "menus",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		request:  createNamedMenuRequest{},
+		response: models.NamedMenu{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"DELETE /api/subadmin/menus/{id}": {
+		summary:  "Archive a named menu, taking it off the catalog",
+		tag:      "menus",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: messageResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/subadmin/menus/{id}/versions": {
+		summary:  "List the versions of a named menu, latest first",
+		tag:      "menus",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: []models.MenuVersion{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"GET /api/subadmin/menus/{id}/versions/{number}": {
+		summary:  "Get a version of a named menu",
+		tag:      "menus",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		path:     []param{{name: "number", description: "Version number, from 1.", kind: "integer"}},
+		response: models.MenuVersion{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"PUT /api/subadmin/menus/{id}/draft": {
+		summary:  "Set the dishes and serving windows of a named menu's draft, starting a draft if there is none",
+		tag:      "menus",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		request:  saveMenuDraftRequest{},
+		response: models.MenuVersion{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"POST /api/subadmin/menus/{id}/publish": {
+		summary:  "Publish a named menu's draft, serving it from now on",
+		tag:      "menus",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		response: models.MenuVersion{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+	"POST /api/subadmin/menus/{id}/rollback": {
+		summary:  "Serve an earlier published version of a named menu again",
+		tag:      "menus",
+		auth:     authBearer,
+		roles:    []models.Role{models.RoleAdmin, models.RoleSubAdmin},
+		request:  rollbackMenuRequest{},
+		response: models.MenuVersion{},
+		errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+	},
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// mirrored names, for every body type in operations.go, where the handlers
+// declare the same body: a package-level type of that name in handlers or
+// models, or a handler whose local struct or map literal has the same fields.
+var mirrored = map[string]string{
+	"registerRequest":           "Register",
+	"registerResponse":          "Register",
+	"loginRequest":              "Login",
+	"loginResponse":             "Login",
+	"refreshResponse":           "RefershToken",
+	"messageResponse":           "Logout",
+	"revokedResponse":           "RevokeOtherSessions",
+	"addAddressRequest":         "AddAddress",
+	"addAddressResponse":        "AddAddress",
+	"changePasswordRequest":     "ChangePassword",
+	"restaurantSummary":         "restaurantListing",
+	"rating":                    "rating",
+	"imageResponse":             "imageResponse",
+	"searchDish":                "searchDish",
+	"searchResult":              "searchResult",
+	"searchResponse":            "searchResponse",
+	"dish":                      "dish",
+	"effectivePrice":            "effectivePrice",
+	"updatePriceRequest":        "UpdatePrice",
+	"createPriceRuleRequest":    "models.PriceRule",
+	"createNamedMenuRequest":    "CreateNamedMenu",
+	"saveMenuDraftRequest":      "SaveMenuDraft",
+	"rollbackMenuRequest":       "RollbackMenu",
+	"setStockRequest":           "SetStock",
+	"setAvailabilityRequest":    "SetAvailability",
+	"saveReviewRequest":         "SaveReview",
+	"replyRequest":              "ReplyToReview",
+	"moderateReviewRequest":     "ModerateReview",
+	"tagFacet":                  "tagFacet",
+	"allergenFacet":             "allergenFacet",
+	"restaurantFacets":          "restaurantFacets",
+	"dishFacets":                "dishFacets",
+	"createTagRequest":          "CreateTag",
+	"updateTagRequest":          "UpdateTag",
+	"setTagsRequest":            "parseTagging",
+	"restaurantTagsResponse":    "SetRestaurantTags",
+	"menuItemTagsResponse":      "SetMenuItemTags",
+	"setAllergensRequest":       "SetMenuItemAllergens",
+	"menuItemAllergensResponse": "SetMenuItemAllergens",
+	"userSummary":               "ListSubAdmins",
+	"createSubAdminRequest":     "CreateSubAdmin",
+	"userCreatedResponse":       "CreateSubAdmin",
+	"impersonateRequest":        "Impersonate",
+	"impersonateResponse":       "Impersonate",
+	"createUserInput":           "createUser",
+	"createRestaurantInput":     "createRestaurant",
+	"createMenuItemInput":       "createMenuItem",
+	"restaurantCreatedResponse": "createRestaurant",
+	"menuItemCreatedResponse":   "createMenuItem",
+	"ownedRestaurant":           "listRestaurantsByCreator",
+	"ownedMenuItem":             "listMenuItemsByCreator",
+	"statusResponse":            "Livez",
+	"readinessCheck":            "checkResult",
+	"readinessResponse":         "Readyz",
+	// the handlers keep the URLs of an image in a map keyed by variant
+	"imageURLs": "",
+}
+
+// serverAssigned lists the fields of a model a request decodes into that the
+// handler sets itself, and so are left out of the documented request.
+var serverAssigned = map[string][]string{
+	"createPriceRuleRequest": {"created_at", "created_by", "id", "restaurant_id"},
+}
+
+// TestOperationsMirrorHandlers fails when a handler's request or response
+// body and its copy in operations.go no longer have the same JSON fields.
+// The field types are left to the copies, which may be more precise, such as
+// a pointer for a field that is null at times.
+func TestOperationsMirrorHandlers(t *testing.T) {
+	mirrors := bodyShapes(t, "operations.go")
+	types, funcs := handlerShapes(t, "../handlers", "")
+	models, _ := handlerShapes(t, "../models", "models.")
+	maps.Copy(types, models)
+
+	for name, fields := range mirrors {
+		source, ok := mirrored[name]
+		if !ok {
+			t.Errorf("%s is not mapped to the handler body it documents", name)
+			continue
+		}
+		if source == "" {
+			continue
+		}
+		if want, ok := types[source]; ok {
+			want = slices.DeleteFunc(slices.Clone(want), func(tag string) bool {
+				field, _, _ := strings.Cut(tag, ",")
+				return slices.Contains(serverAssigned[name], field)
+			})
+			if !reflect.DeepEqual(fields, want) {
+				t.Errorf("%s has fields %v, %s has %v", name, fields, source, want)
+			}
+			continue
+		}
+		candidates, ok := funcs[source]
+		if !ok {
+			t.Errorf("%s mirrors %s, which does not exist", name, source)
+			continue
+		}
+		if !slices.ContainsFunc(candidates, func(c []string) bool { return sameFields(fields, c) }) {
+			t.Errorf("%s has fields %v, which no body in %s has: %v", name, fields, source, candidates)
+		}
+	}
+	for name := range mirrored {
+		if _, ok := mirrors[name]; !ok {
+			t.Errorf("mirrored lists %s, which operations.go does not declare", name)
+		}
+	}
+}
+
+// sameFields compares a struct's json tags with those of a handler body. A
+// map literal has no options, so only the names count then.
+func sameFields(mirror, body []string) bool {
+	if slices.Equal(mirror, body) {
+		return true
+	}
+	names := make([]string, len(mirror))
+	for i, tag := range mirror {
+		names[i], _, _ = strings.Cut(tag, ",")
+	}
+	slices.Sort(names)
+	return slices.Equal(names, body)
+}
+
+// bodyShapes returns the sorted json tags of each struct type declared at the
+// top level of file.
+func bodyShapes(t *testing.T, file string) map[string][]string {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shapes := map[string][]string{}
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			spec := spec.(*ast.TypeSpec)
+			if st, ok := spec.Type.(*ast.StructType); ok {
+				shapes[spec.Name.Name] = structTags(st)
+			}
+		}
+	}
+	return shapes
+}
+
+// handlerShapes returns the json tags of the package-level struct types in
+// dir, named with prefix, and for each function the tags of the structs and
+// the keys of the string-keyed map literals in its body.
+func handlerShapes(t *testing.T, dir, prefix string) (map[string][]string, map[string][][]string) {
+	t.Helper()
+	pkgs, err := parser.ParseDir(token.NewFileSet(), dir, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string][]string{}
+	funcs := map[string][][]string{}
+	for _, pkg := range pkgs {
+		for name, f := range pkg.Files {
+			if strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			for _, decl := range f.Decls {
+				switch decl := decl.(type) {
+				case *ast.GenDecl:
+					for _, spec := range decl.Specs {
+						if spec, ok := spec.(*ast.TypeSpec); ok {
+							if st, ok := spec.Type.(*ast.StructType); ok {
+								types[prefix+spec.Name.Name] = structTags(st)
+							}
+						}
+					}
+				case *ast.FuncDecl:
+					if decl.Body != nil {
+						funcs[decl.Name.Name] = bodiesIn(decl.Body)
+					}
+				}
+			}
+		}
+	}
+	return types, funcs
+}
+
+func bodiesIn(body *ast.BlockStmt) [][]string {
+	var bodies [][]string
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.StructType:
+			bodies = append(bodies, structTags(n))
+		case *ast.CompositeLit:
+			m, ok := n.Type.(*ast.MapType)
+			if !ok {
+				break
+			}
+			if key, ok := m.Key.(*ast.Ident); !ok || key.Name != "string" {
+				break
+			}
+			var keys []string
+			for _, elt := range n.Elts {
+				kv, ok := elt.(*ast.KeyValueExpr)
+				if !ok {
+					continue
+				}
+				if lit, ok := kv.Key.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					key, _ := strconv.Unquote(lit.Value)
+					keys = append(keys, key)
+				}
+			}
+			slices.Sort(keys)
+			bodies = append(bodies, keys)
+		}
+		return true
+	})
+	return bodies
+}
+
+func structTags(st *ast.StructType) []string {
+	var tags []string
+	for _, field := range st.Fields.List {
+		if field.Tag == nil {
+			continue
+		}
+		tag, _ := strconv.Unquote(field.Tag.Value)
+		if json := reflect.StructTag(tag).Get("json"); json != "" && json != "-" {
+			tags = append(tags, json)
+		}
+	}
+	slices.Sort(tags)
+	return tags
+}
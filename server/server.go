@@ -2,19 +2,27 @@ package server
 
 import (
 	"context"
+	"errors"
 	"io"
+	"net"
 	"net/http"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/ray-remotestate/restro/config"
+	"github.com/ray-remotestate/restro/database"
 	"github.com/ray-remotestate/restro/middlewares"
 	"github.com/ray-remotestate/restro/handlers"
 	"github.com/ray-remotestate/restro/models"
+	"github.com/ray-remotestate/restro/ratelimit"
 )
 
 type Server struct {
-	Router *mux.Router
-	server *http.Server
+	Router  *mux.Router
+	handler http.Handler
+	server  *http.Server
+	errs   chan error
 }
 
 const (
@@ -25,57 +33,194 @@ const (
 
 func SetupRoutes() *Server {
 	router := mux.NewRouter()
-	authRoutes := router.PathPrefix("/api").Subrouter()
-	authRoutes.Use(middlewares.AuthMiddleware)
+	router.Use(middlewares.RouteTemplate, middlewares.Tracing(config.Tracing.ServiceName))
+	rateLimit := newRateLimiter()
 
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		io.WriteString(w, `{"alive": true}`)
 	}).Methods("GET")
-	router.HandleFunc("/register", handlers.Register).Methods("POST")
-	router.HandleFunc("/refresh", handlers.RefershToken).Methods("POST")
-	router.HandleFunc("/login", handlers.Login).Methods("POST")
-	authRoutes.HandleFunc("/logout", handlers.Logout).Methods("POST")
-	authRoutes.HandleFunc("/address",handlers.AddAddress).Methods("POST")
+	router.HandleFunc("/livez", handlers.Livez).Methods("GET")
+	router.HandleFunc("/readyz", handlers.Readyz).Methods("GET")
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	router.HandleFunc("/openapi.json", openAPIHandler(router)).Methods("GET")
+	router.HandleFunc("/docs", docsHandler()).Methods("GET")
+	router.HandleFunc("/docs/{file}", docsAssetHandler()).Methods("GET")
+	router.HandleFunc("/media/images/{id}/{variant}", handlers.ServeImage).Methods("GET")
+
+	v1 := router.PathPrefix("/v1").Subrouter()
+	v1.Use(middlewares.APIVersion(1))
+	registerAPIRoutes(v1, rateLimit)
+
+	// v2 sends amounts as objects carrying their currency
+	v2 := router.PathPrefix("/v2").Subrouter()
+	v2.Use(middlewares.APIVersion(2))
+	registerAPIRoutes(v2, rateLimit)
+
+	// the unversioned paths predate /v1 and serve it until the sunset date
+	legacy := router.NewRoute().Subrouter()
+	legacy.Use(middlewares.APIVersion(1), middlewares.Deprecated(config.LegacyAPI.DeprecatedAt, config.LegacyAPI.Sunset, "/v1"))
+	registerAPIRoutes(legacy, rateLimit)
+
+	return &Server {
+		Router: router,
+		// CORS wraps the router so preflight requests are answered before routing
+		handler: middlewares.SecurityHeaders(middlewares.CORS(router)),
+	}
+}
+
+// registerAPIRoutes adds the API to router, once per version prefix.
+func registerAPIRoutes(router *mux.Router, rateLimit func(ratelimit.Policy) mux.MiddlewareFunc) {
+	public := router.NewRoute().Subrouter()
+	public.Use(rateLimit(config.RateLimit.Auth))
+
+	// tokens are never stored for replay; a retry is signed in afresh
+	registerIdempotent := middlewares.IdempotentCredentials(handlers.ReissueRegistration, "access_token", "refersh_token")
+	public.Handle("/register", registerIdempotent(http.HandlerFunc(handlers.Register))).Methods("POST")
+	public.HandleFunc("/login", handlers.Login).Methods("POST")
+
+	// authenticated by the refresh token cookie
+	cookieAuth := public.NewRoute().Subrouter()
+	cookieAuth.Use(middlewares.CSRF)
+
+	cookieAuth.HandleFunc("/refresh", handlers.RefershToken).Methods("POST")
+
+	authRoutes := router.PathPrefix("/api").Subrouter()
+	authRoutes.Use(middlewares.AuthMiddleware, middlewares.ImpersonationAudit, rateLimit(config.RateLimit.API))
+
+	authRoutes.Handle("/address", middlewares.Idempotent(http.HandlerFunc(handlers.AddAddress))).Methods("POST")
+	authRoutes.HandleFunc("/sessions", handlers.ListSessions).Methods("GET")
+
+	// not available to impersonation tokens
+	sensitive := authRoutes.NewRoute().Subrouter()
+	sensitive.Use(middlewares.BlockImpersonation)
+
+	sensitive.HandleFunc("/logout", handlers.Logout).Methods("POST")
+	sensitive.HandleFunc("/password", handlers.ChangePassword).Methods("PUT")
+	sensitive.HandleFunc("/sessions", handlers.RevokeOtherSessions).Methods("DELETE")
+	sensitive.HandleFunc("/sessions/{id}", handlers.RevokeSession).Methods("DELETE")
 
 	authRoutes.HandleFunc("/restaurants", handlers.ListRestaurants).Methods("GET")
+	authRoutes.HandleFunc("/restaurants/facets", handlers.RestaurantFacets).Methods("GET")
 	authRoutes.HandleFunc("/restaurants/{id}/dishes", handlers.GetDishesByRestaurant).Methods("GET")
+	authRoutes.HandleFunc("/restaurants/{id}/dishes/facets", handlers.DishFacets).Methods("GET")
 	authRoutes.HandleFunc("/restaurants/{id}/distance", handlers.GetDistance).Methods("GET")
+	authRoutes.HandleFunc("/search", handlers.Search).Methods("GET")
+	authRoutes.HandleFunc("/tags", handlers.ListTags).Methods("GET")
+	authRoutes.HandleFunc("/menu/{id}/price", handlers.GetEffectivePrice).Methods("GET")
+	authRoutes.HandleFunc("/restaurants/{id}/reviews", handlers.ListReviews).Methods("GET")
+	authRoutes.HandleFunc("/restaurants/{id}/review", handlers.SaveReview).Methods("PUT")
+	authRoutes.HandleFunc("/restaurants/{id}/review", handlers.DeleteReview).Methods("DELETE")
 
 	// admin only
 	admin := authRoutes.PathPrefix("/admin").Subrouter()
-	admin.Use(middlewares.RoleBasedMiddleware(models.RoleAdmin))
+	admin.Use(middlewares.RoleBasedMiddleware(models.RoleAdmin), middlewares.BlockImpersonation, rateLimit(config.RateLimit.Admin))
 
 	admin.HandleFunc("/subadmins", handlers.CreateSubAdmin).Methods("POST")
 	admin.HandleFunc("/subadmins", handlers.ListSubAdmins).Methods("GET")
+	admin.HandleFunc("/users/{id}/sessions", handlers.ForceLogoutUser).Methods("DELETE")
+	admin.HandleFunc("/users/{id}/impersonate", handlers.Impersonate).Methods("POST")
+	admin.HandleFunc("/audit", handlers.ListAuditLogs).Methods("GET")
+	admin.HandleFunc("/tags", handlers.CreateTag).Methods("POST")
+	admin.HandleFunc("/tags/{id}", handlers.UpdateTag).Methods("PUT")
+	admin.HandleFunc("/tags/{id}", handlers.ArchiveTag).Methods("DELETE")
 
 	// admin n subadmin
 	adminSub := authRoutes.PathPrefix("/subadmin").Subrouter()
 	adminSub.Use(middlewares.RoleBasedMiddleware(models.RoleAdmin, models.RoleSubAdmin))
 
-	adminSub.HandleFunc("/resources", handlers.CreateResource).Methods("POST")
+	adminSub.Handle("/resources", middlewares.Idempotent(http.HandlerFunc(handlers.CreateResource))).Methods("POST")
 	adminSub.HandleFunc("/users", handlers.ListAllUsersBySubAdmin).Methods("GET")
 	adminSub.HandleFunc("/resources", handlers.ListResources).Methods("GET")
+	adminSub.HandleFunc("/restaurants/{id}/tags", handlers.SetRestaurantTags).Methods("PUT")
+	adminSub.HandleFunc("/menu/{id}/tags", handlers.SetMenuItemTags).Methods("PUT")
+	adminSub.HandleFunc("/menu/{id}/allergens", handlers.SetMenuItemAllergens).Methods("PUT")
+	adminSub.HandleFunc("/restaurants/{id}/images/{slot}", handlers.UploadRestaurantImage).Methods("PUT")
+	adminSub.HandleFunc("/restaurants/{id}/images/{slot}", handlers.DeleteRestaurantImage).Methods("DELETE")
+	adminSub.HandleFunc("/menu/{id}/image", handlers.UploadMenuItemImage).Methods("PUT")
+	adminSub.HandleFunc("/menu/{id}/image", handlers.DeleteMenuItemImage).Methods("DELETE")
+	adminSub.HandleFunc("/restaurants/{id}/menu/import", handlers.ImportMenu).Methods("POST")
+	adminSub.HandleFunc("/restaurants/{id}/menu/export", handlers.ExportMenu).Methods("GET")
+	adminSub.HandleFunc("/restaurants/{id}/inventory", handlers.GetInventory).Methods("GET")
+	adminSub.HandleFunc("/menu/{id}/stock", handlers.SetStock).Methods("PUT")
+	adminSub.HandleFunc("/menu/{id}/availability", handlers.SetAvailability).Methods("PUT")
+	adminSub.HandleFunc("/menu/{id}/86", handlers.EightySix).Methods("POST")
+	adminSub.HandleFunc("/menu/{id}/86", handlers.UndoEightySix).Methods("DELETE")
+	adminSub.HandleFunc("/menu/{id}/prices", handlers.ListPriceHistory).Methods("GET")
+	adminSub.HandleFunc("/menu/{id}/price", handlers.UpdatePrice).Methods("PUT")
+	adminSub.HandleFunc("/menu/{id}/prices/{change_id}", handlers.CancelPriceChange).Methods("DELETE")
+	adminSub.HandleFunc("/restaurants/{id}/price-rules", handlers.ListPriceRules).Methods("GET")
+	adminSub.HandleFunc("/restaurants/{id}/price-rules", handlers.CreatePriceRule).Methods("POST")
+	adminSub.HandleFunc("/price-rules/{id}", handlers.ArchivePriceRule).Methods("DELETE")
+	adminSub.HandleFunc("/restaurants/{id}/menus", handlers.ListNamedMenus).Methods("GET")
+	adminSub.HandleFunc("/restaurants/{id}/menus", handlers.CreateNamedMenu).Methods("POST")
+	adminSub.HandleFunc("/menus/{id}", handlers.ArchiveNamedMenu).Methods("DELETE")
+	adminSub.HandleFunc("/menus/{id}/versions", handlers.ListMenuVersions).Methods("GET")
+	adminSub.HandleFunc("/menus/{id}/versions/{number}", handlers.GetMenuVersion).Methods("GET")
+	adminSub.HandleFunc("/menus/{id}/draft", handlers.SaveMenuDraft).Methods("PUT")
+	adminSub.HandleFunc("/menus/{id}/publish", handlers.PublishMenu).Methods("POST")
+	adminSub.HandleFunc("/menus/{id}/rollback", handlers.RollbackMenu).Methods("POST")
+	adminSub.HandleFunc("/reviews", handlers.ListReviewsForModeration).Methods("GET")
+	adminSub.HandleFunc("/reviews/{id}/moderation", handlers.ModerateReview).Methods("POST")
+	adminSub.HandleFunc("/reviews/{id}/reply", handlers.ReplyToReview).Methods("PUT")
+	adminSub.HandleFunc("/reviews/{id}/reply", handlers.DeleteReviewReply).Methods("DELETE")
+}
 
-	return &Server {
-		Router: router,
+// newRateLimiter returns a constructor for rate limit middlewares backed by
+// the configured store, or no-op middlewares when rate limiting is disabled.
+func newRateLimiter() func(ratelimit.Policy) mux.MiddlewareFunc {
+	if !config.RateLimit.Enabled {
+		return func(ratelimit.Policy) mux.MiddlewareFunc {
+			return func(next http.Handler) http.Handler { return next }
+		}
+	}
+
+	var store ratelimit.Store
+	switch config.RateLimit.Store {
+	case "postgres":
+		store = ratelimit.NewPostgresStore(database.Restro)
+	default:
+		store = ratelimit.NewMemoryStore()
+	}
+	return func(policy ratelimit.Policy) mux.MiddlewareFunc {
+		return middlewares.RateLimit(store, policy)
 	}
 }
 
-func (svr *Server) Run(port string) error {
+// Start binds the listener and serves in the background. Request contexts
+// derive from ctx. Errors after a successful bind are sent to Errors().
+func (svr *Server) Start(ctx context.Context, port string) error {
 	svr.server = &http.Server{
 		Addr:	port,
-		Handler: svr.Router,
+		Handler: middlewares.RequestID(middlewares.AccessLog(middlewares.Metrics(middlewares.Recover(svr.handler)))),
 		ReadTimeout: readTimeout,
 		ReadHeaderTimeout: readHeaderTimeout,
 		WriteTimeout: writeTimeout,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	listener, err := net.Listen("tcp", port)
+	if err != nil {
+		return err
 	}
-	return svr.server.ListenAndServe()
+
+	svr.errs = make(chan error, 1)
+	go func() {
+		if err := svr.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			svr.errs <- err
+		}
+	}()
+	return nil
+}
+
+// Errors delivers a failure of the serve loop.
+func (svr *Server) Errors() <-chan error {
+	return svr.errs
 }
 
-func (svr *Server) Shutdown(timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+// Shutdown stops accepting connections and waits for in-flight requests
+// until ctx expires.
+func (svr *Server) Shutdown(ctx context.Context) error {
 	return svr.server.Shutdown(ctx)
 }
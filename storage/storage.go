@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore keeps opaque blobs by key. Keys are slash separated paths of
+// lowercase letters, digits, '.', '-' and '_'.
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	// Get returns ErrNotFound for missing keys. The caller closes the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes keys; missing keys are not an error.
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Images holds uploaded restaurant and menu images. main replaces it with
+// the store configured in config.Storage.
+var Images BlobStore = NewLocal("data/blobs")
+
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+		for _, c := range segment {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+				return fmt.Errorf("invalid blob key %q", key)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package utils
+
+import "math"
+
+const earthRadiusKm = 6371.0
+
+// HaversineKm is the great-circle distance in kilometres between two points
+// given in degrees.
+func HaversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -10,17 +14,23 @@ import (
 	"github.com/ray-remotestate/restro/middlewares"
 )
 
-func GenerateTokens(userID uuid.UUID, roles []string) (accessToken string, refreshToken string, err error) {
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+func GenerateTokens(userID, sessionID uuid.UUID, roles []string) (accessToken string, refreshToken string, err error) {
 	now := time.Now()
 
-	accessToken, err = GenerateAccessToken(userID, roles)
+	accessToken, err = GenerateAccessToken(userID, sessionID, roles)
 	if err != nil {
 		return "", "", err
 	}
 
 	refreshClaims := jwt.RegisteredClaims{
+		ID:        sessionID.String(),
 		Subject:   userID.String(),
-		ExpiresAt: jwt.NewNumericDate(now.Add(7 * 24 * time.Hour)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(RefreshTokenTTL)),
 		IssuedAt:  jwt.NewNumericDate(now),
 	}
 	refreshTokenObj := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
@@ -32,14 +42,15 @@ func GenerateTokens(userID uuid.UUID, roles []string) (accessToken string, refre
 	return accessToken, refreshToken, nil
 }
 
-func GenerateAccessToken(userID uuid.UUID, roles []string) (accessToken string, err error) {
+func GenerateAccessToken(userID, sessionID uuid.UUID, roles []string) (accessToken string, err error) {
 	now := time.Now()
 
 	accessClaims := &middlewares.Claims{
-		UserID: userID,
-		Roles:  roles,
+		UserID:    userID,
+		SessionID: sessionID,
+		Roles:     roles,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(now.Add(15 * time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
@@ -55,4 +66,87 @@ func GenerateAccessToken(userID uuid.UUID, roles []string) (accessToken string,
 func HashPassword(pw string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
 	return string(bytes), err
-}
\ No newline at end of file
+}
+
+// HashToken is used to store refresh tokens without keeping them in plain text.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+const ImpersonationTokenTTL = 10 * time.Minute
+
+// GenerateImpersonationToken issues a short-lived access token for subjectID
+// that also names the admin acting on their behalf. No refresh token is issued.
+func GenerateImpersonationToken(actorID, subjectID, sessionID uuid.UUID, roles []string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ImpersonationTokenTTL)
+
+	claims := &middlewares.Claims{
+		UserID:    subjectID,
+		SessionID: sessionID,
+		Roles:     roles,
+		ActorID:   actorID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subjectID.String(),
+			Audience:  jwt.ClaimStrings{"impersonation"},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.SecretKey))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// JSONDiff compares the JSON objects of before and after and returns the
+// changed fields as {"field": {"from": x, "to": y}}. Either side may be nil.
+func JSONDiff(before, after interface{}) (json.RawMessage, error) {
+	from, err := toJSONObject(before)
+	if err != nil {
+		return nil, err
+	}
+	to, err := toJSONObject(after)
+	if err != nil {
+		return nil, err
+	}
+
+	type change struct {
+		From interface{} `json:"from"`
+		To   interface{} `json:"to"`
+	}
+	diff := make(map[string]change)
+	for key, val := range to {
+		if old, ok := from[key]; !ok || !reflect.DeepEqual(old, val) {
+			diff[key] = change{From: from[key], To: val}
+		}
+	}
+	for key, old := range from {
+		if _, ok := to[key]; !ok {
+			diff[key] = change{From: old, To: nil}
+		}
+	}
+	if len(diff) == 0 {
+		return nil, nil
+	}
+
+	return json.Marshal(diff)
+}
+
+func toJSONObject(v interface{}) (map[string]interface{}, error) {
+	obj := make(map[string]interface{})
+	if v == nil {
+		return obj, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if string(raw) == "null" {
+		return obj, nil
+	}
+	return obj, json.Unmarshal(raw, &obj)
+}